@@ -0,0 +1,380 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.b8s.dev/rollingpin/approval"
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/promote"
+	"go.uber.org/zap"
+)
+
+// Router serves the admin API used by operators and the CLI.
+type Router struct {
+	Config   *config.Config
+	Logger   *zap.Logger
+	Pipeline *deploy.Pipeline
+	Promoter *promote.Promoter
+}
+
+// Decision is the optional body of approve and reject requests.
+type Decision struct {
+	User string `json:"user"`
+}
+
+// PauseRequest is the optional body of pause requests.
+type PauseRequest struct {
+	User   string `json:"user"`
+	Reason string `json:"reason"`
+}
+
+// RollbackRequest is the body of rollback requests. Exactly one of
+// Revision and Image must be set.
+type RollbackRequest struct {
+	// Mapping is the `namespace/deployment` to roll back.
+	Mapping  string `json:"mapping"`
+	Revision int64  `json:"revision"`
+	Image    string `json:"image"`
+	User     string `json:"user"`
+}
+
+const defaultHistoryLimit = 20
+
+func (r *Router) Mount(g *gin.RouterGroup) {
+	g.Use(r.auth())
+	g.GET("/approvals", r.listApprovals)
+	g.GET("/approvals/:id", r.getApproval)
+	g.POST("/approvals/:id/approve", r.approve)
+	g.POST("/approvals/:id/reject", r.reject)
+
+	g.GET("/jobs", r.listJobs)
+	g.GET("/jobs/:id", r.getJob)
+
+	g.GET("/history", r.history)
+	g.POST("/rollback", r.rollback)
+
+	g.GET("/pauses", r.listPauses)
+	g.POST("/mappings/:namespace/:deployment/pause", r.pause)
+	g.POST("/mappings/:namespace/:deployment/resume", r.resume)
+
+	if r.Promoter != nil {
+		g.GET("/promotions", r.listPromotions)
+		g.GET("/promotions/:name/history", r.promotionHistory)
+		g.POST("/promotions/:name/halt", r.haltPromotion)
+		g.POST("/promotions/:name/resume", r.resumePromotion)
+	}
+}
+
+func (r *Router) listApprovals(c *gin.Context) {
+	if r.Pipeline.Approvals == nil {
+		c.JSON(http.StatusOK, gin.H{"ok": true, "approvals": []*approval.Request{}})
+		return
+	}
+	list, err := r.Pipeline.Approvals.List(approval.State(c.Query("state")))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if list == nil {
+		list = []*approval.Request{}
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "approvals": list})
+}
+
+func (r *Router) getApproval(c *gin.Context) {
+	if r.Pipeline.Approvals == nil {
+		abortWithError(c, approval.ErrNotFound)
+		return
+	}
+	req, err := r.Pipeline.Approvals.Get(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "approval": req})
+}
+
+func (r *Router) approve(c *gin.Context) {
+	req, result, err := r.Pipeline.Approve(c.Param("id"), decidedBy(c))
+	if err != nil {
+		r.Logger.Info("Error while approving deploy", zap.String("approval_id", c.Param("id")), zap.Error(err))
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "approval": req, "result": result})
+}
+
+func (r *Router) reject(c *gin.Context) {
+	req, err := r.Pipeline.Reject(c.Param("id"), decidedBy(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "approval": req})
+}
+
+func (r *Router) listJobs(c *gin.Context) {
+	jobs := []*deploy.Job{}
+	if r.Pipeline.Jobs != nil {
+		namespace, deployment := parseMapping(c.Query("mapping"))
+		for _, job := range r.Pipeline.Jobs.List(namespace, deployment) {
+			if state := c.Query("state"); state == "" || string(job.State) == state {
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "jobs": jobs})
+}
+
+func (r *Router) getJob(c *gin.Context) {
+	var job *deploy.Job
+	if r.Pipeline.Jobs != nil {
+		job = r.Pipeline.Jobs.Get(c.Param("id"))
+	}
+	if job == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ok": false, "error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "job": job})
+}
+
+func (r *Router) history(c *gin.Context) {
+	m := r.findMapping(c, c.Query("mapping"))
+	if m == nil {
+		return
+	}
+	limit := defaultHistoryLimit
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	history := []*deploy.HistoryEntry{}
+	if r.Pipeline.History != nil {
+		entries, err := r.Pipeline.History.List(m.Namespace, m.DeploymentName)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		history = append(history, entries...)
+	}
+	revisions, err := r.Pipeline.Client.Revisions(m.Namespace, m.DeploymentName)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if revisions == nil {
+		revisions = []*kube.Revision{}
+	}
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	if len(revisions) > limit {
+		revisions = revisions[:limit]
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "history": history, "revisions": revisions})
+}
+
+func (r *Router) rollback(c *gin.Context) {
+	var req RollbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	m := r.findMapping(c, req.Mapping)
+	if m == nil {
+		return
+	}
+	if req.User == "" {
+		req.User = "admin"
+	}
+	result, err := r.Pipeline.Rollback(m, req.Revision, req.Image, req.User)
+	if err != nil {
+		r.Logger.Info("Error while rolling back", zap.String("mapping", req.Mapping), zap.Error(err))
+		abortWithError(c, err)
+		return
+	}
+	status := http.StatusOK
+	if result.Status == deploy.StatusAccepted {
+		status = http.StatusAccepted
+	}
+	c.JSON(status, gin.H{"ok": true, "result": result})
+}
+
+func (r *Router) listPauses(c *gin.Context) {
+	pauses := []*deploy.Pause{}
+	if r.Pipeline.Pauses != nil {
+		list, err := r.Pipeline.Pauses.List()
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		pauses = append(pauses, list...)
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "pauses": pauses})
+}
+
+func (r *Router) pause(c *gin.Context) {
+	m := r.findMapping(c, c.Param("namespace")+"/"+c.Param("deployment"))
+	if m == nil {
+		return
+	}
+	if r.Pipeline.Pauses == nil {
+		abortWithError(c, errors.New("pausing is not enabled"))
+		return
+	}
+	var req PauseRequest
+	if c.Request.ContentLength != 0 {
+		_ = c.ShouldBindJSON(&req)
+	}
+	if req.User == "" {
+		req.User = "admin"
+	}
+	pause, err := r.Pipeline.Pauses.Pause(m, req.User, req.Reason)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	r.Logger.Info("Mapping paused",
+		zap.String("namespace", m.Namespace),
+		zap.String("deployment", m.DeploymentName),
+		zap.String("by", req.User),
+		zap.String("reason", req.Reason))
+	c.JSON(http.StatusOK, gin.H{"ok": true, "pause": pause})
+}
+
+func (r *Router) resume(c *gin.Context) {
+	m := r.findMapping(c, c.Param("namespace")+"/"+c.Param("deployment"))
+	if m == nil {
+		return
+	}
+	if r.Pipeline.Pauses != nil {
+		if err := r.Pipeline.Pauses.Resume(m); err != nil {
+			abortWithError(c, err)
+			return
+		}
+	}
+	r.Logger.Info("Mapping resumed",
+		zap.String("namespace", m.Namespace),
+		zap.String("deployment", m.DeploymentName),
+		zap.String("by", decidedBy(c)))
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// findMapping looks up a mapping reference, or responds with an error and
+// returns nil if there is no such mapping. A bare deployment name has to be
+// unique across namespaces.
+func (r *Router) findMapping(c *gin.Context, mapping string) *config.ImageMapping {
+	namespace, deployment := parseMapping(mapping)
+	if namespace != "" {
+		m := config.FindMapping(r.Config, namespace, deployment)
+		if m == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ok": false, "error": "mapping not found"})
+		}
+		return m
+	}
+
+	var found *config.ImageMapping
+	mappings := r.Config.AllMappings()
+	for i := range mappings {
+		if mappings[i].DeploymentName != deployment {
+			continue
+		}
+		if found != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"ok": false, "error": fmt.Sprintf("%s is mapped in several namespaces, use namespace/%s", deployment, deployment)})
+			return nil
+		}
+		found = &mappings[i]
+	}
+	if found == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ok": false, "error": "mapping not found"})
+	}
+	return found
+}
+
+// parseMapping splits a `namespace/deployment` mapping reference. A bare
+// name matches that deployment in any namespace.
+func parseMapping(mapping string) (string, string) {
+	if i := strings.Index(mapping, "/"); i >= 0 {
+		return mapping[:i], mapping[i+1:]
+	}
+	return "", mapping
+}
+
+func (r *Router) listPromotions(c *gin.Context) {
+	chains := r.Promoter.Chains()
+	if chains == nil {
+		chains = []*promote.Chain{}
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "promotions": chains})
+}
+
+func (r *Router) promotionHistory(c *gin.Context) {
+	history, err := r.Promoter.History(c.Param("name"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "history": history})
+}
+
+func (r *Router) haltPromotion(c *gin.Context) {
+	if err := r.Promoter.Halt(c.Param("name"), decidedBy(c)); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func (r *Router) resumePromotion(c *gin.Context) {
+	if err := r.Promoter.Resume(c.Param("name"), decidedBy(c)); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// decidedBy returns the user named in the request body, falling back to
+// "admin" when the body is empty.
+func decidedBy(c *gin.Context) string {
+	var d Decision
+	if c.Request.ContentLength != 0 {
+		_ = c.ShouldBindJSON(&d)
+	}
+	if d.User == "" {
+		return "admin"
+	}
+	return d.User
+}
+
+func abortWithError(c *gin.Context, err error) {
+	status := http.StatusUnprocessableEntity
+	switch {
+	case errors.Is(err, approval.ErrNotFound), errors.Is(err, promote.ErrUnknownChain), errors.Is(err, deploy.ErrRevisionNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, approval.ErrNotPending):
+		status = http.StatusConflict
+	}
+	c.AbortWithStatusJSON(status, gin.H{"ok": false, "error": err.Error()})
+}
+
+func (r *Router) auth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		headerValue := c.Request.Header.Get("Authorization")
+		if !strings.HasPrefix(headerValue, "Bearer ") {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		token := headerValue[len("Bearer "):]
+		if subtle.ConstantTimeCompare([]byte(token), []byte(r.Config.AdminToken)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+	}
+}
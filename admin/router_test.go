@@ -0,0 +1,278 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.b8s.dev/rollingpin/approval"
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/promote"
+	"go.b8s.dev/rollingpin/state"
+	"go.uber.org/zap"
+)
+
+func testRouter(t *testing.T) (*gin.Engine, *deploy.Pipeline, kube.IClient) {
+	gin.SetMode(gin.TestMode)
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/debian:1"}},
+	})
+	conf := &config.Config{
+		AdminToken: "admin1234",
+		Mappings: []config.ImageMapping{
+			{ImageName: "library/debian", DeploymentName: "app", Namespace: "default", RequireApproval: true},
+		},
+	}
+	pipeline := &deploy.Pipeline{Config: conf, Logger: zap.NewNop(), Client: client, Approvals: approval.NewStore(0)}
+	r := gin.New()
+	(&Router{Config: conf, Logger: zap.NewNop(), Pipeline: pipeline}).Mount(r.Group("/api"))
+	return r, pipeline, client
+}
+
+func serve(r *gin.Engine, method string, path string, body string, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestAuth(t *testing.T) {
+	r, _, _ := testRouter(t)
+	if resp := serve(r, "GET", "/api/approvals", "", ""); resp.Code != http.StatusBadRequest {
+		t.Errorf("Missing token should be 400, got %d", resp.Code)
+	}
+	if resp := serve(r, "GET", "/api/approvals", "", "wrong"); resp.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong token should be 401, got %d", resp.Code)
+	}
+	if resp := serve(r, "GET", "/api/approvals", "", "admin1234"); resp.Code != http.StatusOK {
+		t.Errorf("Valid token should be 200, got %d", resp.Code)
+	}
+}
+
+func TestApproveFlow(t *testing.T) {
+	r, pipeline, client := testRouter(t)
+	result, _ := pipeline.Deploy(&deploy.Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+
+	resp := serve(r, "GET", "/api/approvals?state=pending", "", "admin1234")
+	var list struct {
+		Approvals []*approval.Request `json:"approvals"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &list)
+	if len(list.Approvals) != 1 || list.Approvals[0].ID != result.ID {
+		t.Fatalf("Expected the pending request to be listed, got %s", resp.Body.String())
+	}
+
+	resp = serve(r, "POST", "/api/approvals/"+result.ID+"/approve", `{"user": "alice"}`, "admin1234")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Approve should be 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("Approved deploy was not applied: %s", d.Containers[0].Image)
+	}
+
+	resp = serve(r, "GET", "/api/approvals/"+result.ID, "", "admin1234")
+	var got struct {
+		Approval *approval.Request `json:"approval"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &got)
+	if got.Approval.State != approval.StateApproved || got.Approval.DecidedBy != "alice" {
+		t.Errorf("Approval was not recorded: %s", resp.Body.String())
+	}
+
+	if resp := serve(r, "POST", "/api/approvals/"+result.ID+"/reject", "", "admin1234"); resp.Code != http.StatusConflict {
+		t.Errorf("Deciding twice should be 409, got %d", resp.Code)
+	}
+	if resp := serve(r, "POST", "/api/approvals/missing/approve", "", "admin1234"); resp.Code != http.StatusNotFound {
+		t.Errorf("Unknown request should be 404, got %d", resp.Code)
+	}
+}
+
+func TestReject(t *testing.T) {
+	r, pipeline, client := testRouter(t)
+	result, _ := pipeline.Deploy(&deploy.Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+
+	resp := serve(r, "POST", "/api/approvals/"+result.ID+"/reject", "", "admin1234")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Reject should be 200, got %d", resp.Code)
+	}
+	var got struct {
+		Approval *approval.Request `json:"approval"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &got)
+	if got.Approval.State != approval.StateRejected || got.Approval.DecidedBy != "admin" {
+		t.Errorf("Reject recorded the wrong decision: %s", resp.Body.String())
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Rejected deploy was applied: %s", d.Containers[0].Image)
+	}
+}
+
+func TestPromotions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client, _ := kube.NewFake()
+	conf := &config.Config{
+		AdminToken: "admin1234",
+		Mappings: []config.ImageMapping{
+			{ImageName: "library/app", DeploymentName: "app", Namespace: "staging"},
+			{ImageName: "library/app", DeploymentName: "app", Namespace: "production"},
+		},
+		Promotions: []config.Promotion{{Name: "app", Stages: []string{"staging/app", "production/app"}}},
+	}
+	pipeline := &deploy.Pipeline{Config: conf, Logger: zap.NewNop(), Client: client}
+	promoter, _ := promote.New(conf, zap.NewNop(), pipeline)
+	r := gin.New()
+	(&Router{Config: conf, Logger: zap.NewNop(), Pipeline: pipeline, Promoter: promoter}).Mount(r.Group("/api"))
+
+	if resp := serve(r, "POST", "/api/promotions/app/halt", `{"user": "alice"}`, "admin1234"); resp.Code != http.StatusOK {
+		t.Fatalf("Halt should be 200, got %d", resp.Code)
+	}
+	if chains := promoter.Chains(); !chains[0].Halted {
+		t.Errorf("Chain was not halted")
+	}
+
+	resp := serve(r, "GET", "/api/promotions/app/history", "", "admin1234")
+	var body struct {
+		History []*promote.Record `json:"history"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	if len(body.History) != 1 || body.History[0].State != promote.StateHalted || body.History[0].By != "alice" {
+		t.Errorf("History should record the halt: %s", resp.Body.String())
+	}
+
+	if resp := serve(r, "POST", "/api/promotions/missing/resume", "", "admin1234"); resp.Code != http.StatusNotFound {
+		t.Errorf("Unknown chain should be 404, got %d", resp.Code)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	r, pipeline, _ := testRouter(t)
+	pipeline.Config.Mappings[0].RequireApproval = false
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pipeline.StartJobs(ctx, deploy.NewJobQueue(config.JobsConfig{}, zap.NewNop()))
+
+	result, err := pipeline.Deploy(&deploy.Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil {
+		t.Fatalf("Deploy failed: %v", err)
+	}
+
+	resp := serve(r, "GET", "/api/jobs?mapping=default/app", "", "admin1234")
+	var list struct{ Jobs []*deploy.Job }
+	json.Unmarshal(resp.Body.Bytes(), &list)
+	if resp.Code != http.StatusOK || len(list.Jobs) != 1 || list.Jobs[0].ID != result.ID {
+		t.Fatalf("Expected the job to be listed, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := serve(r, "GET", "/api/jobs?mapping=other/app", "", "admin1234"); !bytes.Contains(resp.Body.Bytes(), []byte(`"jobs":[]`)) {
+		t.Errorf("Expected no jobs for another mapping, got %s", resp.Body.String())
+	}
+
+	resp = serve(r, "GET", "/api/jobs/"+result.ID, "", "admin1234")
+	var got struct{ Job *deploy.Job }
+	json.Unmarshal(resp.Body.Bytes(), &got)
+	if resp.Code != http.StatusOK || got.Job == nil || got.Job.Deployment != "app" {
+		t.Errorf("Expected the job, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := serve(r, "GET", "/api/jobs/missing", "", "admin1234"); resp.Code != http.StatusNotFound {
+		t.Errorf("Unknown job should be 404, got %d", resp.Code)
+	}
+}
+
+func TestHistoryAndRollback(t *testing.T) {
+	r, pipeline, client := testRouter(t)
+	pipeline.History = &deploy.History{Backend: state.NewMemory()}
+	client.(*kube.Client).CreateReplicaSet("default", "app", &kube.Revision{Number: 1, Image: "cr.b8s.dev/library/debian:1", ReplicaSet: "app-1"})
+
+	if resp := serve(r, "GET", "/api/history?mapping=default/missing", "", "admin1234"); resp.Code != http.StatusNotFound {
+		t.Errorf("Unknown mapping should be 404, got %d", resp.Code)
+	}
+
+	resp := serve(r, "POST", "/api/rollback", `{"mapping": "default/app", "image": "cr.b8s.dev/library/debian:0", "user": "alice"}`, "admin1234")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Rollback should succeed, got %d %s", resp.Code, resp.Body.String())
+	}
+	if d, _ := client.GetDeployment("default", "app"); d.Containers[0].Image != "cr.b8s.dev/library/debian:0" {
+		t.Errorf("Deployment should be rolled back, got %s", d.Containers[0].Image)
+	}
+	if resp := serve(r, "POST", "/api/rollback", `{"mapping": "default/app", "revision": 5}`, "admin1234"); resp.Code != http.StatusNotFound {
+		t.Errorf("Unknown revision should be 404, got %d", resp.Code)
+	}
+
+	resp = serve(r, "GET", "/api/history?mapping=default/app", "", "admin1234")
+	var body struct {
+		History   []*deploy.HistoryEntry
+		Revisions []*kube.Revision
+	}
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	if resp.Code != http.StatusOK || len(body.History) != 1 || body.History[0].Operator != "alice" {
+		t.Errorf("History should record the rollback, got %d %s", resp.Code, resp.Body.String())
+	}
+	if len(body.Revisions) != 1 || body.Revisions[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("History should include the revisions, got %s", resp.Body.String())
+	}
+}
+
+func TestBareMappingName(t *testing.T) {
+	r, pipeline, _ := testRouter(t)
+
+	if resp := serve(r, "GET", "/api/history?mapping=app", "", "admin1234"); resp.Code != http.StatusOK {
+		t.Errorf("Bare name should match the deployment in any namespace, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := serve(r, "GET", "/api/history?mapping=missing", "", "admin1234"); resp.Code != http.StatusNotFound {
+		t.Errorf("Unknown bare name should be 404, got %d", resp.Code)
+	}
+
+	pipeline.Config.Mappings = append(pipeline.Config.Mappings, config.ImageMapping{ImageName: "library/debian", DeploymentName: "app", Namespace: "staging"})
+	if resp := serve(r, "GET", "/api/history?mapping=app", "", "admin1234"); resp.Code != http.StatusBadRequest {
+		t.Errorf("Bare name mapped in several namespaces should be 400, got %d", resp.Code)
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	r, pipeline, _ := testRouter(t)
+	pipeline.Pauses = &deploy.Pauses{Backend: state.NewMemory()}
+
+	if resp := serve(r, "POST", "/api/mappings/default/missing/pause", "", "admin1234"); resp.Code != http.StatusNotFound {
+		t.Errorf("Unknown mapping should be 404, got %d", resp.Code)
+	}
+	resp := serve(r, "POST", "/api/mappings/default/app/pause", `{"user": "alice", "reason": "incident"}`, "admin1234")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Pause should succeed, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	result, err := pipeline.Deploy(&deploy.Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil || result.Status != deploy.StatusRejected {
+		t.Errorf("Paused mapping should reject deploys, got %+v, %v", result, err)
+	}
+
+	var body struct {
+		Pauses []*deploy.Pause
+	}
+	resp = serve(r, "GET", "/api/pauses", "", "admin1234")
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	if len(body.Pauses) != 1 || body.Pauses[0].By != "alice" || body.Pauses[0].Reason != "incident" {
+		t.Errorf("Pauses should list the paused mapping, got %s", resp.Body.String())
+	}
+
+	if resp := serve(r, "POST", "/api/mappings/default/app/resume", "", "admin1234"); resp.Code != http.StatusOK {
+		t.Fatalf("Resume should succeed, got %d", resp.Code)
+	}
+	resp = serve(r, "GET", "/api/pauses", "", "admin1234")
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	if len(body.Pauses) != 0 {
+		t.Errorf("Resumed mapping should not be listed, got %s", resp.Body.String())
+	}
+}
@@ -0,0 +1,302 @@
+package approval
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.b8s.dev/rollingpin/state"
+)
+
+// State is where a Request is in its lifecycle.
+type State string
+
+const (
+	StatePending  State = "pending"
+	StateApproved State = "approved"
+	StateRejected State = "rejected"
+	StateExpired  State = "expired"
+)
+
+const defaultRetention = 7 * 24 * time.Hour
+
+var (
+	ErrNotFound   = errors.New("approval request not found")
+	ErrNotPending = errors.New("approval request is no longer pending")
+)
+
+// Request is a deploy that is waiting for a human to approve it.
+type Request struct {
+	ID string `json:"id"`
+
+	// Namespace and Deployment identify the mapping the deploy is for.
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+	ImageName  string `json:"image_name"`
+
+	Provider string `json:"provider"`
+	Tag      string `json:"tag,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+	ImageURL string `json:"image_url"`
+
+	// EventID and OccurAt are the ID the provider gave the event and when it
+	// says the event happened, so that the approved deploy is ordered like
+	// the original event.
+	EventID string    `json:"event_id,omitempty"`
+	OccurAt time.Time `json:"occur_at,omitempty"`
+
+	// JobID is the job that is waiting on the request, if the deploy came
+	// through the job queue.
+	JobID string `json:"job_id,omitempty"`
+
+	State     State      `json:"state"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	DecidedBy string     `json:"decided_by,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+// Store holds approval requests in memory.
+type Store struct {
+	// Expiry is how long a request may stay pending before it expires. Zero
+	// means requests never expire.
+	Expiry time.Duration
+
+	// Retention is how long a decided or expired request is kept before
+	// Prune deletes it. Defaults to a week.
+	Retention time.Duration
+
+	// Backend, if set, persists requests so that they can be restored with
+	// Load after a restart.
+	Backend state.Store
+
+	mu       sync.Mutex
+	requests map[string]*Request
+	now      func() time.Time
+}
+
+func NewStore(expiry time.Duration) *Store {
+	return &Store{Expiry: expiry, requests: map[string]*Request{}, now: time.Now}
+}
+
+// Create assigns an ID to a new pending request and stores it.
+func (s *Store) Create(r *Request) (*Request, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	r.ID = id
+	r.State = StatePending
+	r.CreatedAt = now
+	if s.Expiry > 0 {
+		expiresAt := now.Add(s.Expiry)
+		r.ExpiresAt = &expiresAt
+	}
+	if err := s.persist(r); err != nil {
+		return nil, err
+	}
+	s.requests[id] = r
+	copied := *r
+	return &copied, nil
+}
+
+// Get returns a copy of a request.
+func (s *Store) Get(id string) (*Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sync(); err != nil {
+		return nil, err
+	}
+	r, ok := s.requests[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *r
+	return &copied, nil
+}
+
+// List returns copies of all requests in the given state, or every request
+// if state is empty, oldest first.
+func (s *Store) List(state State) ([]*Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sync(); err != nil {
+		return nil, err
+	}
+	var list []*Request
+	for _, r := range s.requests {
+		if state == "" || r.State == state {
+			copied := *r
+			list = append(list, &copied)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+// Decide moves a pending request to the approved or rejected state. Only
+// one decision is made for a request, so approving it claims it: a request
+// that is approved twice at once is only applied once.
+func (s *Store) Decide(id string, state State, by string) (*Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sync(); err != nil {
+		return nil, err
+	}
+	r, ok := s.requests[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if r.State != StatePending {
+		return nil, ErrNotPending
+	}
+	now := s.now()
+	decided := *r
+	decided.State = state
+	decided.DecidedBy = by
+	decided.DecidedAt = &now
+	if err := s.persist(&decided); err != nil {
+		return nil, err
+	}
+	*r = decided
+	return &decided, nil
+}
+
+// Reopen moves an approved request back to pending, such as when the
+// approved deploy couldn't be applied, so that it can be approved again.
+func (s *Store) Reopen(id string) (*Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	r, ok := s.requests[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if r.State != StateApproved {
+		return nil, fmt.Errorf("approval request is %s, not approved", r.State)
+	}
+	reopened := *r
+	reopened.State = StatePending
+	reopened.DecidedBy = ""
+	reopened.DecidedAt = nil
+	if err := s.persist(&reopened); err != nil {
+		return nil, err
+	}
+	*r = reopened
+	return &reopened, nil
+}
+
+// Prune deletes requests that were decided or expired longer than Retention
+// ago.
+func (s *Store) Prune() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sync(); err != nil {
+		return err
+	}
+	retention := s.Retention
+	if retention <= 0 {
+		retention = defaultRetention
+	}
+	cutoff := s.now().Add(-retention)
+	for id, r := range s.requests {
+		if r.State == StatePending || r.DecidedAt == nil || r.DecidedAt.After(cutoff) {
+			continue
+		}
+		if s.Backend != nil {
+			if err := s.Backend.Delete(state.BucketApprovals, id); err != nil && !errors.Is(err, state.ErrNotFound) {
+				return err
+			}
+		}
+		delete(s.requests, id)
+	}
+	return nil
+}
+
+// Load restores the requests saved in the backend, such as after a
+// restart.
+func (s *Store) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refresh()
+}
+
+// refresh reads the requests saved in the backend, which other replicas
+// may have created or decided. The caller must hold the lock.
+func (s *Store) refresh() error {
+	if s.Backend == nil {
+		return nil
+	}
+	saved, err := s.Backend.List(state.BucketApprovals)
+	if err != nil {
+		return err
+	}
+	for _, b := range saved {
+		r := &Request{}
+		if err := json.Unmarshal(b, r); err != nil {
+			return err
+		}
+		s.requests[r.ID] = r
+	}
+	return nil
+}
+
+// persist writes a request to the backend.
+func (s *Store) persist(r *Request) error {
+	if s.Backend == nil {
+		return nil
+	}
+	return state.PutJSON(s.Backend, state.BucketApprovals, r.ID, r)
+}
+
+// sync reads the requests saved in the backend and expires overdue ones.
+// The caller must hold the lock.
+func (s *Store) sync() error {
+	if err := s.refresh(); err != nil {
+		return err
+	}
+	return s.expire()
+}
+
+// expire marks overdue pending requests as expired, as of when they expired,
+// and saves them. The caller must hold the lock.
+func (s *Store) expire() error {
+	now := s.now()
+	for _, r := range s.requests {
+		if r.State != StatePending || r.ExpiresAt == nil || now.Before(*r.ExpiresAt) {
+			continue
+		}
+		expired := *r
+		expired.State = StateExpired
+		expiredAt := *r.ExpiresAt
+		expired.DecidedAt = &expiredAt
+		if err := s.persist(&expired); err != nil {
+			return err
+		}
+		*r = expired
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
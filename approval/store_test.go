@@ -0,0 +1,155 @@
+package approval
+
+import (
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/state"
+)
+
+func TestStoreLifecycle(t *testing.T) {
+	s := NewStore(0)
+	r, err := s.Create(&Request{Namespace: "default", Deployment: "app", ImageURL: "debian:2"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if r.ID == "" || r.State != StatePending {
+		t.Errorf("Create should assign an ID and pending state: %+v", r)
+	}
+	if list, _ := s.List(StatePending); len(list) != 1 {
+		t.Errorf("List should return the pending request, got %d", len(list))
+	}
+
+	decided, err := s.Decide(r.ID, StateApproved, "alice")
+	if err != nil {
+		t.Fatalf("Decide failed: %v", err)
+	}
+	if decided.State != StateApproved || decided.DecidedBy != "alice" || decided.DecidedAt == nil {
+		t.Errorf("Decide did not record the decision: %+v", decided)
+	}
+	if _, err := s.Decide(r.ID, StateRejected, "bob"); err != ErrNotPending {
+		t.Errorf("Deciding twice should fail with ErrNotPending, got: %v", err)
+	}
+	if _, err := s.Decide("nope", StateApproved, "alice"); err != ErrNotFound {
+		t.Errorf("Deciding a missing request should fail with ErrNotFound, got: %v", err)
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	now := time.Unix(1586922308, 0)
+	s := NewStore(time.Hour)
+	s.now = func() time.Time { return now }
+
+	r, _ := s.Create(&Request{Namespace: "default", Deployment: "app"})
+	if r.ExpiresAt == nil || !r.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Create should set ExpiresAt: %v", r.ExpiresAt)
+	}
+
+	now = now.Add(2 * time.Hour)
+	got, _ := s.Get(r.ID)
+	if got.State != StateExpired {
+		t.Errorf("Request should have expired: %s", got.State)
+	}
+	if _, err := s.Decide(r.ID, StateApproved, "alice"); err != ErrNotPending {
+		t.Errorf("Expired requests should not be approvable, got: %v", err)
+	}
+}
+
+func TestStoreExpiryPersisted(t *testing.T) {
+	now := time.Unix(1586922308, 0)
+	backend := state.NewMemory()
+	s := NewStore(time.Hour)
+	s.Backend = backend
+	s.now = func() time.Time { return now }
+	r, _ := s.Create(&Request{Namespace: "default", Deployment: "app"})
+
+	now = now.Add(2 * time.Hour)
+	s.List("")
+	saved := &Request{}
+	if err := state.GetJSON(backend, state.BucketApprovals, r.ID, saved); err != nil || saved.State != StateExpired {
+		t.Errorf("Expiry should be saved to the backend, got %+v, %v", saved, err)
+	}
+	if saved.DecidedAt == nil || !saved.DecidedAt.Equal(*r.ExpiresAt) {
+		t.Errorf("Expired request should record when it expired, got %v", saved.DecidedAt)
+	}
+}
+
+func TestStoreReopen(t *testing.T) {
+	s := NewStore(0)
+	r, _ := s.Create(&Request{Namespace: "default", Deployment: "app"})
+	if _, err := s.Reopen(r.ID); err == nil {
+		t.Errorf("Reopening a pending request should fail")
+	}
+	s.Decide(r.ID, StateApproved, "alice")
+	reopened, err := s.Reopen(r.ID)
+	if err != nil || reopened.State != StatePending || reopened.DecidedBy != "" {
+		t.Errorf("Reopen should make the request pending again, got %+v, %v", reopened, err)
+	}
+	if _, err := s.Decide(r.ID, StateApproved, "bob"); err != nil {
+		t.Errorf("Reopened request should be approvable, got: %v", err)
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	now := time.Unix(1586922308, 0)
+	backend := state.NewMemory()
+	s := NewStore(0)
+	s.Backend = backend
+	s.Retention = time.Hour
+	s.now = func() time.Time { return now }
+	decided, _ := s.Create(&Request{Namespace: "default", Deployment: "app"})
+	s.Decide(decided.ID, StateRejected, "bob")
+	pending, _ := s.Create(&Request{Namespace: "default", Deployment: "app"})
+
+	now = now.Add(2 * time.Hour)
+	if err := s.Prune(); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if _, err := s.Get(decided.ID); err != ErrNotFound {
+		t.Errorf("Old decided request should be pruned, got: %v", err)
+	}
+	if _, err := backend.Get(state.BucketApprovals, decided.ID); err == nil {
+		t.Errorf("Pruned request should be deleted from the backend")
+	}
+	if _, err := s.Get(pending.ID); err != nil {
+		t.Errorf("Pending request should be kept, got: %v", err)
+	}
+}
+
+func TestStoreLoad(t *testing.T) {
+	backend := state.NewMemory()
+	s := NewStore(0)
+	s.Backend = backend
+	pending, _ := s.Create(&Request{Namespace: "default", Deployment: "app", ImageURL: "debian:2"})
+	decided, _ := s.Create(&Request{Namespace: "default", Deployment: "app", ImageURL: "debian:3"})
+	s.Decide(decided.ID, StateRejected, "alice")
+
+	restored := NewStore(0)
+	restored.Backend = backend
+	if err := restored.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if r, err := restored.Get(pending.ID); err != nil || r.State != StatePending {
+		t.Errorf("Pending request should be restored: %+v %v", r, err)
+	}
+	if r, err := restored.Get(decided.ID); err != nil || r.State != StateRejected || r.DecidedBy != "alice" {
+		t.Errorf("Decided request should be restored: %+v %v", r, err)
+	}
+}
+
+func TestStoreSharedBackend(t *testing.T) {
+	backend := state.NewMemory()
+	leader, follower := NewStore(0), NewStore(0)
+	leader.Backend, follower.Backend = backend, backend
+
+	r, _ := leader.Create(&Request{Namespace: "default", Deployment: "app", ImageURL: "debian:2"})
+	if list, err := follower.List(StatePending); err != nil || len(list) != 1 {
+		t.Fatalf("Follower should see the leader's request: %v %v", list, err)
+	}
+	if _, err := follower.Decide(r.ID, StateApproved, "alice"); err != nil {
+		t.Fatalf("Follower should be able to decide the request: %v", err)
+	}
+	if got, _ := leader.Get(r.ID); got.State != StateApproved {
+		t.Errorf("Leader should see the follower's decision, got %s", got.State)
+	}
+}
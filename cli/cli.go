@@ -0,0 +1,521 @@
+// Package cli implements the rollingpin subcommands that talk to a running
+// server's admin API.
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"go.b8s.dev/rollingpin/approval"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/promote"
+)
+
+// Client calls the admin API.
+type Client struct {
+	Server string
+	Token  string
+	HTTP   *http.Client
+}
+
+// Run executes a subcommand. args excludes the program name.
+func Run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: rollingpin <command> [arguments]")
+	}
+	switch args[0] {
+	case "approvals":
+		return runApprovals(args[1:], stdout)
+	case "promotions":
+		return runPromotions(args[1:], stdout)
+	case "jobs":
+		return runJobs(args[1:], stdout)
+	case "history":
+		return runHistory(args[1:], stdout)
+	case "rollback":
+		return runRollback(args[1:], stdout)
+	case "mappings":
+		return runMappings(args[1:], stdout)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// IsCommand reports whether the argument names a subcommand rather than
+// being a flag for the server.
+func IsCommand(arg string) bool {
+	switch arg {
+	case "approvals", "promotions", "jobs", "history", "rollback", "mappings":
+		return true
+	}
+	return false
+}
+
+// clientFlags registers the flags every subcommand needs to reach the admin
+// API.
+func clientFlags(fs *flag.FlagSet) (server *string, token *string, user *string) {
+	server = fs.String("server", envOr("ROLLINGPIN_SERVER", "http://localhost:8080"), "Address of the rollingpin server.")
+	token = fs.String("token", os.Getenv("ROLLINGPIN_ADMIN_TOKEN"), "Admin API token.")
+	user = fs.String("user", envOr("USER", "admin"), "Name recorded against decisions.")
+	return server, token, user
+}
+
+func runApprovals(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("approvals", flag.ContinueOnError)
+	server, token, user := clientFlags(fs)
+	state := fs.String("state", "pending", "Only list requests in this state; empty lists all.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	client := &Client{Server: *server, Token: *token, HTTP: http.DefaultClient}
+
+	rest := fs.Args()
+	if len(rest) == 0 {
+		return fmt.Errorf("usage: rollingpin approvals list|approve ID|reject ID")
+	}
+	switch rest[0] {
+	case "list":
+		list, err := client.ListApprovals(approval.State(*state))
+		if err != nil {
+			return err
+		}
+		printApprovals(stdout, list)
+		return nil
+	case "approve", "reject":
+		if len(rest) != 2 {
+			return fmt.Errorf("usage: rollingpin approvals %s ID", rest[0])
+		}
+		req, err := client.Decide(rest[1], rest[0], *user)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s %s by %s\n", req.ID, req.State, req.DecidedBy)
+		return nil
+	default:
+		return fmt.Errorf("unknown approvals command %q", rest[0])
+	}
+}
+
+func runPromotions(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("promotions", flag.ContinueOnError)
+	server, token, user := clientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	client := &Client{Server: *server, Token: *token, HTTP: http.DefaultClient}
+
+	rest := fs.Args()
+	if len(rest) == 0 {
+		return fmt.Errorf("usage: rollingpin promotions list|history NAME|halt NAME|resume NAME")
+	}
+	if rest[0] == "list" {
+		chains, err := client.ListPromotions()
+		if err != nil {
+			return err
+		}
+		printPromotions(stdout, chains)
+		return nil
+	}
+	if len(rest) != 2 {
+		return fmt.Errorf("usage: rollingpin promotions %s NAME", rest[0])
+	}
+	switch rest[0] {
+	case "history":
+		history, err := client.PromotionHistory(rest[1])
+		if err != nil {
+			return err
+		}
+		printHistory(stdout, history)
+		return nil
+	case "halt", "resume":
+		if err := client.SetPromotionHalted(rest[1], rest[0] == "halt", *user); err != nil {
+			return err
+		}
+		state := "resumed"
+		if rest[0] == "halt" {
+			state = "halted"
+		}
+		fmt.Fprintf(stdout, "%s %s\n", rest[1], state)
+		return nil
+	default:
+		return fmt.Errorf("unknown promotions command %q", rest[0])
+	}
+}
+
+func runJobs(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("jobs", flag.ContinueOnError)
+	server, token, _ := clientFlags(fs)
+	mapping := fs.String("mapping", "", "Only list jobs for this mapping, as namespace/deployment.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	client := &Client{Server: *server, Token: *token, HTTP: http.DefaultClient}
+
+	rest := fs.Args()
+	switch {
+	case len(rest) == 1 && rest[0] == "list":
+		jobs, err := client.ListJobs(*mapping)
+		if err != nil {
+			return err
+		}
+		printJobs(stdout, jobs)
+		return nil
+	case len(rest) == 2 && rest[0] == "get":
+		job, err := client.GetJob(rest[1])
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(job)
+	default:
+		return fmt.Errorf("usage: rollingpin jobs list|get ID")
+	}
+}
+
+func runHistory(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	server, token, _ := clientFlags(fs)
+	limit := fs.Int("limit", 20, "How many deploys and revisions to list.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: rollingpin history NAMESPACE/DEPLOYMENT")
+	}
+	client := &Client{Server: *server, Token: *token, HTTP: http.DefaultClient}
+	history, revisions, err := client.DeployHistory(fs.Arg(0), *limit)
+	if err != nil {
+		return err
+	}
+	printDeployHistory(stdout, history, revisions)
+	return nil
+}
+
+func runRollback(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	server, token, user := clientFlags(fs)
+	revision := fs.Int64("revision", 0, "Revision to roll back to, as listed by `rollingpin history`.")
+	image := fs.String("image", "", "Image to roll back to.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || (*revision == 0) == (*image == "") {
+		return fmt.Errorf("usage: rollingpin rollback --revision N|--image IMAGE NAMESPACE/DEPLOYMENT")
+	}
+	client := &Client{Server: *server, Token: *token, HTTP: http.DefaultClient}
+	result, err := client.Rollback(fs.Arg(0), *revision, *image, *user)
+	if err != nil {
+		return err
+	}
+	if result.Status == deploy.StatusAccepted {
+		fmt.Fprintf(stdout, "%s rollback queued as job %s\n", fs.Arg(0), result.ID)
+		return nil
+	}
+	fmt.Fprintf(stdout, "%s rolled back to %s\n", fs.Arg(0), result.Image)
+	return nil
+}
+
+func runMappings(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("mappings", flag.ContinueOnError)
+	server, token, user := clientFlags(fs)
+	reason := fs.String("reason", "", "Why the mapping is being paused.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	client := &Client{Server: *server, Token: *token, HTTP: http.DefaultClient}
+
+	rest := fs.Args()
+	switch {
+	case len(rest) == 1 && rest[0] == "paused":
+		pauses, err := client.ListPauses()
+		if err != nil {
+			return err
+		}
+		printPauses(stdout, pauses)
+		return nil
+	case len(rest) == 2 && rest[0] == "pause":
+		if err := client.SetPaused(rest[1], true, *user, *reason); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s paused\n", rest[1])
+		return nil
+	case len(rest) == 2 && rest[0] == "resume":
+		if err := client.SetPaused(rest[1], false, *user, ""); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s resumed\n", rest[1])
+		return nil
+	default:
+		return fmt.Errorf("usage: rollingpin mappings paused|pause NAMESPACE/DEPLOYMENT|resume NAMESPACE/DEPLOYMENT")
+	}
+}
+
+// ListApprovals returns approval requests in the given state.
+func (c *Client) ListApprovals(state approval.State) ([]*approval.Request, error) {
+	var body struct {
+		Approvals []*approval.Request `json:"approvals"`
+	}
+	path := "/api/approvals"
+	if state != "" {
+		path += "?state=" + string(state)
+	}
+	if err := c.do(http.MethodGet, path, nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Approvals, nil
+}
+
+// Decide approves or rejects a request. action is "approve" or "reject".
+func (c *Client) Decide(id string, action string, user string) (*approval.Request, error) {
+	var body struct {
+		Approval *approval.Request `json:"approval"`
+	}
+	payload, err := json.Marshal(map[string]string{"user": user})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.do(http.MethodPost, "/api/approvals/"+id+"/"+action, payload, &body); err != nil {
+		return nil, err
+	}
+	return body.Approval, nil
+}
+
+// ListPromotions returns the state of every promotion chain.
+func (c *Client) ListPromotions() ([]*promote.Chain, error) {
+	var body struct {
+		Promotions []*promote.Chain `json:"promotions"`
+	}
+	if err := c.do(http.MethodGet, "/api/promotions", nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Promotions, nil
+}
+
+// PromotionHistory returns a promotion chain's history.
+func (c *Client) PromotionHistory(name string) ([]*promote.Record, error) {
+	var body struct {
+		History []*promote.Record `json:"history"`
+	}
+	if err := c.do(http.MethodGet, "/api/promotions/"+name+"/history", nil, &body); err != nil {
+		return nil, err
+	}
+	return body.History, nil
+}
+
+// SetPromotionHalted halts or resumes a promotion chain.
+func (c *Client) SetPromotionHalted(name string, halted bool, user string) error {
+	action := "resume"
+	if halted {
+		action = "halt"
+	}
+	payload, err := json.Marshal(map[string]string{"user": user})
+	if err != nil {
+		return err
+	}
+	return c.do(http.MethodPost, "/api/promotions/"+name+"/"+action, payload, &struct{}{})
+}
+
+// ListJobs returns the jobs for a mapping, or all jobs if mapping is empty.
+func (c *Client) ListJobs(mapping string) ([]*deploy.Job, error) {
+	var body struct {
+		Jobs []*deploy.Job `json:"jobs"`
+	}
+	path := "/api/jobs"
+	if mapping != "" {
+		path += "?mapping=" + url.QueryEscape(mapping)
+	}
+	if err := c.do(http.MethodGet, path, nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Jobs, nil
+}
+
+// GetJob returns a single job.
+func (c *Client) GetJob(id string) (*deploy.Job, error) {
+	var body struct {
+		Job *deploy.Job `json:"job"`
+	}
+	if err := c.do(http.MethodGet, "/api/jobs/"+id, nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Job, nil
+}
+
+// DeployHistory returns a mapping's recent deploys and its deployment's
+// revisions, newest first.
+func (c *Client) DeployHistory(mapping string, limit int) ([]*deploy.HistoryEntry, []*kube.Revision, error) {
+	var body struct {
+		History   []*deploy.HistoryEntry `json:"history"`
+		Revisions []*kube.Revision       `json:"revisions"`
+	}
+	path := fmt.Sprintf("/api/history?mapping=%s&limit=%d", url.QueryEscape(mapping), limit)
+	if err := c.do(http.MethodGet, path, nil, &body); err != nil {
+		return nil, nil, err
+	}
+	return body.History, body.Revisions, nil
+}
+
+// Rollback rolls a mapping back to a revision or image.
+func (c *Client) Rollback(mapping string, revision int64, image string, user string) (*deploy.Result, error) {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"mapping":  mapping,
+		"revision": revision,
+		"image":    image,
+		"user":     user,
+	})
+	var body struct {
+		Result *deploy.Result `json:"result"`
+	}
+	if err := c.do(http.MethodPost, "/api/rollback", payload, &body); err != nil {
+		return nil, err
+	}
+	return body.Result, nil
+}
+
+// ListPauses returns the mappings paused through the admin API.
+func (c *Client) ListPauses() ([]*deploy.Pause, error) {
+	var body struct {
+		Pauses []*deploy.Pause `json:"pauses"`
+	}
+	if err := c.do(http.MethodGet, "/api/pauses", nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Pauses, nil
+}
+
+// SetPaused pauses or resumes a `namespace/deployment` mapping.
+func (c *Client) SetPaused(mapping string, paused bool, user string, reason string) error {
+	if !strings.Contains(mapping, "/") {
+		return fmt.Errorf("mapping %q should be NAMESPACE/DEPLOYMENT", mapping)
+	}
+	action := "resume"
+	if paused {
+		action = "pause"
+	}
+	payload, err := json.Marshal(map[string]string{"user": user, "reason": reason})
+	if err != nil {
+		return err
+	}
+	return c.do(http.MethodPost, "/api/mappings/"+mapping+"/"+action, payload, &struct{}{})
+}
+
+func (c *Client) do(method string, path string, payload []byte, out interface{}) error {
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.Server, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var body struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		if body.Error != "" {
+			return fmt.Errorf("%s %s: %s (%s)", method, path, body.Error, resp.Status)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func printApprovals(w io.Writer, list []*approval.Request) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tDEPLOYMENT\tIMAGE\tCREATED")
+	for _, r := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s/%s\t%s\t%s\n",
+			r.ID, r.State, r.Namespace, r.Deployment, r.ImageURL, r.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+	tw.Flush()
+}
+
+func printJobs(w io.Writer, jobs []*deploy.Job) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tDEPLOYMENT\tPROVIDER\tIMAGE\tCREATED\tERROR")
+	for _, j := range jobs {
+		image := j.NewImage
+		if image == "" {
+			image = j.Event.ImageURL
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s/%s\t%s\t%s\t%s\t%s\n",
+			j.ID, j.State, j.Namespace, j.Deployment, j.Provider, image, j.CreatedAt.Format("2006-01-02 15:04:05"), j.Error)
+	}
+	tw.Flush()
+}
+
+func printDeployHistory(w io.Writer, history []*deploy.HistoryEntry, revisions []*kube.Revision) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEPLOYED\tIMAGE\tPROVIDER\tBY")
+	for _, e := range history {
+		by := e.Operator
+		if e.ApprovedBy != "" {
+			by = e.ApprovedBy
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.DeployedAt.Format("2006-01-02 15:04:05"), e.Image, e.Provider, by)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REVISION\tIMAGE\tCREATED")
+	for _, r := range revisions {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", r.Number, r.Image, r.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+	tw.Flush()
+}
+
+func printPauses(w io.Writer, pauses []*deploy.Pause) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEPLOYMENT\tBY\tPAUSED\tREASON")
+	for _, p := range pauses {
+		fmt.Fprintf(tw, "%s/%s\t%s\t%s\t%s\n", p.Namespace, p.Deployment, p.By, p.PausedAt.Format("2006-01-02 15:04:05"), p.Reason)
+	}
+	tw.Flush()
+}
+
+func printPromotions(w io.Writer, chains []*promote.Chain) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTAGES\tSOAK\tHALTED")
+	for _, c := range chains {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\n", c.Name, strings.Join(c.Stages, " -> "), c.Soak, c.Halted)
+	}
+	tw.Flush()
+}
+
+func printHistory(w io.Writer, history []*promote.Record) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSTATE\tFROM\tTO\tIMAGE\tREASON")
+	for _, r := range history {
+		reason := r.Reason
+		if r.By != "" {
+			reason = "by " + r.By
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.At.Format("2006-01-02 15:04:05"), r.State, r.From, r.To, r.Image, reason)
+	}
+	tw.Flush()
+}
+
+func envOr(name string, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/approval"
+	"go.b8s.dev/rollingpin/deploy"
+)
+
+func fakeServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer admin1234" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/approvals":
+			if r.URL.Query().Get("state") != "pending" {
+				t.Errorf("Expected state filter, got %q", r.URL.RawQuery)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"ok": true,
+				"approvals": []*approval.Request{{
+					ID: "abc123", State: approval.StatePending, Namespace: "default", Deployment: "app",
+					ImageURL: "cr.b8s.dev/library/debian:2", CreatedAt: time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC),
+				}},
+			})
+		case r.Method == "POST" && r.URL.Path == "/api/approvals/abc123/approve":
+			var d struct{ User string }
+			json.NewDecoder(r.Body).Decode(&d)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"ok":       true,
+				"approval": &approval.Request{ID: "abc123", State: approval.StateApproved, DecidedBy: d.User},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "approval request not found"})
+		}
+	}))
+}
+
+func TestApprovalsList(t *testing.T) {
+	server := fakeServer(t)
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := Run([]string{"approvals", "--server", server.URL, "--token", "admin1234", "list"}, &out)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "abc123") || !strings.Contains(out.String(), "default/app") {
+		t.Errorf("list output is missing the request:\n%s", out.String())
+	}
+}
+
+func TestApprovalsApprove(t *testing.T) {
+	server := fakeServer(t)
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := Run([]string{"approvals", "--server", server.URL, "--token", "admin1234", "--user", "alice", "approve", "abc123"}, &out)
+	if err != nil {
+		t.Fatalf("approve failed: %v", err)
+	}
+	if strings.TrimSpace(out.String()) != "abc123 approved by alice" {
+		t.Errorf("Unexpected approve output: %q", out.String())
+	}
+
+	err = Run([]string{"approvals", "--server", server.URL, "--token", "admin1234", "reject", "missing"}, &out)
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("reject of unknown request should fail with the server error, got %v", err)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	if err := Run([]string{"approvals", "frobnicate"}, &bytes.Buffer{}); err == nil {
+		t.Errorf("Unknown subcommand should fail")
+	}
+}
+
+func TestPromotionsHalt(t *testing.T) {
+	var halted string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/promotions/app/halt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var d struct{ User string }
+		json.NewDecoder(r.Body).Decode(&d)
+		halted = d.User
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := Run([]string{"promotions", "--server", server.URL, "--user", "alice", "halt", "app"}, &out)
+	if err != nil {
+		t.Fatalf("halt failed: %v", err)
+	}
+	if halted != "alice" || strings.TrimSpace(out.String()) != "app halted" {
+		t.Errorf("Unexpected halt: user %q, output %q", halted, out.String())
+	}
+}
+
+func TestJobsList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/jobs" || r.URL.Query().Get("mapping") != "default/app" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok": true,
+			"jobs": []*deploy.Job{{
+				ID: "job1", State: deploy.JobRollingOut, Namespace: "default", Deployment: "app", Provider: "harbor",
+				NewImage: "cr.b8s.dev/library/debian:2", CreatedAt: time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	if err := Run([]string{"jobs", "--server", server.URL, "--mapping", "default/app", "list"}, &out); err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "job1") || !strings.Contains(out.String(), "rolling-out") || !strings.Contains(out.String(), "debian:2") {
+		t.Errorf("Unexpected list output: %q", out.String())
+	}
+}
+
+func TestRollback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Mapping  string
+			Revision int64
+			User     string
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.Method != "POST" || r.URL.Path != "/api/rollback" || req.Mapping != "default/app" || req.Revision != 2 || req.User != "alice" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":     true,
+			"result": &deploy.Result{Status: deploy.StatusDeployed, Image: "cr.b8s.dev/library/debian:2"},
+		})
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := Run([]string{"rollback", "--server", server.URL, "--user", "alice", "--revision", "2", "default/app"}, &out)
+	if err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	if strings.TrimSpace(out.String()) != "default/app rolled back to cr.b8s.dev/library/debian:2" {
+		t.Errorf("Unexpected rollback output: %q", out.String())
+	}
+	if err := Run([]string{"rollback", "default/app"}, &out); err == nil {
+		t.Errorf("rollback without a revision or image should fail")
+	}
+}
+
+func TestMappingsPause(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			User   string
+			Reason string
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.Method != "POST" || r.URL.Path != "/api/mappings/default/app/pause" || req.User != "alice" || req.Reason != "incident" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true})
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := Run([]string{"mappings", "--server", server.URL, "--user", "alice", "--reason", "incident", "pause", "default/app"}, &out)
+	if err != nil {
+		t.Fatalf("mappings pause failed: %v", err)
+	}
+	if strings.TrimSpace(out.String()) != "default/app paused" {
+		t.Errorf("Unexpected pause output: %q", out.String())
+	}
+	if err := Run([]string{"mappings", "--server", server.URL, "pause", "app"}, &out); err == nil {
+		t.Errorf("pause without a namespace should fail")
+	}
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"time"
+)
+
+// CanaryConfig rolls new images out to a separate `<deployment>-canary`
+// Deployment first. The canary shares the primary's pod labels, so a Service
+// selecting the primary's pods sends it a share of traffic in proportion to
+// its replicas.
+type CanaryConfig struct {
+	// Steps are run in order. Each scales the canary to its replica count and
+	// holds while the canary's pods are checked.
+	Steps []CanaryStep `yaml:"steps"`
+
+	// MaxRestarts is how many container restarts across the canary's pods
+	// are tolerated before it is aborted. Defaults to 0.
+	MaxRestarts int32 `yaml:"max_restarts"`
+
+	// Interval is how often the canary's pods are checked during a step.
+	// Defaults to 10s.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// CanaryStep is one stage of a canary rollout.
+type CanaryStep struct {
+	// Replicas is how many canary pods to run. Defaults to 1.
+	Replicas int32 `yaml:"replicas"`
+
+	// Hold is how long the step lasts. Every canary pod must be ready by the
+	// end of it for the canary to continue. Defaults to 5m.
+	Hold time.Duration `yaml:"hold"`
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// DebounceConfig collects the events for a mapping over a window and deploys
+// only one of them, so that several tags pushed in quick succession cause a
+// single rollout.
+type DebounceConfig struct {
+	// Window starts with the first event and lasts this long. Events that
+	// arrive during it are coalesced.
+	Window time.Duration `yaml:"window"`
+
+	// Pick is which event is deployed when the window closes: `last` (the
+	// default) or `highest`, the highest-ranked tag under the mapping's tag
+	// policy ordering. Tags that rank equally, or that the policy cannot
+	// order, go to the later event.
+	Pick string `yaml:"pick"`
+}
+
+// validate checks which event the debounce picks.
+func (dc *DebounceConfig) validate() error {
+	if dc == nil {
+		return nil
+	}
+	switch dc.Pick {
+	case "", "last", "highest":
+	default:
+		return fmt.Errorf("pick must be last or highest, not %q", dc.Pick)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"time"
+)
+
+// DiscoveryConfig configures building mappings from annotations on
+// Deployments, alongside the static `mappings`.
+type DiscoveryConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Namespaces are the only namespaces searched for annotated
+	// Deployments. At least one is required.
+	Namespaces []string `yaml:"namespaces"`
+
+	// Providers are the providers discovered mappings accept events from,
+	// unless a Deployment lists its own.
+	Providers []string `yaml:"providers"`
+
+	// ResyncPeriod is how often every Deployment is looked at again, on top
+	// of watching for changes. Defaults to 10m.
+	ResyncPeriod time.Duration `yaml:"resync_period"`
+}
+
+// Sources of mappings besides the config file, in order of precedence.
+const (
+	SourceResources   = "resources"
+	SourceAnnotations = "annotations"
+)
+
+var sources = []string{SourceResources, SourceAnnotations}
+
+// SetDiscovered replaces the mappings found by one of the sources.
+func (c *Config) SetDiscovered(source string, mappings []ImageMapping) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.discovered == nil {
+		c.discovered = map[string][]ImageMapping{}
+	}
+	c.discovered[source] = mappings
+}
+
+// AllMappings returns the static mappings followed by the discovered ones.
+// Static mappings take precedence over discovered mappings for the same
+// deployment, and mapping resources over annotations.
+func (c *Config) AllMappings() []ImageMapping {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.discovered) == 0 {
+		return c.Mappings
+	}
+	mappings := make([]ImageMapping, len(c.Mappings))
+	copy(mappings, c.Mappings)
+	for _, source := range sources {
+		for _, m := range c.discovered[source] {
+			if !hasMapping(mappings, m.Namespace, m.DeploymentName) {
+				mappings = append(mappings, m)
+			}
+		}
+	}
+	return mappings
+}
+
+func hasMapping(mappings []ImageMapping, namespace string, deployment string) bool {
+	for _, m := range mappings {
+		if m.Namespace == namespace && m.DeploymentName == deployment {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// FreezeConfig describes when automatic deploys are and are not allowed.
+type FreezeConfig struct {
+	// Mode is what happens to events that arrive during a freeze: `reject`
+	// (the default) drops them, `queue` holds the latest one per mapping and
+	// applies it once the freeze ends.
+	Mode string `yaml:"mode"`
+
+	// Timezone applies to any window that does not set its own. Defaults to
+	// UTC.
+	Timezone string `yaml:"timezone"`
+
+	// Allowed windows, if any are given, are the only times deploys may
+	// happen. A mapping's allowed windows replace the global ones.
+	Allowed []FreezeWindow `yaml:"allowed"`
+
+	// Blocked windows are times deploys may not happen. A mapping's blocked
+	// windows are added to the global ones.
+	Blocked []FreezeWindow `yaml:"blocked"`
+}
+
+// FreezeWindow is either a recurring window, opened by a cron Schedule and
+// lasting for Duration, or a one-off window between Start and End.
+type FreezeWindow struct {
+	Name string `yaml:"name"`
+
+	// Schedule is a standard five-field cron expression, e.g. `0 17 * * 5`
+	// for Fridays at 17:00.
+	Schedule string        `yaml:"schedule"`
+	Duration time.Duration `yaml:"duration"`
+
+	Start time.Time `yaml:"start"`
+	End   time.Time `yaml:"end"`
+
+	Timezone string `yaml:"timezone"`
+}
+
+// ParseSchedule checks the window and returns its cron schedule, in its own
+// timezone or else the one given. A one-off window has a nil schedule.
+func (w FreezeWindow) ParseSchedule(timezone string) (cron.Schedule, error) {
+	if w.Timezone != "" {
+		timezone = w.Timezone
+	}
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("window %q: unknown timezone %q", w.Name, timezone)
+		}
+	}
+	if w.Schedule == "" {
+		if w.Start.IsZero() || !w.End.After(w.Start) {
+			return nil, fmt.Errorf("window %q: needs a schedule, or a start before its end", w.Name)
+		}
+		return nil, nil
+	}
+	if w.Duration <= 0 {
+		return nil, fmt.Errorf("window %q: a schedule needs a positive duration", w.Name)
+	}
+	spec := w.Schedule
+	if timezone != "" {
+		spec = "CRON_TZ=" + timezone + " " + spec
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("window %q: %w", w.Name, err)
+	}
+	return schedule, nil
+}
+
+// validate checks the freeze's mode, timezone and windows.
+func (fc *FreezeConfig) validate() error {
+	if fc == nil {
+		return nil
+	}
+	switch fc.Mode {
+	case "", "reject", "queue":
+	default:
+		return fmt.Errorf("unknown freeze mode %q", fc.Mode)
+	}
+	if fc.Timezone != "" {
+		if _, err := time.LoadLocation(fc.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", fc.Timezone)
+		}
+	}
+	for _, windows := range [][]FreezeWindow{fc.Allowed, fc.Blocked} {
+		for _, w := range windows {
+			if _, err := w.ParseSchedule(fc.Timezone); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
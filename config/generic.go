@@ -0,0 +1,61 @@
+package config
+
+// GenericConfig configures the `generic` provider, which accepts arbitrary
+// JSON webhooks and extracts the image details using jq expressions.
+type GenericConfig struct {
+	Routes []GenericRoute `yaml:"routes"`
+}
+
+// GenericRoute describes a single webhook endpoint for the `generic`
+// provider. Each of the expression fields is a jq program evaluated against
+// the decoded request body.
+type GenericRoute struct {
+	// Name identifies the route in logs.
+	Name string `yaml:"name"`
+
+	// Path is mounted beneath `/webhooks/generic/`.
+	Path string `yaml:"path"`
+
+	Auth GenericAuth `yaml:"auth"`
+
+	// Filter is an optional expression; webhooks for which it does not
+	// evaluate to `true` are acknowledged but otherwise ignored.
+	Filter string `yaml:"filter"`
+
+	Repository string `yaml:"repository"`
+	Tag        string `yaml:"tag"`
+	Digest     string `yaml:"digest"`
+
+	// Image is an optional expression producing the full image reference. If
+	// it is not set, the reference is built from Registry, Repository, and
+	// either Tag or Digest.
+	Image    string `yaml:"image"`
+	Registry string `yaml:"registry"`
+
+	// Operator is an optional expression naming who pushed the image.
+	Operator string `yaml:"operator"`
+
+	// ID is an optional expression producing a unique ID for each delivery,
+	// so that redelivered webhooks are only applied once.
+	ID string `yaml:"id"`
+
+	// Labels are optional expressions whose results are attached to the
+	// event under the given names, for use in mapping policies.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// GenericAuth describes how requests to a generic route are authenticated.
+type GenericAuth struct {
+	// Method is one of `bearer` (the default), `header`, `hmac-sha256`, or
+	// `none`.
+	Method string `yaml:"method"`
+
+	// Header is the request header containing the token or signature. It is
+	// required for the `header` and `hmac-sha256` methods, and ignored for
+	// `bearer`.
+	Header string `yaml:"header"`
+
+	// Token is the shared secret for this route. If empty, the global
+	// AuthToken is used.
+	Token string `yaml:"token"`
+}
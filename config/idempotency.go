@@ -0,0 +1,13 @@
+package config
+
+import (
+	"time"
+)
+
+// IdempotencyConfig configures duplicate event suppression.
+type IdempotencyConfig struct {
+	// TTL is how long an event is remembered, so that a duplicate delivered
+	// within it gets the original result instead of being applied again.
+	// Defaults to 24h.
+	TTL time.Duration `yaml:"ttl"`
+}
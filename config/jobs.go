@@ -0,0 +1,28 @@
+package config
+
+import (
+	"time"
+)
+
+// JobsConfig configures the queue that deploys are run on in the background.
+type JobsConfig struct {
+	// Workers is how many jobs run at once. Jobs for the same deployment
+	// always run one at a time. Defaults to 4.
+	Workers int `yaml:"workers"`
+
+	// MaxAttempts is how many times a job is tried before it fails.
+	// Defaults to 5.
+	MaxAttempts int `yaml:"max_attempts"`
+
+	// Backoff is the delay before the first retry, doubling for each retry
+	// after that up to MaxBackoff. Defaults to 1s and 1m.
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+
+	// Retention is how long finished jobs are kept. Defaults to 24h.
+	Retention time.Duration `yaml:"retention"`
+
+	// PollInterval is how often the state store is checked for jobs queued
+	// by other replicas, when leader election is enabled. Defaults to 2s.
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"time"
+)
+
+// LeaderElectionConfig configures leader election between replicas. Every
+// replica accepts webhooks, but only the leader applies deploys; the others
+// queue them as jobs in the shared state store for the leader to pick up.
+type LeaderElectionConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// LeaseName is the name of the coordination.k8s.io Lease used as the
+	// lock. Defaults to `rollingpin`.
+	LeaseName string `yaml:"lease_name"`
+
+	// Namespace holds the Lease. Defaults to the namespace rollingpin runs
+	// in.
+	Namespace string `yaml:"namespace"`
+
+	// LeaseDuration is how long followers wait before taking over from a
+	// leader that stopped renewing the lease, RenewDeadline is how long the
+	// leader keeps trying to renew it before giving up, and RetryPeriod is
+	// how often every replica tries. Default to 15s, 10s and 2s.
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+	RenewDeadline time.Duration `yaml:"renew_deadline"`
+	RetryPeriod   time.Duration `yaml:"retry_period"`
+}
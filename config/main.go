@@ -1,8 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"sync"
+	"time"
 
+	"go.b8s.dev/rollingpin/policy"
 	"gopkg.in/yaml.v2"
 )
 
@@ -11,16 +15,217 @@ type Config struct {
 	// to ensure requests are from a legitimate source.
 	AuthToken string `yaml:"auth_token"`
 
+	// AdminToken is a bearer token for the admin API. The admin API is not
+	// served unless it is set.
+	AdminToken string `yaml:"admin_token"`
+
 	Mappings []ImageMapping `yaml:"mappings"`
+
+	// Generic holds the declarative webhook routes served by the `generic`
+	// provider.
+	Generic GenericConfig `yaml:"generic"`
+
+	Harbor HarborConfig `yaml:"harbor"`
+
+	Notifications NotificationConfig `yaml:"notifications"`
+
+	// Registries holds connection details for registries rollingpin queries
+	// directly, such as to resolve a tag to a digest.
+	Registries []RegistryConfig `yaml:"registries"`
+
+	// Freeze blocks automatic deploys for every mapping during the
+	// configured windows.
+	Freeze *FreezeConfig `yaml:"freeze"`
+
+	Approvals ApprovalConfig `yaml:"approvals"`
+
+	Promotions []Promotion   `yaml:"promotions"`
+	Rollouts   RolloutConfig `yaml:"rollouts"`
+
+	Jobs JobsConfig `yaml:"jobs"`
+
+	State StateConfig `yaml:"state"`
+
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Replay      ReplayConfig      `yaml:"replay"`
+
+	LeaderElection LeaderElectionConfig `yaml:"leader_election"`
+
+	Discovery DiscoveryConfig `yaml:"discovery"`
+	Resources ResourcesConfig `yaml:"resources"`
+
+	// discovered holds the mappings found outside the config file, by
+	// source. See AllMappings.
+	mu         sync.RWMutex
+	discovered map[string][]ImageMapping
+}
+
+// ApprovalConfig configures the manual approval gate.
+type ApprovalConfig struct {
+	// Expiry is how long a deploy may wait for approval before it expires.
+	// Zero means pending deploys never expire.
+	Expiry time.Duration `yaml:"expiry"`
+
+	// Retention is how long approved, rejected and expired requests are kept.
+	// Defaults to a week.
+	Retention time.Duration `yaml:"retention"`
+}
+
+// RegistryConfig holds credentials for a registry, keyed by its host as it
+// appears in image references.
+type RegistryConfig struct {
+	Host     string `yaml:"host"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// Insecure uses plain HTTP rather than HTTPS.
+	Insecure bool `yaml:"insecure"`
+}
+
+// NotificationConfig describes where operational notifications, such as an
+// in-use image being deleted, are sent.
+type NotificationConfig struct {
+	// WebhookURL receives each notification as a JSON POST.
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+// HarborConfig holds settings specific to the `harbor` provider.
+type HarborConfig struct {
+	// ScanTimeout is how long a push deferred by a mapping's WaitForScan is
+	// held waiting for the SCANNING_COMPLETED event before it is dropped.
+	// Defaults to 30 minutes.
+	ScanTimeout time.Duration `yaml:"scan_timeout"`
 }
 
 // ImageMapping correlates a container image name to a Kubernetes deployment
 // and namespace, so we know what to update for a given image.
 type ImageMapping struct {
-	ImageName      string   `yaml:"image"`
-	DeploymentName string   `yaml:"deployment"`
-	Namespace      string   `yaml:"namespace"`
-	Providers      []string `yaml:"providers"`
+	ImageName      string `yaml:"image"`
+	DeploymentName string `yaml:"deployment"`
+	Namespace      string `yaml:"namespace"`
+	// Providers are the providers the mapping accepts events from. A mapping
+	// without any accepts events from every provider.
+	Providers []string `yaml:"providers"`
+
+	// Container is the name of the container whose image is updated.
+	// Defaults to the first container.
+	Container string `yaml:"container"`
+
+	// WaitForScan defers Harbor pushes until Harbor reports that the
+	// vulnerability scan of the pushed digest has completed.
+	WaitForScan bool `yaml:"wait_for_scan"`
+
+	// SeverityThreshold blocks deploys of scanned images whose most severe
+	// vulnerability is at or above this level (one of Negligible, Low,
+	// Medium, High or Critical). Only applies when WaitForScan is set.
+	SeverityThreshold string `yaml:"severity_threshold"`
+
+	// ReplicationTarget is the registry host Harbor replicates this image to.
+	// When set, Harbor pushes are ignored and the deployment is instead
+	// updated from the target registry once a REPLICATION event reports the
+	// copy succeeded. It cannot be combined with WaitForScan.
+	ReplicationTarget string `yaml:"replication_target"`
+
+	// PinDigest deploys images by digest (`repo@sha256:...`) rather than by
+	// tag, so that rescheduled pods cannot pull a different image. The tag is
+	// recorded in the `rollingpin.b8s.dev/tag` annotation on the deployment.
+	PinDigest bool `yaml:"pin_digest"`
+
+	// OnUnchanged controls what happens when an event's image is identical
+	// to the one already running, as when a mutable tag like `latest` is
+	// re-pushed. `restart` bumps a pod template annotation to roll out new
+	// pods, like `kubectl rollout restart`; `digest` switches the deployment
+	// to the new digest. By default nothing happens.
+	OnUnchanged string `yaml:"on_unchanged"`
+
+	// RequireApproval makes events for this mapping create a pending
+	// approval request instead of deploying. The deploy happens once the
+	// request is approved through the admin API.
+	RequireApproval bool `yaml:"require_approval"`
+
+	// RollbackOnFailure puts the deployment back to its previous image if
+	// the rollout of a new one fails or times out.
+	RollbackOnFailure bool `yaml:"rollback_on_failure"`
+
+	// Debounce coalesces events that arrive close together.
+	Debounce *DebounceConfig `yaml:"debounce"`
+
+	// Canary deploys new images to a canary Deployment and only updates this
+	// one if the canary stays healthy.
+	Canary *CanaryConfig `yaml:"canary"`
+
+	// Freeze adds windows for this mapping on top of the global ones.
+	Freeze *FreezeConfig `yaml:"freeze"`
+
+	// Policies are CEL expressions that must all be true for an event to be
+	// deployed. They can refer to `event` (repository, tag, digest, image,
+	// operator, provider, labels and occur_at) and `workload`, the
+	// deployment's current state (name, namespace, image, tag, annotations,
+	// replicas and available_replicas).
+	Policies []string `yaml:"policies"`
+
+	// TagPolicy restricts which tags may be deployed. If nil, any tag is.
+	TagPolicy *TagPolicy `yaml:"tag_policy"`
+
+	// Poll configures the `poll` provider for registries that cannot send
+	// webhooks.
+	Poll *PollConfig `yaml:"poll"`
+}
+
+// TagPolicy restricts which pushed tags a mapping will deploy.
+type TagPolicy struct {
+	// Semver is a semantic version constraint such as `~1.4` or
+	// `>=2.0.0 <3`. Tags that are not valid versions are rejected.
+	Semver string `yaml:"semver"`
+
+	// Prerelease allows pre-release versions such as `1.5.0-rc.1`, which are
+	// matched against Semver as though they were the release they precede.
+	Prerelease bool `yaml:"prerelease"`
+
+	// Pattern is a regular expression that tags must match in full. Its
+	// named capture groups may be referenced by OrderBy.
+	Pattern string `yaml:"pattern"`
+
+	// Glob is a shell-style pattern, such as `main-*`, that tags must match.
+	Glob string `yaml:"glob"`
+
+	// Order is how tags are ranked against each other: `semver`, `numeric`,
+	// `lexical` or `timestamp`. Defaults to `semver` if Semver is set.
+	Order string `yaml:"order"`
+
+	// OrderBy names the capture group in Pattern whose value is ranked
+	// instead of the whole tag.
+	OrderBy string `yaml:"order_by"`
+
+	// TimestampLayout is the Go time layout used by the `timestamp` order.
+	// Defaults to `2006.01.02`.
+	TimestampLayout string `yaml:"timestamp_layout"`
+
+	// NoDowngrade rejects tags that sort below the tag currently running.
+	// It needs an order to sort them by.
+	NoDowngrade bool `yaml:"no_downgrade"`
+
+	compiled *CompiledTagPolicy
+}
+
+// PollConfig describes how to poll a registry for new images of a mapping.
+// If Tag is set, the poller watches that tag for a new digest; otherwise it
+// watches the repository for newly pushed tags.
+type PollConfig struct {
+	// Registry is the registry host, or a full URL to use plain HTTP.
+	Registry string `yaml:"registry"`
+
+	// Repository is the repository name in the registry. Defaults to the
+	// mapping's image name.
+	Repository string `yaml:"repository"`
+
+	Tag string `yaml:"tag"`
+
+	// Interval between polls. Defaults to five minutes.
+	Interval time.Duration `yaml:"interval"`
+
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
 }
 
 func LoadConfig(configPath string) (*Config, error) {
@@ -36,12 +241,95 @@ func LoadConfig(configPath string) (*Config, error) {
 	if err := d.Decode(&config); err != nil {
 		return nil, err
 	}
+	if err := validate(config); err != nil {
+		return nil, err
+	}
 
 	return config, nil
 }
 
+// validate catches configuration that can be checked before any events
+// arrive, such as expressions that do not compile.
+func validate(config *Config) error {
+	for _, m := range config.Mappings {
+		if _, err := policy.Compile(m.Policies); err != nil {
+			return fmt.Errorf("mapping %s/%s: %w", m.Namespace, m.DeploymentName, err)
+		}
+		if _, err := m.TagPolicy.Compile(); err != nil {
+			return fmt.Errorf("mapping %s/%s: tag_policy: %w", m.Namespace, m.DeploymentName, err)
+		}
+		switch m.OnUnchanged {
+		case "", "restart", "digest":
+		default:
+			return fmt.Errorf("mapping %s/%s: on_unchanged must be restart or digest, not %q", m.Namespace, m.DeploymentName, m.OnUnchanged)
+		}
+		if err := m.Freeze.validate(); err != nil {
+			return fmt.Errorf("mapping %s/%s: freeze: %w", m.Namespace, m.DeploymentName, err)
+		}
+		if err := m.Debounce.validate(); err != nil {
+			return fmt.Errorf("mapping %s/%s: debounce: %w", m.Namespace, m.DeploymentName, err)
+		}
+		if m.WaitForScan && m.ReplicationTarget != "" {
+			// Replicated images are deployed as soon as the copy succeeds,
+			// whatever their scan says.
+			return fmt.Errorf("mapping %s/%s: wait_for_scan cannot be combined with replication_target", m.Namespace, m.DeploymentName)
+		}
+	}
+	if err := config.Freeze.validate(); err != nil {
+		return fmt.Errorf("freeze: %w", err)
+	}
+	if config.LeaderElection.Enabled && config.State.Backend != "kubernetes" {
+		return fmt.Errorf("leader election needs the kubernetes state backend, so that replicas share their jobs")
+	}
+	if config.Discovery.Enabled && len(config.Discovery.Namespaces) == 0 {
+		return fmt.Errorf("discovery needs at least one namespace to watch")
+	}
+	return nil
+}
+
+// FindMapping returns the mapping for a deployment, static or discovered, or
+// nil if there is none.
+func FindMapping(config *Config, namespace string, deployment string) *ImageMapping {
+	mappings := config.AllMappings()
+	for i := range mappings {
+		m := &mappings[i]
+		if m.Namespace == namespace && m.DeploymentName == deployment {
+			return m
+		}
+	}
+	return nil
+}
+
+// AcceptsProvider reports whether the mapping deploys events from the given
+// provider.
+func (m *ImageMapping) AcceptsProvider(provider string) bool {
+	if len(m.Providers) == 0 {
+		return true
+	}
+	for _, p := range m.Providers {
+		if p == provider {
+			return true
+		}
+	}
+	return false
+}
+
 // ProviderEnabled returns true if the given provider is listed in the config.
 func ProviderEnabled(config *Config, provider string) bool {
+	if config.Discovery.Enabled {
+		for _, p := range config.Discovery.Providers {
+			if p == provider {
+				return true
+			}
+		}
+	}
+	if config.Resources.Enabled {
+		for _, p := range config.Resources.Providers {
+			if p == provider {
+				return true
+			}
+		}
+	}
 	for _, p := range config.Mappings {
 		for _, p2 := range p.Providers {
 			if p2 == provider {
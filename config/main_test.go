@@ -1,7 +1,9 @@
 package config
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadConfigValid(t *testing.T) {
@@ -22,4 +24,148 @@ func TestLoadConfigValid(t *testing.T) {
 	if config.Mappings[0].Namespace != "default" {
 		t.Errorf("LoadConfig parsed Mapping.Namespace incorrectly. Got: %v", config.Mappings[0].Namespace)
 	}
+	if len(config.Mappings[0].Policies) != 1 {
+		t.Errorf("LoadConfig parsed Mapping.Policies incorrectly. Got: %v", config.Mappings[0].Policies)
+	}
+	if config.Generic.Routes[0].Repository != ".image.name" {
+		t.Errorf("LoadConfig parsed GenericRoute.Repository incorrectly. Got: %v", config.Generic.Routes[0].Repository)
+	}
+	if config.Freeze.Blocked[0].Duration != 63*time.Hour {
+		t.Errorf("LoadConfig parsed FreezeWindow.Duration incorrectly. Got: %v", config.Freeze.Blocked[0].Duration)
+	}
+	if config.Freeze.Blocked[1].Start.Month() != time.December {
+		t.Errorf("LoadConfig parsed FreezeWindow.Start incorrectly. Got: %v", config.Freeze.Blocked[1].Start)
+	}
+}
+
+func TestLoadConfigInvalidPolicy(t *testing.T) {
+	_, err := LoadConfig("fixtures/config.invalid-policy.yaml")
+	if err == nil || !strings.Contains(err.Error(), "mapping default/abc") {
+		t.Errorf("LoadConfig should reject policies that do not compile, got: %v", err)
+	}
+}
+
+func TestValidateLeaderElection(t *testing.T) {
+	conf := &Config{LeaderElection: LeaderElectionConfig{Enabled: true}}
+	if err := validate(conf); err == nil {
+		t.Errorf("Leader election without a shared state backend should be rejected")
+	}
+	conf.State.Backend = "kubernetes"
+	if err := validate(conf); err != nil {
+		t.Errorf("Leader election with the kubernetes backend should be accepted, got: %v", err)
+	}
+}
+
+func TestValidateDiscovery(t *testing.T) {
+	conf := &Config{Discovery: DiscoveryConfig{Enabled: true}}
+	if err := validate(conf); err == nil {
+		t.Errorf("Discovery without any namespaces should be rejected")
+	}
+	conf.Discovery.Namespaces = []string{"team-a"}
+	if err := validate(conf); err != nil {
+		t.Errorf("Discovery restricted to namespaces should be accepted, got: %v", err)
+	}
+}
+
+func TestValidateTagPolicy(t *testing.T) {
+	conf := &Config{Mappings: []ImageMapping{{
+		Namespace:      "default",
+		DeploymentName: "app",
+		TagPolicy:      &TagPolicy{Semver: "~1.4 <<"},
+	}}}
+	if err := validate(conf); err == nil || !strings.Contains(err.Error(), "tag_policy") {
+		t.Errorf("An invalid semver constraint should be rejected, got: %v", err)
+	}
+	conf.Mappings[0].TagPolicy = &TagPolicy{Pattern: "v(?P<build>[0-9]+)", OrderBy: "build", Order: "numeric"}
+	if err := validate(conf); err != nil {
+		t.Errorf("A valid tag policy should be accepted, got: %v", err)
+	}
+	conf.Mappings[0].TagPolicy = &TagPolicy{Order: "newest"}
+	if err := validate(conf); err == nil {
+		t.Errorf("An unknown tag order should be rejected")
+	}
+	conf.Mappings[0].TagPolicy = &TagPolicy{Glob: "main-*", NoDowngrade: true}
+	if err := validate(conf); err == nil {
+		t.Errorf("no_downgrade without an order should be rejected")
+	}
+	conf.Mappings[0].TagPolicy = &TagPolicy{Semver: ">=1", NoDowngrade: true}
+	if err := validate(conf); err != nil {
+		t.Errorf("no_downgrade with a semver constraint should be accepted, got: %v", err)
+	}
+}
+
+func TestValidateFreeze(t *testing.T) {
+	conf := &Config{Freeze: &FreezeConfig{
+		Timezone: "Europe/Berlin",
+		Blocked:  []FreezeWindow{{Name: "weekend", Schedule: "0 17 * * 5", Duration: 64 * time.Hour}},
+	}}
+	if err := validate(conf); err != nil {
+		t.Errorf("A valid freeze should be accepted, got: %v", err)
+	}
+	conf.Freeze.Timezone = "Mars/Olympus_Mons"
+	if err := validate(conf); err == nil {
+		t.Errorf("An unknown timezone should be rejected")
+	}
+	conf.Freeze.Timezone = ""
+	conf.Freeze.Blocked[0].Schedule = "every friday"
+	if err := validate(conf); err == nil {
+		t.Errorf("An invalid schedule should be rejected")
+	}
+	conf.Freeze = nil
+	conf.Mappings = []ImageMapping{{Namespace: "default", DeploymentName: "app", Freeze: &FreezeConfig{Mode: "hold"}}}
+	if err := validate(conf); err == nil || !strings.Contains(err.Error(), "mapping default/app") {
+		t.Errorf("A mapping's unknown freeze mode should be rejected, got: %v", err)
+	}
+}
+
+func TestValidateOnUnchanged(t *testing.T) {
+	conf := &Config{Mappings: []ImageMapping{{Namespace: "default", DeploymentName: "app", OnUnchanged: "digest"}}}
+	if err := validate(conf); err != nil {
+		t.Errorf("on_unchanged digest should be accepted, got: %v", err)
+	}
+	conf.Mappings[0].OnUnchanged = "redeploy"
+	if err := validate(conf); err == nil {
+		t.Errorf("An unknown on_unchanged mode should be rejected")
+	}
+}
+
+func TestValidateReplicationWithScan(t *testing.T) {
+	conf := &Config{Mappings: []ImageMapping{{Namespace: "default", DeploymentName: "app", ReplicationTarget: "mirror.b8s.dev"}}}
+	if err := validate(conf); err != nil {
+		t.Errorf("replication_target alone should be accepted, got: %v", err)
+	}
+	conf.Mappings[0].WaitForScan = true
+	if err := validate(conf); err == nil {
+		t.Errorf("wait_for_scan with replication_target should be rejected")
+	}
+}
+
+func TestTagPolicyCompileCached(t *testing.T) {
+	p := &TagPolicy{Semver: "~1.4"}
+	first, err := p.Compile()
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if second, _ := p.Compile(); second != first {
+		t.Errorf("Compiling an unchanged policy again should reuse the result")
+	}
+	p.Semver = "~2"
+	if changed, _ := p.Compile(); changed == first || changed.Semver != "~2" {
+		t.Errorf("Compiling a changed policy should not reuse the old result")
+	}
+}
+
+func TestValidateDebounce(t *testing.T) {
+	conf := &Config{Mappings: []ImageMapping{{
+		Namespace:      "default",
+		DeploymentName: "app",
+		Debounce:       &DebounceConfig{Window: time.Minute, Pick: "highest"},
+	}}}
+	if err := validate(conf); err != nil {
+		t.Errorf("A valid debounce should be accepted, got: %v", err)
+	}
+	conf.Mappings[0].Debounce.Pick = "newest"
+	if err := validate(conf); err == nil || !strings.Contains(err.Error(), "debounce") {
+		t.Errorf("An unknown debounce pick should be rejected, got: %v", err)
+	}
 }
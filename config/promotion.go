@@ -0,0 +1,30 @@
+package config
+
+import (
+	"time"
+)
+
+// Promotion chains mappings together so that an image which rolls out
+// successfully at one stage is deployed to the next, e.g. staging then
+// production.
+type Promotion struct {
+	Name string `yaml:"name"`
+
+	// Stages are mappings in promotion order, each given as
+	// `namespace/deployment`. Only the first stage needs to be deployed by a
+	// provider; later stages receive the same image from the stage before.
+	Stages []string `yaml:"stages"`
+
+	// Soak is how long an image must have been rolled out at a stage before
+	// it is promoted to the next.
+	Soak time.Duration `yaml:"soak"`
+}
+
+// RolloutConfig configures how deployments are watched after an update.
+type RolloutConfig struct {
+	// Interval is how often deployment status is checked. Defaults to 5s.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout is how long a rollout may take before it is considered
+	// failed. Defaults to 10m.
+	Timeout time.Duration `yaml:"timeout"`
+}
@@ -0,0 +1,14 @@
+package config
+
+import (
+	"time"
+)
+
+// ReplayConfig configures protection against old events being replayed,
+// such as a delayed webhook retry. It only applies to events that say when
+// they happened, like Harbor's.
+type ReplayConfig struct {
+	// MaxAge skips events that happened longer ago than this. Zero means
+	// events may be any age.
+	MaxAge time.Duration `yaml:"max_age"`
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"time"
+)
+
+// ResourcesConfig configures reading mappings from RollingpinMapping custom
+// resources, alongside the static `mappings`.
+type ResourcesConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Namespaces are the only namespaces RollingpinMappings are read from.
+	// Empty means every namespace.
+	Namespaces []string `yaml:"namespaces"`
+
+	// Providers are the providers mappings accept events from, unless a
+	// RollingpinMapping lists its own.
+	Providers []string `yaml:"providers"`
+
+	// ResyncPeriod is how often every RollingpinMapping is looked at again,
+	// on top of watching for changes. Defaults to 10m.
+	ResyncPeriod time.Duration `yaml:"resync_period"`
+}
@@ -0,0 +1,21 @@
+package config
+
+// StateConfig configures where rollingpin keeps state that should survive a
+// restart, such as queued jobs, deploy history and pending approvals.
+type StateConfig struct {
+	// Backend is `memory` (the default), which keeps nothing across
+	// restarts, `file` or `kubernetes`.
+	Backend string `yaml:"backend"`
+
+	// Path is the database file used by the `file` backend.
+	Path string `yaml:"path"`
+
+	// Namespace holds the ConfigMaps used by the `kubernetes` backend.
+	// Defaults to the namespace rollingpin runs in.
+	Namespace string `yaml:"namespace"`
+
+	// Prefix is prepended to the names of the ConfigMaps used by the
+	// `kubernetes` backend, and must be a valid DNS label. Defaults to
+	// `rollingpin-state`.
+	Prefix string `yaml:"prefix"`
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// compileMu guards the compiled policy cached on each TagPolicy.
+var compileMu sync.Mutex
+
+// CompiledTagPolicy is a TagPolicy with its patterns compiled, and Order
+// set to the order actually used.
+type CompiledTagPolicy struct {
+	TagPolicy
+	Constraint *semver.Constraints
+	Regexp     *regexp.Regexp
+
+	// source is the policy as it was compiled.
+	source TagPolicy
+}
+
+// Compile validates and compiles the policy. A nil policy yields nil. The
+// result is kept on the policy, since a mapping's policy is consulted for
+// every event and every comparison when tags are sorted, and is dropped
+// along with the mapping it belongs to.
+func (p *TagPolicy) Compile() (*CompiledTagPolicy, error) {
+	if p == nil {
+		return nil, nil
+	}
+	compileMu.Lock()
+	defer compileMu.Unlock()
+	policy := *p
+	policy.compiled = nil
+	// The policy may have been changed since it was compiled.
+	if p.compiled != nil && p.compiled.source == policy {
+		return p.compiled, nil
+	}
+	tp := &CompiledTagPolicy{TagPolicy: policy, source: policy}
+	if p.Semver != "" {
+		constraint, err := semver.NewConstraint(p.Semver)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semver constraint %q: %w", p.Semver, err)
+		}
+		tp.Constraint = constraint
+		if tp.Order == "" {
+			tp.Order = "semver"
+		}
+	}
+	if p.Pattern != "" {
+		pattern, err := regexp.Compile("^(?:" + p.Pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag pattern %q: %w", p.Pattern, err)
+		}
+		tp.Regexp = pattern
+	}
+	if p.Glob != "" {
+		if _, err := path.Match(p.Glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid tag glob %q: %w", p.Glob, err)
+		}
+	}
+	if p.OrderBy != "" && (tp.Regexp == nil || tp.Regexp.SubexpIndex(p.OrderBy) < 0) {
+		return nil, fmt.Errorf("order_by %q is not a named group in the tag pattern", p.OrderBy)
+	}
+	switch tp.Order {
+	case "", "semver", "numeric", "lexical", "timestamp":
+	default:
+		return nil, fmt.Errorf("unknown tag order %q", tp.Order)
+	}
+	if p.NoDowngrade && tp.Order == "" {
+		// Without an order every tag compares as equal, so nothing would
+		// ever count as a downgrade.
+		return nil, fmt.Errorf("no_downgrade needs an order, or a semver constraint")
+	}
+	p.compiled = tp
+	return tp, nil
+}
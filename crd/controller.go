@@ -0,0 +1,347 @@
+package crd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+const defaultResyncPeriod = 10 * time.Minute
+
+// Controller watches RollingpinMappings, validates them, and keeps the
+// config's mappings and the resources' statuses up to date.
+type Controller struct {
+	Client dynamic.Interface
+	Config *config.Config
+	Logger *zap.Logger
+
+	// rebuilding makes informers for different namespaces take turns.
+	rebuilding sync.Mutex
+
+	mu      sync.Mutex
+	listers []cache.GenericLister
+	// owners maps each `namespace/deployment` to the name of the valid
+	// RollingpinMapping it came from.
+	owners map[string]string
+	// results holds the status changes from events that are waiting to be
+	// written, by resource, and wake tells the writer there are some.
+	results map[resourceKey][]func(*MappingStatus)
+	wake    chan struct{}
+	now     func() time.Time
+}
+
+// resourceKey identifies a RollingpinMapping.
+type resourceKey struct {
+	namespace string
+	name      string
+}
+
+// Start begins watching RollingpinMappings until ctx is done. It returns once
+// the resources that already exist are in use.
+func (c *Controller) Start(ctx context.Context) error {
+	conf := c.Config.Resources
+	resync := conf.ResyncPeriod
+	if resync <= 0 {
+		resync = defaultResyncPeriod
+	}
+	namespaces := conf.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { c.rebuild(ctx) },
+		UpdateFunc: func(old interface{}, new interface{}) {
+			if specChanged(old, new) {
+				c.rebuild(ctx)
+			}
+		},
+		DeleteFunc: func(interface{}) { c.rebuild(ctx) },
+	}
+	var factories []dynamicinformer.DynamicSharedInformerFactory
+	var synced []cache.InformerSynced
+	c.mu.Lock()
+	c.results = map[resourceKey][]func(*MappingStatus){}
+	c.wake = make(chan struct{}, 1)
+	for _, ns := range namespaces {
+		factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.Client, resync, ns, nil)
+		resources := factory.ForResource(GroupVersionResource)
+		informer := resources.Informer()
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			c.mu.Unlock()
+			return err
+		}
+		c.listers = append(c.listers, resources.Lister())
+		synced = append(synced, informer.HasSynced)
+		factories = append(factories, factory)
+	}
+	c.mu.Unlock()
+
+	for _, factory := range factories {
+		factory.Start(ctx.Done())
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return errors.New("crd: timed out waiting for mappings to sync")
+	}
+	go c.writeResults(ctx)
+	c.rebuild(ctx)
+	return nil
+}
+
+// specChanged reports whether an update to a resource changed more than its
+// status, which the controller writes itself.
+func specChanged(old interface{}, new interface{}) bool {
+	a, okA := old.(*unstructured.Unstructured)
+	b, okB := new.(*unstructured.Unstructured)
+	if !okA || !okB {
+		return true
+	}
+	return a.GetGeneration() != b.GetGeneration() || !equality.Semantic.DeepEqual(a.Object["spec"], b.Object["spec"])
+}
+
+// rebuild replaces the config's resource mappings with the valid
+// RollingpinMappings in the informers' caches, and records on each resource
+// whether it is in use.
+func (c *Controller) rebuild(ctx context.Context) {
+	c.rebuilding.Lock()
+	defer c.rebuilding.Unlock()
+	resources, err := c.list()
+	if err != nil {
+		c.Logger.Warn("Error while listing RollingpinMappings", zap.Error(err))
+		return
+	}
+	// The oldest resource for a deployment wins.
+	sort.Slice(resources, func(i, j int) bool {
+		a, b := resources[i], resources[j]
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+	})
+
+	var mappings []config.ImageMapping
+	owners := map[string]string{}
+	for _, r := range resources {
+		err := r.Validate()
+		key := r.Namespace + "/" + r.Spec.Deployment
+		if err == nil {
+			if owner, ok := owners[key]; ok {
+				err = fmt.Errorf("deployment %s is already mapped by %s", r.Spec.Deployment, owner)
+			} else if c.staticMapping(r.Namespace, r.Spec.Deployment) != nil {
+				err = fmt.Errorf("deployment %s is already mapped in the config file", r.Spec.Deployment)
+			}
+		}
+		if err == nil {
+			owners[key] = r.Name
+			mappings = append(mappings, r.Mapping(c.Config.Resources.Providers))
+		}
+		c.observe(ctx, r, err)
+	}
+
+	c.mu.Lock()
+	c.owners = owners
+	c.mu.Unlock()
+	c.Config.SetDiscovered(config.SourceResources, mappings)
+}
+
+func (c *Controller) list() ([]*RollingpinMapping, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var resources []*RollingpinMapping
+	for _, lister := range c.listers {
+		objects, err := lister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objects {
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			r, err := fromUnstructured(u)
+			if err != nil {
+				c.Logger.Warn("Ignoring malformed RollingpinMapping",
+					zap.String("namespace", u.GetNamespace()),
+					zap.String("name", u.GetName()),
+					zap.Error(err))
+				continue
+			}
+			resources = append(resources, r)
+		}
+	}
+	return resources, nil
+}
+
+func (c *Controller) staticMapping(namespace string, deployment string) *config.ImageMapping {
+	for i := range c.Config.Mappings {
+		m := &c.Config.Mappings[i]
+		if m.Namespace == namespace && m.DeploymentName == deployment {
+			return m
+		}
+	}
+	return nil
+}
+
+// observe records whether a resource is in use in its status, if that has
+// changed.
+func (c *Controller) observe(ctx context.Context, r *RollingpinMapping, invalid error) {
+	valid := invalid == nil
+	status := r.Status
+	if status.ObservedGeneration == r.Generation && status.Valid == valid && (valid || status.LastError == invalid.Error()) {
+		return
+	}
+	if valid {
+		c.Logger.Info("RollingpinMapping in use",
+			zap.String("namespace", r.Namespace),
+			zap.String("name", r.Name),
+			zap.String("image_name", r.Spec.Image),
+			zap.String("deployment", r.Spec.Deployment))
+	} else {
+		c.Logger.Warn("Invalid RollingpinMapping",
+			zap.String("namespace", r.Namespace),
+			zap.String("name", r.Name),
+			zap.Error(invalid))
+	}
+	err := c.updateStatus(ctx, r.Namespace, r.Name, func(s *MappingStatus) {
+		if !s.Valid && valid {
+			s.LastError = ""
+		}
+		s.ObservedGeneration = r.Generation
+		s.Valid = valid
+		if !valid {
+			s.LastError = invalid.Error()
+		}
+	})
+	if err != nil {
+		c.Logger.Warn("Error while updating RollingpinMapping status",
+			zap.String("namespace", r.Namespace),
+			zap.String("name", r.Name),
+			zap.Error(err))
+	}
+}
+
+// HandleResult records the outcome of an event in the status of the
+// RollingpinMapping the mapping came from, if any. It is meant to be
+// registered with Pipeline.OnResult. The status is written in the
+// background, so that events don't wait on the API server.
+func (c *Controller) HandleResult(m *config.ImageMapping, e *deploy.Event, result *deploy.Result, err error) {
+	now := metav1.NewTime(c.clock())
+	change := func(s *MappingStatus) {
+		s.LastEventTime = &now
+		switch {
+		case err != nil:
+			s.LastError = err.Error()
+		case result != nil && result.Status == deploy.StatusDeployed:
+			s.LastDeployedImage = result.Image
+			s.LastError = ""
+		}
+	}
+	c.mu.Lock()
+	name, ok := c.owners[m.Namespace+"/"+m.DeploymentName]
+	if !ok || c.results == nil {
+		c.mu.Unlock()
+		return
+	}
+	key := resourceKey{namespace: m.Namespace, name: name}
+	c.results[key] = append(c.results[key], change)
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// writeResults writes the status changes recorded by HandleResult until ctx
+// is done. Changes that pile up for a resource while a write is in flight
+// are applied together in its next write.
+func (c *Controller) writeResults(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.wake:
+		}
+		c.mu.Lock()
+		results := c.results
+		c.results = map[resourceKey][]func(*MappingStatus){}
+		c.mu.Unlock()
+		for key, changes := range results {
+			err := c.updateStatus(ctx, key.namespace, key.name, func(s *MappingStatus) {
+				for _, change := range changes {
+					change(s)
+				}
+			})
+			if err != nil {
+				c.Logger.Warn("Error while updating RollingpinMapping status",
+					zap.String("namespace", key.namespace),
+					zap.String("name", key.name),
+					zap.Error(err))
+			}
+		}
+	}
+}
+
+// updateStatus applies a change to a resource's status, retrying if the
+// resource changed in the meantime.
+func (c *Controller) updateStatus(ctx context.Context, namespace string, name string, change func(*MappingStatus)) error {
+	client := c.Client.Resource(GroupVersionResource).Namespace(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		r, err := fromUnstructured(u)
+		if err != nil {
+			return err
+		}
+		change(&r.Status)
+		u, err = toUnstructured(r)
+		if err != nil {
+			return err
+		}
+		_, err = client.UpdateStatus(ctx, u, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (c *Controller) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+func fromUnstructured(u *unstructured.Unstructured) (*RollingpinMapping, error) {
+	r := &RollingpinMapping{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func toUnstructured(r *RollingpinMapping) (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(r)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: obj}
+	u.SetAPIVersion(Group + "/" + Version)
+	u.SetKind(Kind)
+	return u, nil
+}
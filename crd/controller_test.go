@@ -0,0 +1,188 @@
+package crd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.b8s.dev/rollingpin/kube"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func resource(t *testing.T, ns string, name string, spec MappingSpec) runtime.Object {
+	u, err := toUnstructured(&RollingpinMapping{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+		Spec:       spec,
+	})
+	if err != nil {
+		t.Fatalf("toUnstructured failed: %v", err)
+	}
+	return u
+}
+
+func testController(t *testing.T, objects ...runtime.Object) (*Controller, *fake.FakeDynamicClient) {
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{GroupVersionResource: Kind + "List"},
+		objects...)
+	conf := &config.Config{
+		Mappings: []config.ImageMapping{
+			{ImageName: "library/static", DeploymentName: "static", Namespace: "default"},
+		},
+		Resources: config.ResourcesConfig{Enabled: true, Providers: []string{"direct"}},
+	}
+	c := &Controller{Client: client, Config: conf, Logger: zap.NewNop()}
+	return c, client
+}
+
+func getResource(t *testing.T, c *Controller, ns string, name string) *RollingpinMapping {
+	u, err := c.Client.Resource(GroupVersionResource).Namespace(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	r, err := fromUnstructured(u)
+	if err != nil {
+		t.Fatalf("fromUnstructured failed: %v", err)
+	}
+	return r
+}
+
+// waitForStatus waits for the controller to write a resource's status in
+// the background.
+func waitForStatus(t *testing.T, c *Controller, ns string, name string, what string, done func(MappingStatus) bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status := getResource(t, c, ns, name).Status
+		if done(status) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s, status is %+v", what, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestControllerValidates(t *testing.T) {
+	c, _ := testController(t,
+		resource(t, "team-a", "app", MappingSpec{
+			Image:      "library/app",
+			Deployment: "app",
+			Container:  "main",
+			TagPolicy:  &TagPolicySpec{Semver: "~1.4", NoDowngrade: true},
+			Debounce:   &DebounceSpec{Window: metav1.Duration{Duration: time.Minute}, Pick: "highest"},
+		}),
+		resource(t, "team-a", "duplicate", MappingSpec{Image: "library/other", Deployment: "app"}),
+		resource(t, "team-a", "noimage", MappingSpec{Deployment: "noimage"}),
+		resource(t, "team-a", "badpolicy", MappingSpec{Image: "library/x", Deployment: "x", Policies: []string{"]["}}),
+		resource(t, "team-a", "badtags", MappingSpec{Image: "library/y", Deployment: "y", TagPolicy: &TagPolicySpec{Glob: "main-*", NoDowngrade: true}}),
+		resource(t, "team-a", "baddebounce", MappingSpec{Image: "library/z", Deployment: "z", Debounce: &DebounceSpec{Pick: "newest"}}),
+		resource(t, "default", "static", MappingSpec{Image: "library/static", Deployment: "static"}),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	if n := len(c.Config.AllMappings()); n != 2 {
+		t.Errorf("Only the static and one valid resource mapping should be in use, got %+v", c.Config.AllMappings())
+	}
+	m := config.FindMapping(c.Config, "team-a", "app")
+	if m == nil || m.ImageName != "library/app" || m.Container != "main" || len(m.Providers) != 1 || m.Providers[0] != "direct" {
+		t.Errorf("Resource should be mapped with the default providers, got %+v", m)
+	}
+	if m != nil && (m.TagPolicy == nil || m.TagPolicy.Semver != "~1.4" || !m.TagPolicy.NoDowngrade) {
+		t.Errorf("Resource should be mapped with its tag policy, got %+v", m.TagPolicy)
+	}
+	if m != nil && (m.Debounce == nil || m.Debounce.Window != time.Minute || m.Debounce.Pick != "highest") {
+		t.Errorf("Resource should be mapped with its debounce, got %+v", m.Debounce)
+	}
+	if r := getResource(t, c, "team-a", "app"); !r.Status.Valid || r.Status.LastError != "" {
+		t.Errorf("Valid resource should be marked valid, got %+v", r.Status)
+	}
+	for _, name := range []string{"duplicate", "noimage", "badpolicy", "badtags", "baddebounce"} {
+		if r := getResource(t, c, "team-a", name); r.Status.Valid || r.Status.LastError == "" {
+			t.Errorf("Resource %s should be marked invalid, got %+v", name, r.Status)
+		}
+	}
+	if r := getResource(t, c, "default", "static"); r.Status.Valid {
+		t.Errorf("Resource for a statically mapped deployment should be invalid, got %+v", r.Status)
+	}
+}
+
+func TestControllerRecordsResults(t *testing.T) {
+	c, client := testController(t,
+		resource(t, "team-a", "app", MappingSpec{Image: "library/app", Deployment: "app"}),
+	)
+	now := time.Unix(1586922308, 0)
+	c.now = func() time.Time { return now }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	kubeClient, _ := kube.NewFake()
+	kubeClient.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "team-a",
+		Containers: []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/app:1"}},
+	})
+	p := &deploy.Pipeline{Config: c.Config, Logger: zap.NewNop(), Client: kubeClient}
+	p.OnResult(c.HandleResult)
+
+	result, err := p.Deploy(&deploy.Event{Provider: "direct", Repository: "library/app", ImageURL: "cr.b8s.dev/library/app:2"})
+	if err != nil || result.Status != deploy.StatusDeployed {
+		t.Fatalf("Webhook should match the resource's mapping, got %+v, %v", result, err)
+	}
+	waitForStatus(t, c, "team-a", "app", "the deploy to be recorded", func(s MappingStatus) bool {
+		return s.LastDeployedImage == "cr.b8s.dev/library/app:2" && s.LastEventTime != nil && s.LastEventTime.Time.Equal(now)
+	})
+
+	// Deleting the deployment makes the next event fail.
+	kubeClient.DeleteDeployment("team-a", "app")
+	if _, err := p.Deploy(&deploy.Event{Provider: "direct", Repository: "library/app", ImageURL: "cr.b8s.dev/library/app:3"}); err == nil {
+		t.Fatalf("Deploy to a missing deployment should fail")
+	}
+	waitForStatus(t, c, "team-a", "app", "the error to be recorded", func(s MappingStatus) bool {
+		return s.LastError != "" && s.LastDeployedImage == "cr.b8s.dev/library/app:2"
+	})
+
+	if err := client.Resource(GroupVersionResource).Namespace("team-a").Delete(ctx, "app", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for config.FindMapping(c.Config, "team-a", "app") != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Deleted resource should no longer be mapped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSpecChanged(t *testing.T) {
+	old := resource(t, "team-a", "app", MappingSpec{Image: "library/app", Deployment: "app"}).(*unstructured.Unstructured)
+	statusOnly := old.DeepCopy()
+	unstructured.SetNestedField(statusOnly.Object, "cr.b8s.dev/library/app:2", "status", "lastDeployedImage")
+	if specChanged(old, statusOnly) {
+		t.Errorf("A status update should not count as a spec change")
+	}
+	respecced := old.DeepCopy()
+	unstructured.SetNestedField(respecced.Object, "other", "spec", "deployment")
+	if !specChanged(old, respecced) {
+		t.Errorf("A spec update should count as a spec change")
+	}
+	regenerated := old.DeepCopy()
+	regenerated.SetGeneration(2)
+	if !specChanged(old, regenerated) {
+		t.Errorf("A new generation should count as a spec change")
+	}
+}
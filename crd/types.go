@@ -0,0 +1,187 @@
+// Package crd defines the RollingpinMapping custom resource, which lets teams
+// declare mappings next to their own manifests, and the controller that turns
+// them into mappings.
+//
+// +k8s:deepcopy-gen=package
+// +groupName=rollingpin.b8s.dev
+package crd
+
+import (
+	"errors"
+	"fmt"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/policy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group    = "rollingpin.b8s.dev"
+	Version  = "v1alpha1"
+	Kind     = "RollingpinMapping"
+	Resource = "rollingpinmappings"
+)
+
+// GroupVersionResource identifies RollingpinMappings to the dynamic client.
+var GroupVersionResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: Resource}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RollingpinMapping maps an image to a Deployment in the same namespace.
+type RollingpinMapping struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MappingSpec   `json:"spec"`
+	Status MappingStatus `json:"status,omitempty"`
+}
+
+// MappingSpec mirrors the fields of a mapping in the config file that
+// application teams are expected to set. Canaries, per-mapping freezes,
+// polling and the Harbor scan and replication settings can only be
+// configured in the config file; the global freeze applies to resource
+// mappings too.
+type MappingSpec struct {
+	// Image is the image name, as in a mapping's `image`.
+	Image      string `json:"image"`
+	Deployment string `json:"deployment"`
+
+	// Container is the container to update. Defaults to the first.
+	Container string `json:"container,omitempty"`
+
+	// Providers default to the `resources.providers` config.
+	Providers []string `json:"providers,omitempty"`
+
+	PinDigest         bool     `json:"pinDigest,omitempty"`
+	OnUnchanged       string   `json:"onUnchanged,omitempty"`
+	RequireApproval   bool     `json:"requireApproval,omitempty"`
+	RollbackOnFailure bool     `json:"rollbackOnFailure,omitempty"`
+	Policies          []string `json:"policies,omitempty"`
+
+	// TagPolicy restricts which tags may be deployed, as in a mapping's
+	// `tag_policy`. If unset, any tag is.
+	TagPolicy *TagPolicySpec `json:"tagPolicy,omitempty"`
+
+	// Debounce coalesces events that arrive close together, as in a
+	// mapping's `debounce`.
+	Debounce *DebounceSpec `json:"debounce,omitempty"`
+}
+
+// TagPolicySpec mirrors a mapping's `tag_policy`.
+type TagPolicySpec struct {
+	Semver          string `json:"semver,omitempty"`
+	Prerelease      bool   `json:"prerelease,omitempty"`
+	Pattern         string `json:"pattern,omitempty"`
+	Glob            string `json:"glob,omitempty"`
+	Order           string `json:"order,omitempty"`
+	OrderBy         string `json:"orderBy,omitempty"`
+	TimestampLayout string `json:"timestampLayout,omitempty"`
+	NoDowngrade     bool   `json:"noDowngrade,omitempty"`
+}
+
+// DebounceSpec mirrors a mapping's `debounce`.
+type DebounceSpec struct {
+	Window metav1.Duration `json:"window"`
+	Pick   string          `json:"pick,omitempty"`
+}
+
+// MappingStatus is written by the controller.
+type MappingStatus struct {
+	// ObservedGeneration is the generation of the spec that Valid describes.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Valid is whether the mapping is in use. If it isn't, LastError says
+	// why.
+	Valid bool `json:"valid"`
+
+	LastDeployedImage string       `json:"lastDeployedImage,omitempty"`
+	LastError         string       `json:"lastError,omitempty"`
+	LastEventTime     *metav1.Time `json:"lastEventTime,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RollingpinMappingList is a list of RollingpinMappings.
+type RollingpinMappingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []RollingpinMapping `json:"items"`
+}
+
+// Validate checks the spec for mistakes that can be caught before any
+// events arrive.
+func (r *RollingpinMapping) Validate() error {
+	if r.Spec.Image == "" {
+		return errors.New("spec.image is required")
+	}
+	if r.Spec.Deployment == "" {
+		return errors.New("spec.deployment is required")
+	}
+	switch r.Spec.OnUnchanged {
+	case "", "restart", "digest":
+	default:
+		return fmt.Errorf("spec.onUnchanged must be restart or digest, not %q", r.Spec.OnUnchanged)
+	}
+	if _, err := policy.Compile(r.Spec.Policies); err != nil {
+		return fmt.Errorf("spec.policies: %w", err)
+	}
+	if _, err := r.Spec.TagPolicy.tagPolicy().Compile(); err != nil {
+		return fmt.Errorf("spec.tagPolicy: %w", err)
+	}
+	if d := r.Spec.Debounce; d != nil {
+		if d.Window.Duration <= 0 {
+			return errors.New("spec.debounce.window must be positive")
+		}
+		switch d.Pick {
+		case "", "last", "highest":
+		default:
+			return fmt.Errorf("spec.debounce.pick must be last or highest, not %q", d.Pick)
+		}
+	}
+	return nil
+}
+
+// Mapping returns the mapping the resource describes. providers are used if
+// the spec doesn't list any.
+func (r *RollingpinMapping) Mapping(providers []string) config.ImageMapping {
+	m := config.ImageMapping{
+		ImageName:         r.Spec.Image,
+		DeploymentName:    r.Spec.Deployment,
+		Namespace:         r.Namespace,
+		Container:         r.Spec.Container,
+		Providers:         r.Spec.Providers,
+		PinDigest:         r.Spec.PinDigest,
+		OnUnchanged:       r.Spec.OnUnchanged,
+		RequireApproval:   r.Spec.RequireApproval,
+		RollbackOnFailure: r.Spec.RollbackOnFailure,
+		Policies:          r.Spec.Policies,
+		TagPolicy:         r.Spec.TagPolicy.tagPolicy(),
+	}
+	if d := r.Spec.Debounce; d != nil {
+		m.Debounce = &config.DebounceConfig{Window: d.Window.Duration, Pick: d.Pick}
+	}
+	if len(m.Providers) == 0 {
+		m.Providers = providers
+	}
+	return m
+}
+
+// tagPolicy converts the spec to the config's TagPolicy. A nil spec yields
+// nil.
+func (s *TagPolicySpec) tagPolicy() *config.TagPolicy {
+	if s == nil {
+		return nil
+	}
+	return &config.TagPolicy{
+		Semver:          s.Semver,
+		Prerelease:      s.Prerelease,
+		Pattern:         s.Pattern,
+		Glob:            s.Glob,
+		Order:           s.Order,
+		OrderBy:         s.OrderBy,
+		TimestampLayout: s.TimestampLayout,
+		NoDowngrade:     s.NoDowngrade,
+	}
+}
@@ -0,0 +1,166 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.b8s.dev/rollingpin/approval"
+	"go.b8s.dev/rollingpin/config"
+	"go.uber.org/zap"
+)
+
+const approvalSweepInterval = time.Minute
+
+// requestApproval stores the event as a pending approval request instead of
+// deploying it.
+func (p *Pipeline) requestApproval(m *config.ImageMapping, e *Event) (*Result, error) {
+	if p.Approvals == nil {
+		return nil, fmt.Errorf("mapping %s requires approval but approvals are not enabled", m.DeploymentName)
+	}
+	req, err := p.Approvals.Create(&approval.Request{
+		Namespace:  m.Namespace,
+		Deployment: m.DeploymentName,
+		ImageName:  m.ImageName,
+		Provider:   e.Provider,
+		Tag:        e.Tag,
+		Digest:     e.Digest,
+		ImageURL:   e.ImageURL,
+		EventID:    e.ID,
+		OccurAt:    e.OccurAt,
+		JobID:      e.job,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.Logger.Info("Deploy awaiting approval",
+		zap.String("provider", e.Provider),
+		zap.String("image_name", m.ImageName),
+		zap.String("deployment", m.DeploymentName),
+		zap.String("tag", e.Tag),
+		zap.String("approval_id", req.ID))
+	return &Result{ID: req.ID, Status: StatusPendingApproval, Reason: "mapping requires approval"}, nil
+}
+
+// Approve claims a pending request as approved and applies it through the
+// normal pipeline. If a job is waiting on the request, it is queued again.
+// The request is reopened if it can't be applied, so that it can be
+// approved again.
+func (p *Pipeline) Approve(id string, by string) (*approval.Request, *Result, error) {
+	if p.Approvals == nil {
+		return nil, nil, approval.ErrNotFound
+	}
+	req, err := p.Approvals.Decide(id, approval.StateApproved, by)
+	if err != nil {
+		return nil, nil, err
+	}
+	var result *Result
+	if m := config.FindMapping(p.Config, req.Namespace, req.Deployment); m == nil {
+		err = fmt.Errorf("mapping for %s/%s no longer exists", req.Namespace, req.Deployment)
+	} else if req.JobID != "" && p.Jobs != nil {
+		result, err = p.releaseApproved(req, by)
+	} else {
+		result, err = p.applyApproved(m, req, by)
+	}
+	if err != nil {
+		if reopened, reopenErr := p.Approvals.Reopen(id); reopenErr != nil {
+			p.Logger.Warn("Error while reopening approval request",
+				zap.String("approval_id", id),
+				zap.Error(reopenErr))
+		} else {
+			req = reopened
+		}
+		return req, result, err
+	}
+	p.Logger.Info("Deploy approved",
+		zap.String("approval_id", req.ID),
+		zap.String("deployment", req.Deployment),
+		zap.String("approved_by", by))
+	return req, result, nil
+}
+
+// applyApproved applies an approved request. It skips the duplicate and
+// age checks, which the original event already passed, but is still
+// ordered against later events by its OccurAt.
+func (p *Pipeline) applyApproved(m *config.ImageMapping, req *approval.Request, by string) (*Result, error) {
+	e := &Event{
+		Provider:   req.Provider,
+		Repository: req.ImageName,
+		Tag:        req.Tag,
+		Digest:     req.Digest,
+		ImageURL:   req.ImageURL,
+		ID:         req.EventID,
+		OccurAt:    req.OccurAt,
+		ApprovedBy: by,
+	}
+	result, err := p.accept(m, e)
+	p.report(m, e, result, err)
+	return result, err
+}
+
+// releaseApproved queues the job waiting on an approved request again.
+func (p *Pipeline) releaseApproved(req *approval.Request, by string) (*Result, error) {
+	job := p.Jobs.Get(req.JobID)
+	if job == nil {
+		return nil, fmt.Errorf("job %s no longer exists", req.JobID)
+	}
+	e := job.Event
+	e.ApprovedBy = by
+	if err := p.Jobs.Release(job.ID, &e); err != nil {
+		return nil, err
+	}
+	return &Result{ID: job.ID, Status: StatusAccepted}, nil
+}
+
+// Reject marks a pending request as rejected so it is never applied.
+func (p *Pipeline) Reject(id string, by string) (*approval.Request, error) {
+	if p.Approvals == nil {
+		return nil, approval.ErrNotFound
+	}
+	req, err := p.Approvals.Decide(id, approval.StateRejected, by)
+	if err != nil {
+		return nil, err
+	}
+	p.Logger.Info("Deploy rejected",
+		zap.String("approval_id", req.ID),
+		zap.String("deployment", req.Deployment),
+		zap.String("rejected_by", by))
+	p.closeJob(&Event{job: req.JobID}, JobRejected, "rejected by "+by)
+	return req, nil
+}
+
+// SweepApprovals closes the jobs of approval requests that expired and
+// prunes old requests, every minute until the context is cancelled.
+func (p *Pipeline) SweepApprovals(ctx context.Context) {
+	ticker := time.NewTicker(approvalSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := p.sweepApprovals(); err != nil {
+			p.Logger.Warn("Error while sweeping approval requests", zap.Error(err))
+		}
+	}
+}
+
+func (p *Pipeline) sweepApprovals() error {
+	if p.Approvals == nil {
+		return nil
+	}
+	expired, err := p.Approvals.List(approval.StateExpired)
+	if err != nil {
+		return err
+	}
+	for _, req := range expired {
+		if req.JobID == "" || p.Jobs == nil {
+			continue
+		}
+		if job := p.Jobs.Get(req.JobID); job != nil && job.State == JobWaiting {
+			p.closeJob(&Event{job: req.JobID}, JobRejected, "approval expired")
+		}
+	}
+	return p.Approvals.Prune()
+}
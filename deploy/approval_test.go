@@ -0,0 +1,132 @@
+package deploy
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/approval"
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+)
+
+func TestPipelineRequireApproval(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{RequireApproval: true})
+
+	result, err := p.Deploy(&Event{Provider: "direct", Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil {
+		t.Fatalf("Deploy failed: %v", err)
+	}
+	if result.Status != StatusPendingApproval || result.ID == "" {
+		t.Fatalf("Deploy should be pending approval with an ID, got %+v", result)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Deploy should wait for approval, deployment runs %s", d.Containers[0].Image)
+	}
+
+	req, result, err := p.Approve(result.ID, "alice")
+	if err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	if req.State != approval.StateApproved || req.DecidedBy != "alice" {
+		t.Errorf("Approve recorded the wrong decision: %+v", req)
+	}
+	if result.Status != StatusDeployed {
+		t.Errorf("Approved deploy should be applied, got %s", result.Status)
+	}
+	d, _ = client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("Approved deploy was not applied: %s", d.Containers[0].Image)
+	}
+
+	if _, _, err := p.Approve(req.ID, "alice"); !errors.Is(err, approval.ErrNotPending) {
+		t.Errorf("Approving twice should fail with ErrNotPending, got %v", err)
+	}
+}
+
+func TestPipelineRejectApproval(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{RequireApproval: true})
+
+	result, _ := p.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+	req, err := p.Reject(result.ID, "bob")
+	if err != nil {
+		t.Fatalf("Reject failed: %v", err)
+	}
+	if req.State != approval.StateRejected {
+		t.Errorf("Reject recorded state %s", req.State)
+	}
+	if _, _, err := p.Approve(result.ID, "alice"); !errors.Is(err, approval.ErrNotPending) {
+		t.Errorf("Approving a rejected request should fail, got %v", err)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Rejected deploy was applied: %s", d.Containers[0].Image)
+	}
+}
+
+func TestPipelineRequireApprovalWithoutStore(t *testing.T) {
+	p, _, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{RequireApproval: true})
+	p.Approvals = nil
+	if _, err := p.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"}); err == nil {
+		t.Errorf("Deploy should fail when approvals are required but not enabled")
+	}
+}
+
+func TestPipelineApproveRetriesFailedApply(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{RequireApproval: true})
+
+	occurAt := time.Unix(1586922308, 0)
+	result, err := p.Deploy(&Event{Provider: "direct", Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2", ID: "delivery-1", OccurAt: occurAt})
+	if err != nil {
+		t.Fatalf("Deploy failed: %v", err)
+	}
+	req, err := p.Approvals.Get(result.ID)
+	if err != nil || req.EventID != "delivery-1" || !req.OccurAt.Equal(occurAt) {
+		t.Fatalf("Request should carry the event's ID and time, got %+v, %v", req, err)
+	}
+
+	client.DeleteDeployment("default", "app")
+	if _, _, err := p.Approve(req.ID, "alice"); err == nil {
+		t.Fatalf("Approve should fail when the deployment is missing")
+	}
+	if req, _ := p.Approvals.Get(req.ID); req.State != approval.StatePending {
+		t.Fatalf("Request should stay pending when it can't be applied, got %s", req.State)
+	}
+
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/debian:1"}},
+	})
+	req, result, err = p.Approve(req.ID, "alice")
+	if err != nil || req.State != approval.StateApproved || result.Status != StatusDeployed {
+		t.Fatalf("Approving again should apply the deploy, got %+v, %+v, %v", req, result, err)
+	}
+}
+
+func TestPipelineApproveConcurrently(t *testing.T) {
+	p, _, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{RequireApproval: true})
+	var deployed int32
+	p.OnResult(func(_ *config.ImageMapping, _ *Event, result *Result, _ error) {
+		if result != nil && result.Status == StatusDeployed {
+			atomic.AddInt32(&deployed, 1)
+		}
+	})
+
+	result, _ := p.Deploy(&Event{Provider: "direct", Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+	var wg sync.WaitGroup
+	for _, by := range []string{"alice", "bob"} {
+		wg.Add(1)
+		go func(by string) {
+			defer wg.Done()
+			p.Approve(result.ID, by)
+		}(by)
+	}
+	wg.Wait()
+	if deployed != 1 {
+		t.Errorf("Approving a request twice at once should deploy it once, deployed %d times", deployed)
+	}
+}
@@ -0,0 +1,223 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCanaryHold     = 5 * time.Minute
+	defaultCanaryInterval = 10 * time.Second
+
+	// maxCanaryCheckRetries is how many more times the canary's pods are
+	// checked once a step's hold ends if the check fails.
+	maxCanaryCheckRetries = 3
+)
+
+// canaryRun is a canary running in the background.
+type canaryRun struct {
+	cancel context.CancelFunc
+}
+
+// startCanary runs the update through the mapping's canary in the
+// background. A newer event for the same deployment takes over the canary
+// from an earlier one.
+func (p *Pipeline) startCanary(m *config.ImageMapping, e *Event, update *kube.ImageUpdate) (*Result, error) {
+	steps := canarySteps(m.Canary)
+	key := fmt.Sprintf("%s/%s", m.Namespace, m.DeploymentName)
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &canaryRun{cancel: cancel}
+	p.mu.Lock()
+	if p.canaries == nil {
+		p.canaries = map[string]*canaryRun{}
+	}
+	previous, running := p.canaries[key]
+	p.canaries[key] = run
+	p.mu.Unlock()
+
+	// The earlier canary is torn down so that its pods aren't counted as the
+	// new one's.
+	if running {
+		previous.cancel()
+		if err := p.Client.DeleteCanary(m.Namespace, m.DeploymentName); err != nil {
+			p.Logger.Info("Error while deleting canary", zap.String("deployment", m.DeploymentName), zap.Error(err))
+		}
+	}
+	if err := p.Client.ApplyCanary(m.Namespace, m.DeploymentName, update, steps[0].Replicas); err != nil {
+		p.mu.Lock()
+		if p.canaries[key] == run {
+			delete(p.canaries, key)
+		}
+		p.mu.Unlock()
+		cancel()
+		return nil, err
+	}
+
+	p.Logger.Info("Started canary",
+		zap.String("provider", e.Provider),
+		zap.String("image_name", m.ImageName),
+		zap.String("deployment", m.DeploymentName),
+		zap.String("tag", e.Tag),
+		zap.String("image", update.Image))
+
+	go func() {
+		defer cancel()
+		err := p.runCanary(ctx, m, steps, update)
+		p.mu.Lock()
+		superseded := ctx.Err() != nil
+		if !superseded {
+			delete(p.canaries, key)
+		}
+		p.mu.Unlock()
+		if superseded {
+			result := &Result{Status: StatusCoalesced, Reason: "canary superseded by a later event"}
+			p.resolveJob(e, result, nil)
+			p.report(m, e, result, nil)
+			return
+		}
+		p.finishCanary(m, e, update, err)
+	}()
+
+	return &Result{Status: StatusCanary, Reason: "canary started"}, nil
+}
+
+// runCanary scales the canary through each step, checking its pods until the
+// step's hold ends. It returns an error if the canary should be aborted.
+func (p *Pipeline) runCanary(ctx context.Context, m *config.ImageMapping, steps []config.CanaryStep, update *kube.ImageUpdate) error {
+	interval := m.Canary.Interval
+	if interval <= 0 {
+		interval = defaultCanaryInterval
+	}
+	canary := kube.CanaryName(m.DeploymentName)
+	for i, step := range steps {
+		if i > 0 {
+			if err := p.Client.ApplyCanary(m.Namespace, m.DeploymentName, update, step.Replicas); err != nil {
+				return err
+			}
+			p.Logger.Info("Scaled canary",
+				zap.String("deployment", m.DeploymentName),
+				zap.Int32("replicas", step.Replicas))
+		}
+
+		hold := time.NewTimer(step.Hold)
+		ticker := time.NewTicker(interval)
+		held, retries := false, 0
+		for passed := false; !passed; {
+			select {
+			case <-ctx.Done():
+				hold.Stop()
+				ticker.Stop()
+				return ctx.Err()
+			case <-ticker.C:
+			case <-hold.C:
+				held = true
+			}
+			health, err := p.Client.PodHealth(m.Namespace, canary)
+			if err != nil {
+				p.Logger.Info("Error while checking canary", zap.String("deployment", canary), zap.Error(err))
+				// Once the hold is over the step only passes on a check
+				// that succeeds, which is tried again on the next tick.
+				if held {
+					if retries++; retries > maxCanaryCheckRetries {
+						ticker.Stop()
+						return fmt.Errorf("checking canary pods: %w", err)
+					}
+				}
+				continue
+			}
+			if health.Restarts > m.Canary.MaxRestarts {
+				hold.Stop()
+				ticker.Stop()
+				return fmt.Errorf("canary containers restarted %d times", health.Restarts)
+			}
+			if held && health.Ready < step.Replicas {
+				ticker.Stop()
+				return fmt.Errorf("only %d of %d canary pods were ready", health.Ready, step.Replicas)
+			}
+			passed = held
+		}
+		ticker.Stop()
+	}
+	return nil
+}
+
+// finishCanary promotes the update to the primary deployment if the canary
+// passed, and removes the canary either way. The outcome is reported, and the
+// job that started the canary is closed with it.
+func (p *Pipeline) finishCanary(m *config.ImageMapping, e *Event, update *kube.ImageUpdate, canaryErr error) {
+	var result *Result
+	err := canaryErr
+	if canaryErr != nil {
+		err = fmt.Errorf("canary aborted: %w", canaryErr)
+		p.Logger.Info("Aborted canary",
+			zap.String("image_name", m.ImageName),
+			zap.String("deployment", m.DeploymentName),
+			zap.String("image", update.Image),
+			zap.Error(canaryErr))
+	} else if result, err = p.promoteCanary(m, e, update); err != nil {
+		p.Logger.Info("Error while promoting canary", zap.String("deployment", m.DeploymentName), zap.Error(err))
+	}
+	if err := p.Client.DeleteCanary(m.Namespace, m.DeploymentName); err != nil {
+		p.Logger.Info("Error while deleting canary", zap.String("deployment", m.DeploymentName), zap.Error(err))
+	}
+	p.resolveJob(e, result, err)
+	p.report(m, e, result, err)
+}
+
+// promoteCanary updates the primary deployment to the canary's image, unless
+// the mapping was paused while the canary ran.
+func (p *Pipeline) promoteCanary(m *config.ImageMapping, e *Event, update *kube.ImageUpdate) (*Result, error) {
+	current, err := p.Client.GetDeployment(m.Namespace, m.DeploymentName)
+	if err != nil {
+		return nil, err
+	}
+	if result, err := p.checkPaused(m, e, current); result != nil || err != nil {
+		return result, err
+	}
+	return p.update(m, e, update, current)
+}
+
+// RemoveCanaries deletes the canary Deployments of every mapping with a
+// canary that this pipeline isn't running, like those left behind when
+// rollingpin restarted in the middle of a canary. It should be called before
+// jobs are started: the job for an interrupted canary is queued again by
+// JobQueue.Load, and starts the canary over.
+func (p *Pipeline) RemoveCanaries() {
+	p.mu.Lock()
+	running := map[string]bool{}
+	for key := range p.canaries {
+		running[key] = true
+	}
+	p.mu.Unlock()
+	for _, m := range p.Config.AllMappings() {
+		if m.Canary == nil || running[fmt.Sprintf("%s/%s", m.Namespace, m.DeploymentName)] {
+			continue
+		}
+		if err := p.Client.DeleteCanary(m.Namespace, m.DeploymentName); err != nil {
+			p.Logger.Info("Error while deleting canary", zap.String("deployment", m.DeploymentName), zap.Error(err))
+		}
+	}
+}
+
+// canarySteps fills in defaults for the configured steps.
+func canarySteps(c *config.CanaryConfig) []config.CanaryStep {
+	if len(c.Steps) == 0 {
+		return []config.CanaryStep{{Replicas: 1, Hold: defaultCanaryHold}}
+	}
+	steps := make([]config.CanaryStep, len(c.Steps))
+	for i, step := range c.Steps {
+		if step.Replicas <= 0 {
+			step.Replicas = 1
+		}
+		if step.Hold <= 0 {
+			step.Hold = defaultCanaryHold
+		}
+		steps[i] = step
+	}
+	return steps
+}
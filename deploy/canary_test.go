@@ -0,0 +1,221 @@
+package deploy
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/state"
+)
+
+// canaryMapping runs a canary of two short steps, checked often.
+var canaryMapping = config.ImageMapping{
+	Canary: &config.CanaryConfig{
+		Steps: []config.CanaryStep{
+			{Replicas: 1, Hold: 20 * time.Millisecond},
+			{Replicas: 2, Hold: 20 * time.Millisecond},
+		},
+		Interval: 5 * time.Millisecond,
+	},
+}
+
+// canaryRemoved reports whether the canary deployment is gone.
+func canaryRemoved(client kube.IClient) func() bool {
+	return func() bool {
+		_, err := client.GetDeployment("default", "app-canary")
+		return err != nil
+	}
+}
+
+func TestPipelineCanaryPromotes(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1", canaryMapping)
+	canary := map[string]string{kube.TrackLabel: "canary"}
+	client.CreatePod(&kube.Pod{Namespace: "default", Name: "app-canary-a", Labels: canary, Ready: true})
+	client.CreatePod(&kube.Pod{Namespace: "default", Name: "app-canary-b", Labels: canary, Ready: true})
+
+	result, err := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if result.Status != StatusCanary {
+		t.Errorf("Apply should start a canary, got %s", result.Status)
+	}
+	c, err := client.GetDeployment("default", "app-canary")
+	if err != nil || c.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Fatalf("Canary should run the new image: %v", err)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Primary should not change while the canary runs: %s", d.Containers[0].Image)
+	}
+
+	waitFor(t, "the canary to be removed", canaryRemoved(client))
+	d, _ = client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("Healthy canary should have been promoted, primary runs %s", d.Containers[0].Image)
+	}
+}
+
+func TestPipelineCanaryAbortsOnRestarts(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1", canaryMapping)
+	canary := map[string]string{kube.TrackLabel: "canary"}
+	client.CreatePod(&kube.Pod{Namespace: "default", Name: "app-canary-a", Labels: canary, Ready: true, Restarts: 1})
+	errs := make(chan error, 2)
+	p.OnResult(func(_ *config.ImageMapping, _ *Event, _ *Result, err error) { errs <- err })
+
+	if _, err := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:2"}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	waitFor(t, "the canary to be removed", canaryRemoved(client))
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Crashing canary should have been aborted, primary runs %s", d.Containers[0].Image)
+	}
+	<-errs
+	select {
+	case err := <-errs:
+		if err == nil || !strings.HasPrefix(err.Error(), "canary aborted") {
+			t.Errorf("Aborted canary should be reported, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Canary outcome was never reported")
+	}
+}
+
+func TestPipelineCanaryAbortsWhenNotReady(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1", canaryMapping)
+	canary := map[string]string{kube.TrackLabel: "canary"}
+	client.CreatePod(&kube.Pod{Namespace: "default", Name: "app-canary-a", Labels: canary, Ready: true})
+
+	if _, err := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:2"}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	// Only one pod is ready, so the second step, with two replicas, fails.
+	waitFor(t, "the canary to be removed", canaryRemoved(client))
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Canary without enough ready pods should have been aborted, primary runs %s", d.Containers[0].Image)
+	}
+}
+
+func TestCanaryStepsDefaults(t *testing.T) {
+	steps := canarySteps(&config.CanaryConfig{Steps: []config.CanaryStep{{Replicas: 2}, {Hold: time.Minute}}})
+	if steps[0].Replicas != 2 || steps[0].Hold != defaultCanaryHold {
+		t.Errorf("Step without a hold should hold for the default, got %+v", steps[0])
+	}
+	if steps[1].Replicas != 1 || steps[1].Hold != time.Minute {
+		t.Errorf("Step without replicas should run one, got %+v", steps[1])
+	}
+}
+
+func TestPipelineRemoveCanaries(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", canaryMapping)
+	client.ApplyCanary("default", "app", &kube.ImageUpdate{Image: "cr.b8s.dev/library/debian:2"}, 1)
+
+	p.RemoveCanaries()
+	if _, err := client.GetDeployment("default", "app-canary"); err == nil {
+		t.Errorf("Canary left behind by a restart should have been removed")
+	}
+}
+
+// canaryClient fails every check of the canary's pods with healthErr, if it
+// is set, and counts the canaries it deletes.
+type canaryClient struct {
+	kube.IClient
+	healthErr error
+
+	mu      sync.Mutex
+	deleted int
+}
+
+func (c *canaryClient) PodHealth(ns string, name string) (*kube.PodHealth, error) {
+	if c.healthErr != nil {
+		return nil, c.healthErr
+	}
+	return c.IClient.PodHealth(ns, name)
+}
+
+func (c *canaryClient) DeleteCanary(ns string, name string) error {
+	c.mu.Lock()
+	c.deleted++
+	c.mu.Unlock()
+	return c.IClient.DeleteCanary(ns, name)
+}
+
+func TestPipelineCanaryAbortsWithoutHealthCheck(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1", canaryMapping)
+	p.Client = &canaryClient{IClient: client, healthErr: errors.New("connection refused")}
+	errs := make(chan error, 2)
+	p.OnResult(func(_ *config.ImageMapping, _ *Event, _ *Result, err error) { errs <- err })
+
+	if _, err := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:2"}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	<-errs
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "checking canary pods") {
+			t.Errorf("Canary that was never checked should be aborted, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Canary outcome was never reported")
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Unchecked canary should not be promoted, primary runs %s", d.Containers[0].Image)
+	}
+}
+
+func TestPipelineCanaryReplacesRunningCanary(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1", canaryMapping)
+	wrapped := &canaryClient{IClient: client}
+	p.Client = wrapped
+	canary := map[string]string{kube.TrackLabel: "canary"}
+	client.CreatePod(&kube.Pod{Namespace: "default", Name: "app-canary-a", Labels: canary, Ready: true})
+	client.CreatePod(&kube.Pod{Namespace: "default", Name: "app-canary-b", Labels: canary, Ready: true})
+
+	p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:2"})
+	p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:3"})
+	wrapped.mu.Lock()
+	deleted := wrapped.deleted
+	wrapped.mu.Unlock()
+	if deleted != 1 {
+		t.Errorf("Running canary should be torn down before the next one starts, deleted %d", deleted)
+	}
+	waitFor(t, "the canary to be promoted", func() bool {
+		d, _ := client.GetDeployment("default", "app")
+		return d.Containers[0].Image == "cr.b8s.dev/library/debian:3"
+	})
+}
+
+func TestPipelineCanaryNotPromotedWhenPaused(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1", canaryMapping)
+	p.Pauses = &Pauses{Backend: state.NewMemory()}
+	canary := map[string]string{kube.TrackLabel: "canary"}
+	client.CreatePod(&kube.Pod{Namespace: "default", Name: "app-canary-a", Labels: canary, Ready: true})
+	client.CreatePod(&kube.Pod{Namespace: "default", Name: "app-canary-b", Labels: canary, Ready: true})
+	results := make(chan *Result, 2)
+	p.OnResult(func(_ *config.ImageMapping, _ *Event, result *Result, _ error) { results <- result })
+
+	if _, err := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:2"}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	p.Pauses.Pause(m, "alice", "incident")
+	<-results
+	select {
+	case result := <-results:
+		if result == nil || result.Status != StatusRejected {
+			t.Errorf("Canary for a paused mapping should be rejected, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Canary outcome was never reported")
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Canary should not be promoted once the mapping is paused, primary runs %s", d.Containers[0].Image)
+	}
+}
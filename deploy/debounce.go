@@ -0,0 +1,100 @@
+package deploy
+
+import (
+	"fmt"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.uber.org/zap"
+)
+
+// debounced is the event chosen so far in a mapping's open debounce window.
+type debounced struct {
+	event     *Event
+	closesAt  time.Time
+	coalesced int
+}
+
+// debounce holds the event until the mapping's debounce window closes, and
+// then applies whichever event in the window was picked. Events that lose
+// their place in the window are reported as coalesced.
+func (p *Pipeline) debounce(m *config.ImageMapping, e *Event) *Result {
+	key := fmt.Sprintf("%s/%s", m.Namespace, m.DeploymentName)
+	p.mu.Lock()
+	if p.debounces == nil {
+		p.debounces = map[string]*debounced{}
+	}
+
+	window, ok := p.debounces[key]
+	if !ok {
+		window = &debounced{event: e, closesAt: p.clock().Add(m.Debounce.Window)}
+		p.debounces[key] = window
+		p.mu.Unlock()
+		time.AfterFunc(m.Debounce.Window, func() { p.closeWindow(m, key) })
+		p.Logger.Info("Debouncing deploy",
+			zap.String("provider", e.Provider),
+			zap.String("image_name", m.ImageName),
+			zap.String("deployment", m.DeploymentName),
+			zap.String("tag", e.Tag),
+			zap.Time("closes_at", window.closesAt))
+		closesAt := window.closesAt
+		return &Result{Status: StatusDebounced, OpensAt: &closesAt}
+	}
+
+	window.coalesced++
+	closesAt := window.closesAt
+	if m.Debounce.Pick == "highest" && CompareTags(m, e.Tag, window.event.Tag) < 0 {
+		picked := window.event
+		p.mu.Unlock()
+		p.logCoalesced(m, e, picked)
+		return &Result{Status: StatusCoalesced, Reason: "superseded by " + picked.Tag}
+	}
+	superseded := window.event
+	window.event = e
+	p.mu.Unlock()
+
+	p.logCoalesced(m, superseded, e)
+	result := &Result{Status: StatusCoalesced, Reason: "superseded by " + e.Tag}
+	p.resolveJob(superseded, result, nil)
+	p.report(m, superseded, result, nil)
+	return &Result{Status: StatusDebounced, OpensAt: &closesAt}
+}
+
+// closeWindow applies the event picked in a debounce window. If it came from
+// a job, the job is queued again to apply it; otherwise it is accepted like a
+// new event, which queues it as a job when there is a job queue.
+func (p *Pipeline) closeWindow(m *config.ImageMapping, key string) {
+	p.mu.Lock()
+	window := p.debounces[key]
+	delete(p.debounces, key)
+	p.mu.Unlock()
+	if window == nil {
+		return
+	}
+	released := *window.event
+	released.Debounced = true
+	if p.releaseJob(&released) {
+		return
+	}
+
+	result, err := p.accept(m, &released)
+	p.report(m, &released, result, err)
+	if err != nil {
+		p.Logger.Info("Error while applying debounced deploy", zap.String("deployment", m.DeploymentName), zap.Error(err))
+		return
+	}
+	p.Logger.Info("Applied debounced deploy",
+		zap.String("image_name", m.ImageName),
+		zap.String("deployment", m.DeploymentName),
+		zap.String("tag", window.event.Tag),
+		zap.Int("coalesced", window.coalesced),
+		zap.String("status", string(result.Status)))
+}
+
+func (p *Pipeline) logCoalesced(m *config.ImageMapping, superseded *Event, by *Event) {
+	p.Logger.Info("Coalesced debounced deploy",
+		zap.String("image_name", m.ImageName),
+		zap.String("deployment", m.DeploymentName),
+		zap.String("tag", superseded.Tag),
+		zap.String("superseded_by", by.Tag))
+}
@@ -0,0 +1,104 @@
+package deploy
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+)
+
+// debounceMapping debounces events for a short window, picking one as pick
+// says.
+func debounceMapping(pick string) config.ImageMapping {
+	return config.ImageMapping{
+		TagPolicy: &config.TagPolicy{Semver: ">=1.0.0"},
+		Debounce:  &config.DebounceConfig{Window: 30 * time.Millisecond, Pick: pick},
+	}
+}
+
+func TestPipelineDebounceLast(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1.0.0", debounceMapping(""))
+
+	var results []*Result
+	for _, tag := range []string{"1.2.0", "1.3.0", "1.1.0"} {
+		result, err := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:" + tag})
+		if err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+		results = append(results, result)
+	}
+	for _, result := range results {
+		if result.Status != StatusDebounced || result.OpensAt == nil {
+			t.Errorf("Events in the window should be debounced, got %+v", result)
+		}
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1.0.0" {
+		t.Errorf("Nothing should deploy before the window closes: %s", d.Containers[0].Image)
+	}
+	waitForImage(t, client, "cr.b8s.dev/library/debian:1.1.0")
+}
+
+func TestPipelineDebounceHighest(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1.0.0", debounceMapping("highest"))
+
+	p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:1.2.0"})
+	p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:1.3.0"})
+	result, _ := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:1.1.0"})
+	if result.Status != StatusCoalesced || result.Reason != "superseded by 1.3.0" {
+		t.Errorf("Lower-ranked event should be coalesced immediately, got %+v", result)
+	}
+	waitForImage(t, client, "cr.b8s.dev/library/debian:1.3.0")
+
+	// The window is gone once applied, so the next event opens a new one.
+	result, _ = p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:1.4.0"})
+	if result.Status != StatusDebounced {
+		t.Errorf("A new window should open after the last one closed, got %s", result.Status)
+	}
+	waitForImage(t, client, "cr.b8s.dev/library/debian:1.4.0")
+}
+
+func TestPipelineDebounceSkipsRefusedTags(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1.0.0", debounceMapping(""))
+
+	p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:1.2.0"})
+	result, _ := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:pr-7"})
+	if result.Status != StatusSkipped {
+		t.Errorf("Tags refused by policy should not enter the window, got %s", result.Status)
+	}
+	waitForImage(t, client, "cr.b8s.dev/library/debian:1.2.0")
+}
+
+func TestPipelineDebounceReportsOutcome(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1.0.0", debounceMapping(""))
+	var mu sync.Mutex
+	reported := map[string][]Status{}
+	p.OnResult(func(_ *config.ImageMapping, e *Event, result *Result, err error) {
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", e.Tag, err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		reported[e.Tag] = append(reported[e.Tag], result.Status)
+	})
+
+	p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:1.2.0"})
+	p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:1.3.0"})
+	waitForImage(t, client, "cr.b8s.dev/library/debian:1.3.0")
+
+	waitFor(t, "the picked event to be reported", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(reported["1.3.0"]) == 2
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if got := reported["1.2.0"]; len(got) != 2 || got[1] != StatusCoalesced {
+		t.Errorf("Superseded event should be reported as coalesced, got %v", got)
+	}
+	if got := reported["1.3.0"]; len(got) != 2 || got[1] != StatusDeployed {
+		t.Errorf("Picked event should be reported as deployed when the window closes, got %v", got)
+	}
+}
@@ -0,0 +1,60 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+
+	"go.b8s.dev/rollingpin/registry"
+)
+
+const dockerHubRegistry = "registry-1.docker.io"
+
+// pinImage rewrites an event's image to reference its digest, resolving the
+// digest from the registry if the provider did not supply one.
+func (p *Pipeline) pinImage(e *Event) (string, error) {
+	name, tag, digest := ParseImage(e.ImageURL)
+	if digest == "" {
+		digest = e.Digest
+	}
+	if digest == "" {
+		if tag == "" {
+			return "", fmt.Errorf("cannot resolve a digest for %s without a tag", e.ImageURL)
+		}
+		resolved, err := p.resolveDigest(name, tag)
+		if err != nil {
+			return "", err
+		}
+		digest = resolved
+	}
+	e.Digest = digest
+	return name + "@" + digest, nil
+}
+
+func (p *Pipeline) resolveDigest(name string, tag string) (string, error) {
+	host, repository := splitRepository(name)
+	base, username, password := host, "", ""
+	for _, rc := range p.Config.Registries {
+		if rc.Host != host {
+			continue
+		}
+		username, password = rc.Username, rc.Password
+		if rc.Insecure {
+			base = "http://" + host
+		}
+	}
+	return registry.New(base, username, password).ResolveDigest(repository, tag)
+}
+
+// splitRepository separates the registry host from an image name, applying
+// Docker Hub's defaults to names without one.
+func splitRepository(name string) (string, string) {
+	i := strings.Index(name, "/")
+	if i < 0 {
+		return dockerHubRegistry, "library/" + name
+	}
+	host := name[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return dockerHubRegistry, name
+	}
+	return host, name[i+1:]
+}
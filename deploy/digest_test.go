@@ -0,0 +1,74 @@
+package deploy
+
+import (
+	"testing"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/registry/registrytest"
+)
+
+func TestSplitRepository(t *testing.T) {
+	cases := []struct{ name, host, repository string }{
+		{"debian", "registry-1.docker.io", "library/debian"},
+		{"bitnami/redis", "registry-1.docker.io", "bitnami/redis"},
+		{"cr.b8s.dev/library/debian", "cr.b8s.dev", "library/debian"},
+		{"localhost:5000/debian", "localhost:5000", "debian"},
+	}
+	for _, c := range cases {
+		host, repository := splitRepository(c.name)
+		if host != c.host || repository != c.repository {
+			t.Errorf("splitRepository(%q) = %q, %q", c.name, host, repository)
+		}
+	}
+}
+
+func TestPipelineApplyPinDigest(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{PinDigest: true})
+
+	_, err := p.Apply(m, &Event{Tag: "2", Digest: "sha256:abc", ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian@sha256:abc" {
+		t.Errorf("Apply did not pin the digest, image was: %s", d.Containers[0].Image)
+	}
+	if d.Annotations[kube.TagAnnotation] != "2" {
+		t.Errorf("Apply did not record the tag, annotations were: %v", d.Annotations)
+	}
+}
+
+func TestPipelineApplyPinDigestResolved(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	fake.Username = "robot"
+	fake.Password = "hunter2"
+	fake.Push("library/debian", "3", "sha256:def")
+
+	p, client, m := testPipeline(t, fake.Host()+"/library/debian:2", config.ImageMapping{
+		PinDigest: true,
+		TagPolicy: &config.TagPolicy{Semver: "*", NoDowngrade: true},
+	})
+	p.Config.Registries = []config.RegistryConfig{
+		{Host: fake.Host(), Username: "robot", Password: "hunter2", Insecure: true},
+	}
+
+	_, err := p.Apply(m, &Event{ImageURL: fake.Host() + "/library/debian:3"})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != fake.Host()+"/library/debian@sha256:def" {
+		t.Errorf("Apply did not pin the resolved digest, image was: %s", d.Containers[0].Image)
+	}
+
+	// The pinned deployment's tag comes from the annotation, so older tags
+	// are still recognised as downgrades.
+	fake.Push("library/debian", "1", "sha256:123")
+	p.Apply(m, &Event{ImageURL: fake.Host() + "/library/debian:1"})
+	d, _ = client.GetDeployment("default", "app")
+	if d.Containers[0].Image != fake.Host()+"/library/debian@sha256:def" {
+		t.Errorf("Apply should have refused to downgrade, image was: %s", d.Containers[0].Image)
+	}
+}
@@ -0,0 +1,52 @@
+package deploy
+
+import (
+	"time"
+)
+
+// Event is a provider-agnostic notice that a new image is available for a
+// repository. Every provider translates its own payloads into Events so that
+// they share a single path to the cluster.
+type Event struct {
+	// Provider is the name of the provider that produced the event, e.g.
+	// `harbor`.
+	Provider string `json:"provider"`
+
+	// Repository is the image name without registry or tag, matched against
+	// ImageMapping.ImageName.
+	Repository string `json:"repository"`
+
+	Tag    string `json:"tag,omitempty"`
+	Digest string `json:"digest,omitempty"`
+
+	// ImageURL is the full image reference to deploy.
+	ImageURL string `json:"image_url"`
+
+	// ID identifies the event to its provider, such as a delivery ID or an
+	// `Idempotency-Key` header. Events with the same ID are only applied
+	// once.
+	ID string `json:"id,omitempty"`
+
+	Operator string `json:"operator,omitempty"`
+
+	// OccurAt is when the provider says the event happened, and ReceivedAt
+	// is when rollingpin received it, if that was earlier than when it was
+	// handed to the pipeline.
+	OccurAt    time.Time `json:"occur_at,omitempty"`
+	ReceivedAt time.Time `json:"received_at,omitempty"`
+
+	// Labels are extra attributes of the event, available to mapping
+	// policies.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// ApprovedBy is set when the event is being applied because someone
+	// approved it, so that it bypasses the approval gate.
+	ApprovedBy string `json:"approved_by,omitempty"`
+
+	// Debounced is set once the event has waited out its mapping's debounce
+	// window, so that it isn't held again.
+	Debounced bool `json:"debounced,omitempty"`
+
+	// job is the ID of the job applying the event, if any.
+	job string
+}
@@ -0,0 +1,56 @@
+package deploy
+
+import (
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/policy"
+)
+
+// checkPolicies evaluates the mapping's CEL policies against the event and
+// the deployment's current state. It reports whether the event is allowed,
+// and if not, which expression refused it.
+func checkPolicies(m *config.ImageMapping, e *Event, current *kube.Deployment) (bool, string, error) {
+	p, err := policy.Compile(m.Policies)
+	if err != nil {
+		return false, "", err
+	}
+	return p.Eval(&policy.Input{Event: eventInput(e), Workload: workloadInput(m, current)})
+}
+
+func eventInput(e *Event) map[string]interface{} {
+	labels := e.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return map[string]interface{}{
+		"repository": e.Repository,
+		"tag":        e.Tag,
+		"digest":     e.Digest,
+		"image":      e.ImageURL,
+		"operator":   e.Operator,
+		"provider":   e.Provider,
+		"labels":     labels,
+		"occur_at":   e.OccurAt,
+	}
+}
+
+func workloadInput(m *config.ImageMapping, d *kube.Deployment) map[string]interface{} {
+	annotations := d.Annotations
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	workload := map[string]interface{}{
+		"name":               d.Name,
+		"namespace":          d.Namespace,
+		"image":              runningImage(m, d),
+		"tag":                runningTag(m, d),
+		"annotations":        annotations,
+		"replicas":           0,
+		"available_replicas": 0,
+	}
+	if d.Status != nil {
+		workload["replicas"] = d.Status.Replicas
+		workload["available_replicas"] = d.Status.AvailableReplicas
+	}
+	return workload
+}
@@ -0,0 +1,53 @@
+package deploy
+
+import (
+	"testing"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.uber.org/zap"
+)
+
+func TestPipelineApplyPolicies(t *testing.T) {
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:        "app",
+		Namespace:   "default",
+		Annotations: map[string]string{"team": "payments"},
+		Containers:  []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/debian:1"}},
+	})
+	m := &config.ImageMapping{
+		ImageName:      "library/debian",
+		DeploymentName: "app",
+		Namespace:      "default",
+		Policies: []string{
+			`event.operator == "ci-bot" && !event.tag.startsWith("pr-")`,
+			`workload.annotations["team"] == "payments" && workload.tag != event.tag`,
+		},
+	}
+	p := &Pipeline{Config: &config.Config{}, Logger: zap.NewNop(), Client: client}
+
+	refused := []*Event{
+		{Operator: "someone", ImageURL: "cr.b8s.dev/library/debian:2"},
+		{Operator: "ci-bot", ImageURL: "cr.b8s.dev/library/debian:pr-12"},
+		{Operator: "ci-bot", ImageURL: "cr.b8s.dev/library/debian:1"},
+	}
+	for _, e := range refused {
+		result, err := p.Apply(m, e)
+		if err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+		if result.Status != StatusSkipped {
+			t.Errorf("Policy should have refused %s by %s, got %s", e.ImageURL, e.Operator, result.Status)
+		}
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Refused events were deployed: %s", d.Containers[0].Image)
+	}
+
+	result, err := p.Apply(m, &Event{Operator: "ci-bot", Labels: map[string]string{"branch": "main"}, ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil || result.Status != StatusDeployed {
+		t.Fatalf("Policy should have allowed the event: %+v %v", result, err)
+	}
+}
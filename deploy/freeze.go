@@ -0,0 +1,119 @@
+package deploy
+
+import (
+	"fmt"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/freeze"
+	"go.uber.org/zap"
+)
+
+// checkFreeze returns a non-nil Result if the mapping is currently frozen,
+// either rejecting the event or holding it until the freeze ends, depending
+// on the freeze mode.
+func (p *Pipeline) checkFreeze(m *config.ImageMapping, e *Event) (*Result, error) {
+	policy, err := p.freezePolicy(m)
+	if err != nil {
+		return nil, err
+	}
+	decision := policy.Check(p.clock())
+	if !decision.Frozen {
+		return nil, nil
+	}
+	if policy.Mode == "queue" && !decision.OpensAt.IsZero() {
+		p.hold(m, e, decision)
+		opensAt := decision.OpensAt
+		return &Result{Status: StatusQueued, Reason: decision.Reason, OpensAt: &opensAt}, nil
+	}
+	p.Logger.Info("Deploy rejected by freeze",
+		zap.String("provider", e.Provider),
+		zap.String("image_name", m.ImageName),
+		zap.String("deployment", m.DeploymentName),
+		zap.String("tag", e.Tag),
+		zap.String("reason", decision.Reason))
+	return &Result{Status: StatusRejected, Reason: decision.Reason}, nil
+}
+
+// compiledFreeze is a mapping's freeze policy and the configs it was built
+// from.
+type compiledFreeze struct {
+	global  *config.FreezeConfig
+	mapping *config.FreezeConfig
+	policy  *freeze.Policy
+}
+
+// freezePolicy returns the mapping's freeze policy, compiling it the first
+// time it is needed. Policies are kept per deployment and compiled again
+// when its mapping, or the global freeze, is replaced.
+func (p *Pipeline) freezePolicy(m *config.ImageMapping) (*freeze.Policy, error) {
+	key := fmt.Sprintf("%s/%s", m.Namespace, m.DeploymentName)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cached, ok := p.freezes[key]; ok && cached.global == p.Config.Freeze && cached.mapping == m.Freeze {
+		return cached.policy, nil
+	}
+	policy, err := freeze.New(p.Config.Freeze, m.Freeze)
+	if err != nil {
+		return nil, err
+	}
+	if p.freezes == nil {
+		p.freezes = map[string]*compiledFreeze{}
+	}
+	p.freezes[key] = &compiledFreeze{global: p.Config.Freeze, mapping: m.Freeze, policy: policy}
+	return policy, nil
+}
+
+// heldEvent is an event waiting for the freeze on its mapping to end.
+type heldEvent struct {
+	event *Event
+	timer *time.Timer
+}
+
+// hold re-applies an event once the freeze on its mapping ends. Only the
+// latest held event for each deployment is kept.
+func (p *Pipeline) hold(m *config.ImageMapping, e *Event, decision freeze.Decision) {
+	key := fmt.Sprintf("%s/%s", m.Namespace, m.DeploymentName)
+	p.Logger.Info("Deploy queued until freeze ends",
+		zap.String("provider", e.Provider),
+		zap.String("image_name", m.ImageName),
+		zap.String("deployment", m.DeploymentName),
+		zap.String("tag", e.Tag),
+		zap.String("reason", decision.Reason),
+		zap.Time("opens_at", decision.OpensAt))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.held == nil {
+		p.held = map[string]*heldEvent{}
+	}
+	if previous, ok := p.held[key]; ok {
+		previous.timer.Stop()
+		p.closeJob(previous.event, JobSkipped, "superseded by a later event during the freeze")
+	}
+	held := &heldEvent{event: e}
+	held.timer = time.AfterFunc(decision.OpensAt.Sub(p.clock()), func() {
+		p.mu.Lock()
+		if p.held[key] != held {
+			p.mu.Unlock()
+			return
+		}
+		delete(p.held, key)
+		p.mu.Unlock()
+
+		if p.releaseJob(e) {
+			return
+		}
+		result, err := p.accept(m, e)
+		p.report(m, e, result, err)
+		if err != nil {
+			p.Logger.Info("Error while applying queued deploy", zap.Error(err))
+			return
+		}
+		p.Logger.Info("Applied queued deploy",
+			zap.String("image_name", m.ImageName),
+			zap.String("deployment", m.DeploymentName),
+			zap.String("status", string(result.Status)))
+	})
+	p.held[key] = held
+}
@@ -0,0 +1,93 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+)
+
+// freezeUntil blocks deploys from an hour ago until end, handling events
+// during the freeze as mode says.
+func freezeUntil(mode string, end time.Time) *config.FreezeConfig {
+	return &config.FreezeConfig{
+		Mode: mode,
+		Blocked: []config.FreezeWindow{
+			{Name: "incident", Start: time.Now().Add(-time.Hour), End: end},
+		},
+	}
+}
+
+func TestPipelineApplyFreezeReject(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	p.Config.Freeze = freezeUntil("reject", time.Now().Add(time.Hour))
+
+	result, err := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if result.Status != StatusRejected || result.Reason == "" {
+		t.Errorf("Apply should have rejected the deploy: %+v", result)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Apply should not deploy during a freeze, image was: %s", d.Containers[0].Image)
+	}
+}
+
+func TestPipelineApplyFreezeQueue(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	p.Config.Freeze = freezeUntil("queue", time.Now().Add(50*time.Millisecond))
+	results := make(chan *Result, 3)
+	p.OnResult(func(_ *config.ImageMapping, _ *Event, result *Result, _ error) { results <- result })
+
+	p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:2"})
+	result, err := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:3"})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if result.Status != StatusQueued || result.OpensAt == nil {
+		t.Errorf("Apply should have queued the deploy: %+v", result)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Apply should not deploy during a freeze, image was: %s", d.Containers[0].Image)
+	}
+
+	waitForImage(t, client, "cr.b8s.dev/library/debian:3")
+	for i := 0; i < 2; i++ {
+		if result := <-results; result.Status != StatusQueued {
+			t.Errorf("Held deploys should be reported as queued, got %+v", result)
+		}
+	}
+	select {
+	case result := <-results:
+		if result.Status != StatusDeployed {
+			t.Errorf("Released deploy should be reported as deployed, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Released deploy was never reported")
+	}
+}
+
+func TestPipelineFreezeReplaced(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	m.Freeze = freezeUntil("reject", time.Now().Add(time.Hour))
+
+	result, _ := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:2"})
+	if result.Status != StatusRejected {
+		t.Errorf("Apply should have rejected the deploy: %+v", result)
+	}
+	// Rebuilt mappings, like discovered ones, bring a new freeze config.
+	m.Freeze = &config.FreezeConfig{}
+	if result, _ := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:3"}); result.Status != StatusDeployed {
+		t.Errorf("Apply should use the replaced freeze config: %+v", result)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:3" {
+		t.Errorf("Deploy after the freeze was lifted was not applied: %s", d.Containers[0].Image)
+	}
+	if len(p.freezes) != 1 {
+		t.Errorf("Freeze policies should be kept per deployment, got %d", len(p.freezes))
+	}
+}
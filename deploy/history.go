@@ -0,0 +1,101 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.b8s.dev/rollingpin/state"
+)
+
+const defaultHistoryLimit = 50
+
+// HistoryEntry records a single update the pipeline made to a deployment.
+type HistoryEntry struct {
+	ID string `json:"id"`
+
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+
+	Image         string `json:"image"`
+	PreviousImage string `json:"previous_image,omitempty"`
+	Tag           string `json:"tag,omitempty"`
+	Digest        string `json:"digest,omitempty"`
+
+	Provider   string `json:"provider"`
+	Operator   string `json:"operator,omitempty"`
+	ApprovedBy string `json:"approved_by,omitempty"`
+
+	DeployedAt time.Time `json:"deployed_at"`
+}
+
+// History keeps the most recent updates made to each deployment.
+type History struct {
+	Backend state.Store
+
+	// Limit is how many entries are kept for each deployment. Defaults to
+	// 50.
+	Limit int
+}
+
+// Record adds an entry to the history, forgetting the deployment's oldest
+// entries if it is over the limit.
+func (h *History) Record(entry *HistoryEntry) error {
+	if entry.ID == "" {
+		id, err := newJobID()
+		if err != nil {
+			return err
+		}
+		// Prefixing the time keeps IDs in the order they were deployed.
+		entry.ID = fmt.Sprintf("%d-%s", entry.DeployedAt.UnixNano(), id)
+	}
+	if err := state.PutJSON(h.Backend, state.BucketHistory, entry.ID, entry); err != nil {
+		return err
+	}
+
+	entries, err := h.List(entry.Namespace, entry.Deployment)
+	if err != nil {
+		return err
+	}
+	limit := h.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	for i := limit; i < len(entries); i++ {
+		if err := h.Backend.Delete(state.BucketHistory, entries[i].ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the entries for a deployment, or for every deployment if
+// namespace and deployment are empty, newest first.
+func (h *History) List(namespace string, deployment string) ([]*HistoryEntry, error) {
+	saved, err := h.Backend.List(state.BucketHistory)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*HistoryEntry
+	for _, b := range saved {
+		entry := &HistoryEntry{}
+		if err := json.Unmarshal(b, entry); err != nil {
+			return nil, err
+		}
+		if namespace != "" && entry.Namespace != namespace {
+			continue
+		}
+		if deployment != "" && entry.Deployment != deployment {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].DeployedAt.Equal(entries[j].DeployedAt) {
+			return entries[i].ID > entries[j].ID
+		}
+		return entries[i].DeployedAt.After(entries[j].DeployedAt)
+	})
+	return entries, nil
+}
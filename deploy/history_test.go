@@ -0,0 +1,51 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/state"
+)
+
+func TestHistoryLimit(t *testing.T) {
+	h := &History{Backend: state.NewMemory(), Limit: 2}
+	start := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, tag := range []string{"1", "2", "3"} {
+		err := h.Record(&HistoryEntry{Namespace: "default", Deployment: "app", Tag: tag, DeployedAt: start.Add(time.Duration(i) * time.Minute)})
+		if err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	h.Record(&HistoryEntry{Namespace: "default", Deployment: "other", Tag: "1", DeployedAt: start})
+
+	entries, err := h.List("default", "app")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Tag != "3" || entries[1].Tag != "2" {
+		t.Errorf("Expected the two newest entries, newest first, got %+v", entries)
+	}
+	if entries, _ := h.List("", ""); len(entries) != 3 {
+		t.Errorf("Expected entries for every deployment, got %d", len(entries))
+	}
+}
+
+func TestPipelineRecordsHistory(t *testing.T) {
+	p, _, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	h := &History{Backend: state.NewMemory()}
+	p.History = h
+
+	_, err := p.Deploy(&Event{Provider: "harbor", Repository: "library/debian", Operator: "ci-bot", ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil {
+		t.Fatalf("Deploy failed: %v", err)
+	}
+	entries, _ := h.List("default", "app")
+	if len(entries) != 1 {
+		t.Fatalf("Expected one history entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Image != "cr.b8s.dev/library/debian:2" || e.PreviousImage != "cr.b8s.dev/library/debian:1" || e.Tag != "2" || e.Operator != "ci-bot" || e.Provider != "harbor" {
+		t.Errorf("Unexpected history entry: %+v", e)
+	}
+}
@@ -0,0 +1,155 @@
+package deploy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/state"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultIdempotencyTTL = 24 * time.Hour
+	idempotencyPruneEvery = time.Minute
+)
+
+// Idempotency remembers the results of recent events so that duplicate
+// deliveries, such as Harbor retrying a webhook that timed out, get the
+// original result instead of updating the deployment again.
+type Idempotency struct {
+	Backend state.Store
+	Logger  *zap.Logger
+
+	// TTL is how long events are remembered. Defaults to 24h.
+	TTL time.Duration
+
+	mu        sync.Mutex
+	inflight  map[string]chan struct{}
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// remembered is what is stored for each event.
+type remembered struct {
+	Result    *Result   `json:"result"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func NewIdempotency(conf config.IdempotencyConfig, backend state.Store, logger *zap.Logger) *Idempotency {
+	return &Idempotency{
+		Backend:  backend,
+		Logger:   logger,
+		TTL:      conf.TTL,
+		inflight: map[string]chan struct{}{},
+		now:      time.Now,
+	}
+}
+
+// idempotencyKey identifies an event for a mapping, by the event's ID if it
+// has one and otherwise by what was pushed and when. Events with neither
+// can't be told apart from a genuine repeat, and get no key.
+func idempotencyKey(m *config.ImageMapping, e *Event) string {
+	parts := []string{m.Namespace, m.DeploymentName}
+	switch {
+	case e.ID != "":
+		parts = append(parts, "id", e.Provider, e.ID)
+	case e.Digest != "" && !e.OccurAt.IsZero():
+		parts = append(parts, "event", e.Repository, e.Digest, e.OccurAt.UTC().Format(time.RFC3339Nano))
+	default:
+		return ""
+	}
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Do returns the remembered result for key if there is one. Otherwise it
+// runs apply and remembers its result. A duplicate that arrives while the
+// original is still being applied waits for it. Reports whether the result
+// was remembered.
+func (d *Idempotency) Do(key string, apply func() (*Result, error)) (*Result, bool, error) {
+	for {
+		d.mu.Lock()
+		wait, busy := d.inflight[key]
+		if !busy {
+			d.inflight[key] = make(chan struct{})
+		}
+		d.mu.Unlock()
+		if !busy {
+			break
+		}
+		<-wait
+	}
+	defer func() {
+		d.mu.Lock()
+		close(d.inflight[key])
+		delete(d.inflight, key)
+		d.mu.Unlock()
+	}()
+
+	var r remembered
+	err := state.GetJSON(d.Backend, state.BucketIdempotency, key, &r)
+	if err == nil && d.now().Before(r.ExpiresAt) {
+		return r.Result, true, nil
+	}
+	if err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, false, err
+	}
+
+	result, err := apply()
+	if err != nil {
+		// Failures aren't remembered, so that the sender can retry.
+		return nil, false, err
+	}
+	d.remember(key, result)
+	return result, false, nil
+}
+
+func (d *Idempotency) remember(key string, result *Result) {
+	now := d.now()
+	ttl := d.TTL
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	err := state.PutJSON(d.Backend, state.BucketIdempotency, key, &remembered{Result: result, ExpiresAt: now.Add(ttl)})
+	if err != nil {
+		d.Logger.Warn("Error while remembering event", zap.Error(err))
+	}
+
+	d.mu.Lock()
+	prune := now.Sub(d.lastPrune) >= idempotencyPruneEvery
+	if prune {
+		d.lastPrune = now
+	}
+	d.mu.Unlock()
+	if prune {
+		d.prune(now)
+	}
+}
+
+// prune forgets expired events.
+func (d *Idempotency) prune(now time.Time) {
+	saved, err := d.Backend.List(state.BucketIdempotency)
+	if err != nil {
+		d.Logger.Warn("Error while pruning remembered events", zap.Error(err))
+		return
+	}
+	for key, b := range saved {
+		var r remembered
+		if err := json.Unmarshal(b, &r); err == nil && now.Before(r.ExpiresAt) {
+			continue
+		}
+		if err := d.Backend.Delete(state.BucketIdempotency, key); err != nil {
+			d.Logger.Warn("Error while pruning remembered events", zap.Error(err))
+			return
+		}
+	}
+}
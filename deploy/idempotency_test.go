@@ -0,0 +1,99 @@
+package deploy
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/state"
+	"go.uber.org/zap"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	m := &config.ImageMapping{Namespace: "default", DeploymentName: "app"}
+	occurAt := time.Unix(1586922308, 0)
+	push := &Event{Provider: "harbor", Repository: "library/debian", Digest: "sha256:abc", OccurAt: occurAt}
+
+	if idempotencyKey(m, push) != idempotencyKey(m, &Event{Provider: "harbor", Repository: "library/debian", Digest: "sha256:abc", OccurAt: occurAt}) {
+		t.Errorf("Identical pushes should have the same key")
+	}
+	if idempotencyKey(m, push) == idempotencyKey(m, &Event{Provider: "harbor", Repository: "library/debian", Digest: "sha256:abc", OccurAt: occurAt.Add(time.Second)}) {
+		t.Errorf("Pushes at different times should have different keys")
+	}
+	if idempotencyKey(m, push) == idempotencyKey(&config.ImageMapping{Namespace: "default", DeploymentName: "other"}, push) {
+		t.Errorf("The same push to different mappings should have different keys")
+	}
+	if key := idempotencyKey(m, &Event{Repository: "library/debian", Digest: "sha256:abc"}); key != "" {
+		t.Errorf("Events without a time or ID should have no key, got %q", key)
+	}
+	if key := idempotencyKey(m, &Event{Provider: "direct", ID: "build-42"}); key == "" {
+		t.Errorf("Events with an ID should have a key")
+	}
+}
+
+func TestPipelineSuppressesDuplicates(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{OnUnchanged: "restart"})
+	now := time.Unix(1586922308, 0)
+	p.Idempotency = NewIdempotency(config.IdempotencyConfig{TTL: time.Hour}, state.NewMemory(), zap.NewNop())
+	p.Idempotency.now = func() time.Time { return now }
+	push := func() *Result {
+		result, err := p.Deploy(&Event{
+			Provider:   "harbor",
+			Repository: "library/debian",
+			Digest:     "sha256:abc",
+			ImageURL:   "cr.b8s.dev/library/debian:2",
+			OccurAt:    time.Unix(1586922300, 0),
+		})
+		if err != nil {
+			t.Fatalf("Deploy failed: %v", err)
+		}
+		return result
+	}
+
+	first := push()
+	d, _ := client.GetDeployment("default", "app")
+	restartedAt := d.PodAnnotations[kube.RestartedAtAnnotation]
+
+	// A redelivery would restart the deployment if it were applied.
+	second := push()
+	if second.Status != first.Status || second.Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("Duplicate should get the original result, got %+v", second)
+	}
+	if d, _ := client.GetDeployment("default", "app"); d.PodAnnotations[kube.RestartedAtAnnotation] != restartedAt {
+		t.Errorf("Duplicate should not have been applied")
+	}
+
+	now = now.Add(2 * time.Hour)
+	p.now = func() time.Time { return now }
+	push()
+	if d, _ := client.GetDeployment("default", "app"); d.PodAnnotations[kube.RestartedAtAnnotation] == restartedAt {
+		t.Errorf("Event should be applied again once it has been forgotten")
+	}
+}
+
+func TestIdempotencyConcurrentDuplicates(t *testing.T) {
+	idempotency := NewIdempotency(config.IdempotencyConfig{}, state.NewMemory(), zap.NewNop())
+	var applied int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, _, err := idempotency.Do("abc", func() (*Result, error) {
+				atomic.AddInt32(&applied, 1)
+				time.Sleep(10 * time.Millisecond)
+				return &Result{Status: StatusDeployed}, nil
+			})
+			if err != nil || result.Status != StatusDeployed {
+				t.Errorf("Unexpected result: %+v %v", result, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if applied != 1 {
+		t.Errorf("Expected one application, got %d", applied)
+	}
+}
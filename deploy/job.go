@@ -0,0 +1,94 @@
+package deploy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// JobState is where a Job is in its lifecycle.
+type JobState string
+
+const (
+	JobQueued   JobState = "queued"
+	JobApplying JobState = "applying"
+	// JobRollingOut means the deployment was updated and its rollout is
+	// being watched.
+	JobRollingOut JobState = "rolling-out"
+	// JobWaiting means the pipeline is holding the event, for a freeze
+	// window, an approval, a canary or a debounce window, and the job will
+	// close or be queued again once that is over.
+	JobWaiting   JobState = "waiting"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	// JobSkipped means the pipeline decided not to deploy the event, such as
+	// because of a tag policy or a later event superseding it.
+	JobSkipped JobState = "skipped"
+	// JobRejected means the event was refused, by a freeze, a pause or
+	// whoever decided on its approval.
+	JobRejected JobState = "rejected"
+	// JobRolledBack means the rollout failed and the deployment was put back
+	// to its previous image.
+	JobRolledBack JobState = "rolled-back"
+)
+
+// Job is an event waiting to be, or being, applied to a mapping by the job
+// queue.
+type Job struct {
+	ID string `json:"id"`
+
+	// Namespace and Deployment identify the mapping. Jobs for the same
+	// deployment run one at a time, in the order they were queued.
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+
+	Provider string `json:"provider"`
+	Event    Event  `json:"event"`
+
+	State    JobState `json:"state"`
+	Attempts int      `json:"attempts"`
+	Error    string   `json:"error,omitempty"`
+
+	// Reason says why a job was skipped or rejected, or what it is waiting
+	// for.
+	Reason string `json:"reason,omitempty"`
+
+	// Result is what the pipeline decided once the job was applied.
+	Result *Result `json:"result,omitempty"`
+
+	// OldImage and NewImage are what the deployment was changed from and
+	// to, once the job has updated it.
+	OldImage string `json:"old_image,omitempty"`
+	NewImage string `json:"new_image,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func (j *Job) key() string {
+	return j.Namespace + "/" + j.Deployment
+}
+
+func (j *Job) finished() bool {
+	switch j.State {
+	case JobSucceeded, JobFailed, JobSkipped, JobRejected, JobRolledBack:
+		return true
+	}
+	return false
+}
+
+// awaitingApproval reports whether a job is waiting on an approval request,
+// which unlike the pipeline's other holds outlives a restart.
+func (j *Job) awaitingApproval() bool {
+	return j.State == JobWaiting && j.Result != nil && j.Result.Status == StatusPendingApproval
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
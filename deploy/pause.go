@@ -0,0 +1,125 @@
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/state"
+	"go.uber.org/zap"
+)
+
+// Pause records that an operator stopped deploys to a mapping.
+type Pause struct {
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+
+	By       string    `json:"by"`
+	Reason   string    `json:"reason,omitempty"`
+	PausedAt time.Time `json:"paused_at"`
+}
+
+// Pauses keeps track of paused mappings. They are kept in the backend so
+// that every replica sees them.
+type Pauses struct {
+	Backend state.Store
+
+	now func() time.Time
+}
+
+// Pause stops events from being applied to the mapping until it is resumed.
+// Pausing a paused mapping replaces the pause.
+func (s *Pauses) Pause(m *config.ImageMapping, by string, reason string) (*Pause, error) {
+	pause := &Pause{
+		Namespace:  m.Namespace,
+		Deployment: m.DeploymentName,
+		By:         by,
+		Reason:     reason,
+		PausedAt:   s.clock(),
+	}
+	if err := state.PutJSON(s.Backend, state.BucketPauses, mappingKey(m), pause); err != nil {
+		return nil, err
+	}
+	return pause, nil
+}
+
+// Resume lets events be applied to the mapping again. Resuming a mapping
+// that isn't paused does nothing.
+func (s *Pauses) Resume(m *config.ImageMapping) error {
+	err := s.Backend.Delete(state.BucketPauses, mappingKey(m))
+	if errors.Is(err, state.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Get returns the mapping's pause, or nil if it isn't paused.
+func (s *Pauses) Get(m *config.ImageMapping) (*Pause, error) {
+	var pause Pause
+	err := state.GetJSON(s.Backend, state.BucketPauses, mappingKey(m), &pause)
+	if errors.Is(err, state.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pause, nil
+}
+
+// List returns every paused mapping, most recently paused first.
+func (s *Pauses) List() ([]*Pause, error) {
+	saved, err := s.Backend.List(state.BucketPauses)
+	if err != nil {
+		return nil, err
+	}
+	var pauses []*Pause
+	for _, b := range saved {
+		pause := &Pause{}
+		if err := json.Unmarshal(b, pause); err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, pause)
+	}
+	sort.Slice(pauses, func(i, j int) bool { return pauses[i].PausedAt.After(pauses[j].PausedAt) })
+	return pauses, nil
+}
+
+func (s *Pauses) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// checkPaused returns a rejected Result if the mapping has been paused,
+// either through the admin API or by annotating its deployment.
+func (p *Pipeline) checkPaused(m *config.ImageMapping, e *Event, current *kube.Deployment) (*Result, error) {
+	reason := ""
+	if current.Annotations[kube.PausedAnnotation] == "true" {
+		reason = "deployment is paused by the " + kube.PausedAnnotation + " annotation"
+	} else if p.Pauses != nil {
+		pause, err := p.Pauses.Get(m)
+		if err != nil {
+			return nil, err
+		}
+		if pause != nil {
+			reason = "mapping was paused by " + pause.By
+			if pause.Reason != "" {
+				reason += ": " + pause.Reason
+			}
+		}
+	}
+	if reason == "" {
+		return nil, nil
+	}
+	p.Logger.Info("Deploy rejected while paused",
+		zap.String("provider", e.Provider),
+		zap.String("image_name", m.ImageName),
+		zap.String("deployment", m.DeploymentName),
+		zap.String("tag", e.Tag),
+		zap.String("reason", reason))
+	return &Result{Status: StatusRejected, Reason: reason}, nil
+}
@@ -0,0 +1,65 @@
+package deploy
+
+import (
+	"strings"
+	"testing"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/state"
+	"go.uber.org/zap"
+)
+
+func TestPauseRejectsDeploys(t *testing.T) {
+	p, _, m := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	pauses := &Pauses{Backend: state.NewMemory()}
+	p.Pauses = pauses
+
+	if _, err := pauses.Pause(m, "alice", "incident"); err != nil {
+		t.Fatalf("Pause failed: %v", err)
+	}
+	result, err := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if result.Status != StatusRejected || result.Reason != "mapping was paused by alice: incident" {
+		t.Errorf("Paused mapping should reject deploys, got %+v", result)
+	}
+	if list, _ := pauses.List(); len(list) != 1 || list[0].Deployment != "app" {
+		t.Errorf("List should return the pause, got %v", list)
+	}
+
+	if err := pauses.Resume(m); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if err := pauses.Resume(m); err != nil {
+		t.Errorf("Resuming a mapping that isn't paused should do nothing, got %v", err)
+	}
+	result, _ = p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:2"})
+	if result.Status != StatusDeployed {
+		t.Errorf("Resumed mapping should deploy, got %+v", result)
+	}
+}
+
+func TestPauseAnnotation(t *testing.T) {
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:        "app",
+		Namespace:   "default",
+		Annotations: map[string]string{kube.PausedAnnotation: "true"},
+		Containers:  []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/debian:1"}},
+	})
+	m := &config.ImageMapping{ImageName: "library/debian", DeploymentName: "app", Namespace: "default"}
+	p := &Pipeline{Config: &config.Config{}, Logger: zap.NewNop(), Client: client}
+
+	result, err := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if result.Status != StatusRejected || !strings.Contains(result.Reason, kube.PausedAnnotation) {
+		t.Errorf("Annotated deployment should reject deploys, got %+v", result)
+	}
+	if d, _ := client.GetDeployment("default", "app"); d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Annotated deployment should not be updated, image was %s", d.Containers[0].Image)
+	}
+}
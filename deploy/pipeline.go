@@ -0,0 +1,480 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.b8s.dev/rollingpin/approval"
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/rollout"
+	"go.uber.org/zap"
+)
+
+// Pipeline applies Events from any provider to the deployments they are
+// mapped to.
+type Pipeline struct {
+	Config *config.Config
+	Logger *zap.Logger
+	Client kube.IClient
+
+	// Approvals holds deploys for mappings that require approval.
+	Approvals *approval.Store
+
+	// Jobs, if set, runs deploys in the background with retries. See
+	// StartJobs.
+	Jobs *JobQueue
+
+	// Rollouts, if set, watches each updated deployment until its rollout
+	// finishes.
+	Rollouts *rollout.Watcher
+
+	// History, if set, records every update the pipeline makes.
+	History *History
+
+	// Idempotency, if set, makes sure duplicate deliveries of an event are
+	// only applied once.
+	Idempotency *Idempotency
+
+	// Replay, if set, skips events that are too old or older than the last
+	// event applied to the same mapping.
+	Replay *ReplayGuard
+
+	// Pauses, if set, holds mappings that operators have paused. Deployments
+	// with the paused annotation are skipped either way.
+	Pauses *Pauses
+
+	mu        sync.Mutex
+	listeners []func(*config.ImageMapping, *Event, *Result, error)
+	held      map[string]*heldEvent
+	freezes   map[string]*compiledFreeze
+	canaries  map[string]*canaryRun
+	debounces map[string]*debounced
+	now       func() time.Time
+}
+
+// Deploy finds the mapping for the event's repository that accepts events
+// from its provider, and applies the event to it. Events that match no
+// mapping are ignored.
+func (p *Pipeline) Deploy(e *Event) (*Result, error) {
+	mappings := p.Config.AllMappings()
+	for i := range mappings {
+		m := &mappings[i]
+		if e.Repository == m.ImageName && m.AcceptsProvider(e.Provider) {
+			return p.Apply(m, e)
+		}
+	}
+	return &Result{Status: StatusIgnored}, nil
+}
+
+// OnResult registers a function to be called with the outcome of every event
+// applied to a mapping: once when it is received, and again when its job
+// runs if it was queued.
+func (p *Pipeline) OnResult(f func(*config.ImageMapping, *Event, *Result, error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listeners = append(p.listeners, f)
+}
+
+func (p *Pipeline) report(m *config.ImageMapping, e *Event, result *Result, err error) {
+	p.mu.Lock()
+	listeners := p.listeners
+	p.mu.Unlock()
+	for _, f := range listeners {
+		f(m, e, result, err)
+	}
+}
+
+// Apply updates the mapping's deployment to the event's image. It is used
+// directly by providers that have already decided which mapping an event is
+// for. If the pipeline has a job queue, the event is queued and applied in
+// the background. Duplicates of an event that has already been applied get
+// the original result.
+func (p *Pipeline) Apply(m *config.ImageMapping, e *Event) (*Result, error) {
+	result, err := p.applyOnce(m, e)
+	p.report(m, e, result, err)
+	return result, err
+}
+
+// applyOnce applies the event unless it is a replay or a duplicate.
+func (p *Pipeline) applyOnce(m *config.ImageMapping, e *Event) (*Result, error) {
+	if p.Replay != nil {
+		if reason := p.Replay.CheckAge(e); reason != "" {
+			p.logReplay(m, e, reason)
+			return &Result{Status: StatusSkipped, Reason: reason}, nil
+		}
+	}
+	if p.Idempotency == nil {
+		return p.accept(m, e)
+	}
+	key := idempotencyKey(m, e)
+	if key == "" {
+		return p.accept(m, e)
+	}
+	result, duplicate, err := p.Idempotency.Do(key, func() (*Result, error) {
+		return p.accept(m, e)
+	})
+	if duplicate {
+		p.Logger.Info("Ignoring duplicate event",
+			zap.String("provider", e.Provider),
+			zap.String("image_name", m.ImageName),
+			zap.String("deployment", m.DeploymentName),
+			zap.String("event_id", e.ID),
+			zap.String("digest", e.Digest))
+	}
+	return result, err
+}
+
+// accept queues the event as a job, or admits it straight away if there is
+// no job queue.
+func (p *Pipeline) accept(m *config.ImageMapping, e *Event) (*Result, error) {
+	if p.Jobs != nil {
+		job, err := p.Jobs.Enqueue(m.Namespace, m.DeploymentName, e)
+		if err != nil {
+			return nil, err
+		}
+		p.Logger.Info("Queued deploy",
+			zap.String("provider", e.Provider),
+			zap.String("image_name", m.ImageName),
+			zap.String("deployment", m.DeploymentName),
+			zap.String("job_id", job.ID))
+		return &Result{ID: job.ID, Status: StatusAccepted}, nil
+	}
+	return p.admit(m, e)
+}
+
+// StartJobs makes the pipeline queue events on q, and starts q's workers.
+// Jobs restored by q.Load that were rolling out are watched again.
+func (p *Pipeline) StartJobs(ctx context.Context, q *JobQueue) {
+	p.Jobs = q
+	if p.Rollouts != nil {
+		for _, job := range q.List("", "") {
+			if job.State != JobRollingOut {
+				continue
+			}
+			container := ""
+			if m := config.FindMapping(p.Config, job.Namespace, job.Deployment); m != nil {
+				container = m.Container
+			}
+			p.Rollouts.Track(rollout.Target{
+				Namespace:     job.Namespace,
+				Deployment:    job.Deployment,
+				Container:     container,
+				Image:         job.NewImage,
+				PreviousImage: job.OldImage,
+				Tag:           job.Event.Tag,
+				Digest:        job.Event.Digest,
+			})
+		}
+	}
+	q.Start(ctx, p.runJob)
+}
+
+// runJob makes a single attempt at applying a queued job.
+func (p *Pipeline) runJob(job *Job) (*Result, error) {
+	m := config.FindMapping(p.Config, job.Namespace, job.Deployment)
+	if m == nil {
+		return nil, fmt.Errorf("no mapping for %s/%s", job.Namespace, job.Deployment)
+	}
+	e := job.Event
+	e.job = job.ID
+	var result *Result
+	var err error
+	if e.Provider == rollbackProvider {
+		result, err = p.rollBackTo(m, &e)
+	} else {
+		result, err = p.admit(m, &e)
+	}
+	p.report(m, &e, result, err)
+	return result, err
+}
+
+// releaseJob queues the job holding an event again, and reports whether
+// there was one.
+func (p *Pipeline) releaseJob(e *Event) bool {
+	if e.job == "" || p.Jobs == nil {
+		return false
+	}
+	if err := p.Jobs.Release(e.job, e); err != nil {
+		p.Logger.Warn("Error while releasing job", zap.String("job_id", e.job), zap.Error(err))
+	}
+	return true
+}
+
+// closeJob finishes the job holding an event, if there is one.
+func (p *Pipeline) closeJob(e *Event, state JobState, reason string) {
+	if e.job == "" || p.Jobs == nil {
+		return
+	}
+	if err := p.Jobs.Close(e.job, state, reason); err != nil {
+		p.Logger.Warn("Error while closing job", zap.String("job_id", e.job), zap.Error(err))
+	}
+}
+
+// resolveJob records the outcome of the job holding an event, if there is
+// one.
+func (p *Pipeline) resolveJob(e *Event, result *Result, err error) {
+	if e.job == "" || p.Jobs == nil {
+		return
+	}
+	if err := p.Jobs.Resolve(e.job, result, err); err != nil {
+		p.Logger.Warn("Error while resolving job", zap.String("job_id", e.job), zap.Error(err))
+	}
+}
+
+// admit checks the event against the mapping's tag policy and debounce
+// before applying it.
+func (p *Pipeline) admit(m *config.ImageMapping, e *Event) (*Result, error) {
+	if e.Tag == "" {
+		_, e.Tag, _ = ParseImage(e.ImageURL)
+	}
+	if p.Replay != nil {
+		reason, err := p.Replay.CheckOrder(m, e)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			p.logReplay(m, e, reason)
+			return &Result{Status: StatusSkipped, Reason: reason}, nil
+		}
+	}
+	if err := AdmitTag(m, e.Tag); err != nil {
+		p.Logger.Info("Tag rejected by policy",
+			zap.String("provider", e.Provider),
+			zap.String("image_name", m.ImageName),
+			zap.String("deployment", m.DeploymentName),
+			zap.Error(err))
+		return &Result{Status: StatusSkipped, Reason: err.Error()}, nil
+	}
+	if m.Debounce != nil && e.ApprovedBy == "" && !e.Debounced {
+		return p.debounce(m, e), nil
+	}
+	return p.apply(m, e)
+}
+
+func (p *Pipeline) logReplay(m *config.ImageMapping, e *Event, reason string) {
+	p.Logger.Info("Skipping replayed event",
+		zap.String("provider", e.Provider),
+		zap.String("image_name", m.ImageName),
+		zap.String("deployment", m.DeploymentName),
+		zap.Time("occur_at", e.OccurAt),
+		zap.String("reason", reason))
+}
+
+// apply runs an admitted event through the rest of the pipeline.
+func (p *Pipeline) apply(m *config.ImageMapping, e *Event) (*Result, error) {
+	current, err := p.Client.GetDeployment(m.Namespace, m.DeploymentName)
+	if err != nil {
+		return nil, err
+	}
+	if result, err := p.checkPaused(m, e, current); result != nil || err != nil {
+		return result, err
+	}
+	if isDowngrade(m, runningTag(m, current), e.Tag) {
+		p.Logger.Info("Refusing to downgrade deployment",
+			zap.String("provider", e.Provider),
+			zap.String("image_name", m.ImageName),
+			zap.String("deployment", m.DeploymentName),
+			zap.String("current_tag", runningTag(m, current)),
+			zap.String("tag", e.Tag))
+		return &Result{Status: StatusSkipped, Reason: "refusing to downgrade from " + runningTag(m, current)}, nil
+	}
+
+	if len(m.Policies) > 0 {
+		allowed, expr, err := checkPolicies(m, e, current)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			p.Logger.Info("Event refused by policy",
+				zap.String("provider", e.Provider),
+				zap.String("image_name", m.ImageName),
+				zap.String("deployment", m.DeploymentName),
+				zap.String("tag", e.Tag),
+				zap.String("policy", expr))
+			return &Result{Status: StatusSkipped, Reason: "policy not satisfied: " + expr}, nil
+		}
+	}
+
+	if m.RequireApproval && e.ApprovedBy == "" {
+		return p.requestApproval(m, e)
+	}
+
+	if result, err := p.checkFreeze(m, e); result != nil || err != nil {
+		return result, err
+	}
+
+	update := &kube.ImageUpdate{Image: e.ImageURL, Container: m.Container, Annotations: map[string]string{}}
+	if m.PinDigest {
+		image, err := p.pinImage(e)
+		if err != nil {
+			return nil, err
+		}
+		update.Image = image
+		update.Annotations[kube.TagAnnotation] = e.Tag
+	}
+	if m.OnUnchanged != "" && unchanged(m, current, update.Image) {
+		proceed, err := p.handleUnchanged(m, e, update, current)
+		if err != nil {
+			return nil, err
+		}
+		if !proceed {
+			return &Result{Status: StatusSkipped, Reason: "image unchanged"}, nil
+		}
+	}
+
+	if m.Canary != nil {
+		return p.startCanary(m, e, update)
+	}
+
+	return p.update(m, e, update, current)
+}
+
+// update writes the update to the mapping's deployment and starts watching
+// its rollout. current is the deployment as it was before, if the caller
+// already has it.
+func (p *Pipeline) update(m *config.ImageMapping, e *Event, update *kube.ImageUpdate, current *kube.Deployment) (*Result, error) {
+	if current == nil {
+		d, err := p.Client.GetDeployment(m.Namespace, m.DeploymentName)
+		if err != nil {
+			return nil, err
+		}
+		current = d
+	}
+	result := &Result{Status: StatusDeployed, Image: update.Image, PreviousImage: runningImage(m, current)}
+
+	err := p.Client.UpdateDeployment(m.Namespace, m.DeploymentName, update)
+	p.Logger.Info("Updated deployment",
+		zap.String("provider", e.Provider),
+		zap.String("image_name", m.ImageName),
+		zap.String("deployment", m.DeploymentName),
+		zap.String("tag", e.Tag),
+		zap.String("image", update.Image))
+	if err != nil {
+		return nil, err
+	}
+	p.recordHistory(m, e, result)
+	if p.Replay != nil {
+		if err := p.Replay.Applied(m, e); err != nil {
+			p.Logger.Warn("Error while recording applied event",
+				zap.String("deployment", m.DeploymentName),
+				zap.Error(err))
+		}
+	}
+	if p.Rollouts != nil {
+		p.Rollouts.Track(rollout.Target{
+			Namespace:     m.Namespace,
+			Deployment:    m.DeploymentName,
+			Container:     m.Container,
+			Image:         update.Image,
+			PreviousImage: result.PreviousImage,
+			Tag:           e.Tag,
+			Digest:        e.Digest,
+			Rollback:      e.Provider == rollbackProvider,
+		})
+		result.rollingOut = true
+	}
+	return result, nil
+}
+
+// recordHistory adds a deploy to the history. Failing to record it doesn't
+// undo the deploy, so errors are only logged.
+func (p *Pipeline) recordHistory(m *config.ImageMapping, e *Event, result *Result) {
+	if p.History == nil {
+		return
+	}
+	err := p.History.Record(&HistoryEntry{
+		Namespace:     m.Namespace,
+		Deployment:    m.DeploymentName,
+		Image:         result.Image,
+		PreviousImage: result.PreviousImage,
+		Tag:           e.Tag,
+		Digest:        e.Digest,
+		Provider:      e.Provider,
+		Operator:      e.Operator,
+		ApprovedBy:    e.ApprovedBy,
+		DeployedAt:    p.clock(),
+	})
+	if err != nil {
+		p.Logger.Warn("Error while recording deploy history",
+			zap.String("deployment", m.DeploymentName),
+			zap.Error(err))
+	}
+}
+
+// handleUnchanged adjusts an update whose image is identical to the one
+// already running, which on its own would not trigger a rollout. This is
+// what happens when a mutable tag like `latest` is re-pushed. It reports
+// whether the update should still be applied.
+func (p *Pipeline) handleUnchanged(m *config.ImageMapping, e *Event, update *kube.ImageUpdate, current *kube.Deployment) (bool, error) {
+	switch m.OnUnchanged {
+	case "restart":
+		update.PodAnnotations = map[string]string{
+			kube.RestartedAtAnnotation: p.clock().Format(time.RFC3339),
+		}
+		p.Logger.Info("Image unchanged, restarting deployment",
+			zap.String("image_name", m.ImageName),
+			zap.String("deployment", m.DeploymentName))
+		return true, nil
+	case "digest":
+		// An update that is already by digest is the digest running.
+		if _, _, digest := ParseImage(update.Image); digest != "" {
+			return false, nil
+		}
+		image, err := p.pinImage(e)
+		if err != nil {
+			return false, err
+		}
+		if image == runningImage(m, current) {
+			return false, nil
+		}
+		update.Image = image
+		update.Annotations[kube.TagAnnotation] = e.Tag
+		p.Logger.Info("Image unchanged, switching deployment to digest",
+			zap.String("image_name", m.ImageName),
+			zap.String("deployment", m.DeploymentName),
+			zap.String("digest", e.Digest))
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown on_unchanged mode %q", m.OnUnchanged)
+	}
+}
+
+// unchanged reports whether deploying the image would leave the deployment
+// running what it already is: either the identical reference, or the same
+// tag that was previously pinned to a digest.
+func unchanged(m *config.ImageMapping, d *kube.Deployment, image string) bool {
+	running := runningImage(m, d)
+	if running == image {
+		return true
+	}
+	runningName, _, runningDigest := ParseImage(running)
+	name, tag, _ := ParseImage(image)
+	return runningDigest != "" && runningName == name && tag != "" && tag == d.Annotations[kube.TagAnnotation]
+}
+
+// runningImage returns the image the mapping's container is currently
+// running.
+func runningImage(m *config.ImageMapping, d *kube.Deployment) string {
+	return d.Image(m.Container)
+}
+
+// runningTag returns the tag of the image the deployment is currently
+// running.
+func runningTag(m *config.ImageMapping, d *kube.Deployment) string {
+	_, tag, _ := ParseImage(runningImage(m, d))
+	if tag == "" {
+		// Images pinned to a digest keep their tag in an annotation.
+		tag = d.Annotations[kube.TagAnnotation]
+	}
+	return tag
+}
+
+func (p *Pipeline) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
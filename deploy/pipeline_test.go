@@ -0,0 +1,196 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/approval"
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/rollout"
+	"go.uber.org/zap"
+)
+
+// testPipeline returns a pipeline with a single mapping, of library/debian to
+// the default/app deployment, which starts out running image, and an
+// in-memory approval store. Tests set whatever else they exercise on m.
+func testPipeline(t *testing.T, image string, m config.ImageMapping) (*Pipeline, *kube.Client, *config.ImageMapping) {
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: image}},
+	})
+	m.ImageName, m.DeploymentName, m.Namespace = "library/debian", "app", "default"
+	conf := &config.Config{Mappings: []config.ImageMapping{m}}
+	p := &Pipeline{Config: conf, Logger: zap.NewNop(), Client: client, Approvals: approval.NewStore(0)}
+	return p, client, &conf.Mappings[0]
+}
+
+// startJobs makes the pipeline queue events as jobs, which are attempted up
+// to attempts times. The first failures updates of the deployment fail.
+func startJobs(t *testing.T, p *Pipeline, failures int, attempts int) *JobQueue {
+	p.Client = &flakyClient{IClient: p.Client, failures: failures}
+	q := NewJobQueue(config.JobsConfig{MaxAttempts: attempts, Backoff: time.Millisecond}, zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	p.StartJobs(ctx, q)
+	return q
+}
+
+// waitFor polls until done returns true, and fails the test if it doesn't
+// within a couple of seconds.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitForImage waits until the default/app deployment runs image.
+func waitForImage(t *testing.T, client kube.IClient, image string) {
+	t.Helper()
+	waitFor(t, "the deployment to run "+image, func() bool {
+		d, _ := client.GetDeployment("default", "app")
+		return d != nil && d.Containers[0].Image == image
+	})
+}
+
+// waitForJob waits until a job has finished, and returns it.
+func waitForJob(t *testing.T, q *JobQueue, id string) *Job {
+	t.Helper()
+	var job *Job
+	waitFor(t, "job "+id+" to finish", func() bool {
+		job = q.Get(id)
+		return job != nil && job.finished()
+	})
+	return job
+}
+
+func TestPipelineDeploy(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+
+	_, err := p.Deploy(&Event{Repository: "library/alpine", ImageURL: "cr.b8s.dev/library/alpine:2"})
+	if err != nil {
+		t.Errorf("Deploy should ignore unmapped repositories: %v", err)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Deploy updated the wrong deployment: %s", d.Containers[0].Image)
+	}
+
+	_, err = p.Deploy(&Event{Repository: "library/debian", Tag: "2", ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil {
+		t.Errorf("Deploy failed: %v", err)
+	}
+	d, _ = client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("Deploy did not update the deployment: %s", d.Containers[0].Image)
+	}
+}
+
+func TestPipelineDeployFiltersProviders(t *testing.T) {
+	p, _, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{Providers: []string{"harbor"}})
+
+	result, err := p.Deploy(&Event{Provider: "direct", Repository: "library/debian", Tag: "2", ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil || result.Status != StatusIgnored {
+		t.Errorf("Deploy should ignore providers the mapping doesn't accept, got %+v, %v", result, err)
+	}
+	result, err = p.Deploy(&Event{Provider: "harbor", Repository: "library/debian", Tag: "2", ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil || result.Status != StatusDeployed {
+		t.Errorf("Deploy should apply events from the mapping's providers, got %+v, %v", result, err)
+	}
+}
+
+func TestPipelineApplyTagPolicy(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:1.4.2", config.ImageMapping{
+		TagPolicy: &config.TagPolicy{Semver: "~1.4", NoDowngrade: true},
+	})
+
+	for _, image := range []string{"cr.b8s.dev/library/debian:pr-123", "cr.b8s.dev/library/debian:1.4.1"} {
+		if _, err := p.Apply(m, &Event{ImageURL: image}); err != nil {
+			t.Errorf("Apply failed: %v", err)
+		}
+		d, _ := client.GetDeployment("default", "app")
+		if d.Containers[0].Image != "cr.b8s.dev/library/debian:1.4.2" {
+			t.Errorf("Apply should have rejected %s", image)
+		}
+	}
+
+	p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:1.4.3"})
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1.4.3" {
+		t.Errorf("Apply should have deployed 1.4.3, image was: %s", d.Containers[0].Image)
+	}
+}
+
+func TestPipelineApplyUnchangedRestart(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:latest", config.ImageMapping{OnUnchanged: "restart"})
+
+	if _, err := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:latest"}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.PodAnnotations[kube.RestartedAtAnnotation] == "" {
+		t.Errorf("Apply should have bumped the restart annotation: %v", d.PodAnnotations)
+	}
+}
+
+func TestPipelineApplyUnchangedDigest(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:latest", config.ImageMapping{OnUnchanged: "digest"})
+
+	p.Apply(m, &Event{Digest: "sha256:abc", ImageURL: "cr.b8s.dev/library/debian:latest"})
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian@sha256:abc" {
+		t.Errorf("Apply should have switched to the digest, image was: %s", d.Containers[0].Image)
+	}
+
+	p.Apply(m, &Event{Digest: "sha256:def", ImageURL: "cr.b8s.dev/library/debian:latest"})
+	d, _ = client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian@sha256:def" {
+		t.Errorf("Apply should have switched to the new digest, image was: %s", d.Containers[0].Image)
+	}
+
+	result, err := p.Apply(m, &Event{Digest: "sha256:def", ImageURL: "cr.b8s.dev/library/debian:latest"})
+	if err != nil || result.Status != StatusSkipped {
+		t.Errorf("Redelivering the running digest should be skipped, got %+v, %v", result, err)
+	}
+}
+
+func TestPipelineApplyUnchangedDefault(t *testing.T) {
+	p, client, m := testPipeline(t, "cr.b8s.dev/library/debian:latest", config.ImageMapping{})
+
+	if _, err := p.Apply(m, &Event{ImageURL: "cr.b8s.dev/library/debian:latest"}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if _, ok := d.PodAnnotations[kube.RestartedAtAnnotation]; ok {
+		t.Errorf("Apply should not restart by default: %v", d.PodAnnotations)
+	}
+}
+
+func TestPipelineTracksRollout(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	client.SetDeploymentStatus("default", "app", &kube.RolloutStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1})
+	watcher := &rollout.Watcher{Client: client, Logger: zap.NewNop(), Interval: 5 * time.Millisecond}
+	results := make(chan *rollout.Result, 1)
+	watcher.OnResult(func(r *rollout.Result) { results <- r })
+	p.Rollouts = watcher
+
+	if _, err := p.Deploy(&Event{Repository: "library/debian", Digest: "sha256:abc", ImageURL: "cr.b8s.dev/library/debian:2"}); err != nil {
+		t.Fatalf("Deploy failed: %v", err)
+	}
+	select {
+	case r := <-results:
+		if !r.Succeeded || r.Image != "cr.b8s.dev/library/debian:2" || r.Tag != "2" || r.Digest != "sha256:abc" {
+			t.Errorf("Unexpected rollout result: %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Rollout was not tracked")
+	}
+}
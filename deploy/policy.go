@@ -0,0 +1,159 @@
+package deploy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"go.b8s.dev/rollingpin/config"
+)
+
+const defaultTimestampLayout = "2006.01.02"
+
+// tagPolicy is a mapping's compiled tag policy.
+type tagPolicy struct {
+	*config.CompiledTagPolicy
+}
+
+// compilePolicy returns a mapping's compiled tag policy, or nil if it has
+// none.
+func compilePolicy(p *config.TagPolicy) (*tagPolicy, error) {
+	compiled, err := p.Compile()
+	if err != nil || compiled == nil {
+		return nil, err
+	}
+	return &tagPolicy{compiled}, nil
+}
+
+// AdmitTag returns an error if the mapping's tag policy does not allow the
+// tag to be deployed.
+func AdmitTag(m *config.ImageMapping, tag string) error {
+	tp, err := compilePolicy(m.TagPolicy)
+	if err != nil || tp == nil {
+		return err
+	}
+	if tp.Regexp != nil && !tp.Regexp.MatchString(tag) {
+		return fmt.Errorf("tag %q does not match %q", tag, tp.Pattern)
+	}
+	if tp.Glob != "" {
+		if ok, _ := path.Match(tp.Glob, tag); !ok {
+			return fmt.Errorf("tag %q does not match %q", tag, tp.Glob)
+		}
+	}
+	if tp.Constraint != nil {
+		return tp.admitSemver(tag)
+	}
+	return nil
+}
+
+func (tp *tagPolicy) admitSemver(tag string) error {
+	v, err := semver.NewVersion(tp.sortKey(tag))
+	if err != nil {
+		return fmt.Errorf("tag %q is not a semantic version", tag)
+	}
+	if v.Prerelease() != "" {
+		if !tp.Prerelease {
+			return fmt.Errorf("tag %q is a pre-release", tag)
+		}
+		release, _ := v.SetPrerelease("")
+		v = &release
+	}
+	if !tp.Constraint.Check(v) {
+		return fmt.Errorf("tag %q does not satisfy %q", tag, tp.Semver)
+	}
+	return nil
+}
+
+// CompareTags orders two tags according to the mapping's tag policy,
+// returning -1, 0 or 1 as a is lower than, equal to or higher than b. Tags
+// the policy cannot order compare as equal.
+func CompareTags(m *config.ImageMapping, a string, b string) int {
+	tp, err := compilePolicy(m.TagPolicy)
+	if err != nil || tp == nil {
+		return 0
+	}
+	ka, kb := tp.sortKey(a), tp.sortKey(b)
+	switch tp.Order {
+	case "semver":
+		va, errA := semver.NewVersion(ka)
+		vb, errB := semver.NewVersion(kb)
+		if errA != nil || errB != nil {
+			return 0
+		}
+		return va.Compare(vb)
+	case "numeric":
+		return compareNumeric(ka, kb)
+	case "lexical":
+		return strings.Compare(ka, kb)
+	case "timestamp":
+		layout := tp.TimestampLayout
+		if layout == "" {
+			layout = defaultTimestampLayout
+		}
+		ta, errA := time.Parse(layout, ka)
+		tb, errB := time.Parse(layout, kb)
+		if errA != nil || errB != nil {
+			return 0
+		}
+		if ta.Before(tb) {
+			return -1
+		} else if ta.After(tb) {
+			return 1
+		}
+		return 0
+	}
+	return 0
+}
+
+// sortKey returns the part of a tag that is ranked: the OrderBy capture
+// group if there is one, otherwise the whole tag.
+func (tp *tagPolicy) sortKey(tag string) string {
+	if tp.OrderBy == "" || tp.Regexp == nil {
+		return tag
+	}
+	match := tp.Regexp.FindStringSubmatch(tag)
+	if match == nil {
+		return ""
+	}
+	return match[tp.Regexp.SubexpIndex(tp.OrderBy)]
+}
+
+// compareNumeric compares two strings of decimal digits of any length.
+// Non-numeric keys compare as equal.
+func compareNumeric(a string, b string) int {
+	if !isDigits(a) || !isDigits(b) {
+		return 0
+	}
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isDowngrade reports whether deploying the candidate tag would move the
+// mapping backwards from the current tag under a NoDowngrade policy.
+func isDowngrade(m *config.ImageMapping, current string, candidate string) bool {
+	if m.TagPolicy == nil || !m.TagPolicy.NoDowngrade || current == "" {
+		return false
+	}
+	return CompareTags(m, candidate, current) < 0
+}
@@ -0,0 +1,138 @@
+package deploy
+
+import (
+	"testing"
+
+	"go.b8s.dev/rollingpin/config"
+)
+
+func TestAdmitTagSemver(t *testing.T) {
+	m := &config.ImageMapping{TagPolicy: &config.TagPolicy{Semver: ">=2.0.0 <3"}}
+	for _, tag := range []string{"2.0.0", "v2.3.1", "2.9"} {
+		if err := AdmitTag(m, tag); err != nil {
+			t.Errorf("AdmitTag should admit %s: %v", tag, err)
+		}
+	}
+	for _, tag := range []string{"1.9.9", "3.0.0", "latest", "pr-123", "2.1.0-rc.1"} {
+		if err := AdmitTag(m, tag); err == nil {
+			t.Errorf("AdmitTag should reject %s", tag)
+		}
+	}
+
+	m.TagPolicy.Prerelease = true
+	if err := AdmitTag(m, "2.1.0-rc.1"); err != nil {
+		t.Errorf("AdmitTag should admit pre-releases when allowed: %v", err)
+	}
+}
+
+func TestAdmitTagNoPolicy(t *testing.T) {
+	if err := AdmitTag(&config.ImageMapping{}, "debug"); err != nil {
+		t.Errorf("AdmitTag should admit anything without a policy: %v", err)
+	}
+}
+
+func TestAdmitTagInvalidConstraint(t *testing.T) {
+	m := &config.ImageMapping{TagPolicy: &config.TagPolicy{Semver: "~>banana"}}
+	if err := AdmitTag(m, "1.0.0"); err == nil {
+		t.Errorf("AdmitTag should fail for an invalid constraint")
+	}
+}
+
+func TestCompareTags(t *testing.T) {
+	m := &config.ImageMapping{TagPolicy: &config.TagPolicy{Semver: "*"}}
+	if CompareTags(m, "1.10.0", "1.9.0") != 1 {
+		t.Errorf("CompareTags should order versions numerically")
+	}
+	if CompareTags(m, "latest", "1.9.0") != 0 {
+		t.Errorf("CompareTags should not order non-versions")
+	}
+	if CompareTags(&config.ImageMapping{}, "2", "1") != 0 {
+		t.Errorf("CompareTags should not order tags without a policy")
+	}
+}
+
+func TestAdmitTagPattern(t *testing.T) {
+	m := &config.ImageMapping{TagPolicy: &config.TagPolicy{Pattern: `main-(?P<build>\d+)`}}
+	if err := AdmitTag(m, "main-42"); err != nil {
+		t.Errorf("AdmitTag should admit main-42: %v", err)
+	}
+	for _, tag := range []string{"main-42-debug", "pr-42", "main-"} {
+		if err := AdmitTag(m, tag); err == nil {
+			t.Errorf("AdmitTag should reject %s", tag)
+		}
+	}
+}
+
+func TestAdmitTagGlob(t *testing.T) {
+	m := &config.ImageMapping{TagPolicy: &config.TagPolicy{Glob: "release-*"}}
+	if err := AdmitTag(m, "release-2026.10"); err != nil {
+		t.Errorf("AdmitTag should admit release-2026.10: %v", err)
+	}
+	if err := AdmitTag(m, "main-1"); err == nil {
+		t.Errorf("AdmitTag should reject main-1")
+	}
+}
+
+func TestAdmitTagInvalidPolicy(t *testing.T) {
+	policies := []*config.TagPolicy{
+		{Pattern: "main-("},
+		{Glob: "[main"},
+		{Order: "alphabetical"},
+		{Pattern: `main-\d+`, OrderBy: "build"},
+	}
+	for _, p := range policies {
+		if err := AdmitTag(&config.ImageMapping{TagPolicy: p}, "main-1"); err == nil {
+			t.Errorf("AdmitTag should fail for invalid policy %+v", p)
+		}
+	}
+}
+
+func TestCompareTagsNumeric(t *testing.T) {
+	m := &config.ImageMapping{TagPolicy: &config.TagPolicy{
+		Pattern: `main-(?P<build>\d+)`,
+		Order:   "numeric",
+		OrderBy: "build",
+	}}
+	if CompareTags(m, "main-100", "main-99") != 1 {
+		t.Errorf("CompareTags should order build numbers numerically")
+	}
+	if CompareTags(m, "main-007", "main-7") != 0 {
+		t.Errorf("CompareTags should ignore leading zeroes")
+	}
+}
+
+func TestCompareTagsLexical(t *testing.T) {
+	m := &config.ImageMapping{TagPolicy: &config.TagPolicy{Order: "lexical"}}
+	if CompareTags(m, "b", "a") != 1 || CompareTags(m, "a", "b") != -1 {
+		t.Errorf("CompareTags should order tags lexically")
+	}
+}
+
+func TestCompareTagsTimestamp(t *testing.T) {
+	m := &config.ImageMapping{TagPolicy: &config.TagPolicy{
+		Pattern: `(?P<date>\d{4}\.\d{2}\.\d{2})-[0-9a-f]+`,
+		Order:   "timestamp",
+		OrderBy: "date",
+	}}
+	if CompareTags(m, "2026.10.18-abcdef", "2026.9.30-123456") != 0 {
+		t.Errorf("CompareTags should not order unparseable timestamps")
+	}
+	if CompareTags(m, "2026.10.18-abcdef", "2026.09.30-123456") != 1 {
+		t.Errorf("CompareTags should order tags by timestamp")
+	}
+}
+
+func TestIsDowngradeNumeric(t *testing.T) {
+	m := &config.ImageMapping{TagPolicy: &config.TagPolicy{
+		Pattern:     `main-(?P<build>\d+)`,
+		Order:       "numeric",
+		OrderBy:     "build",
+		NoDowngrade: true,
+	}}
+	if !isDowngrade(m, "main-10", "main-9") {
+		t.Errorf("main-9 should be a downgrade from main-10")
+	}
+	if isDowngrade(m, "main-10", "main-11") {
+		t.Errorf("main-11 should not be a downgrade from main-10")
+	}
+}
@@ -0,0 +1,641 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/state"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultJobWorkers    = 4
+	defaultJobAttempts   = 5
+	defaultJobBackoff    = time.Second
+	defaultJobMaxBackoff = time.Minute
+	defaultJobRetention  = 24 * time.Hour
+	defaultJobPoll       = 2 * time.Second
+)
+
+// JobQueue runs jobs on a pool of workers, retrying failures with
+// exponential backoff. Jobs for the same deployment never run concurrently.
+type JobQueue struct {
+	Config config.JobsConfig
+	Logger *zap.Logger
+
+	// Backend, if set, persists jobs so that they can be restored with Load
+	// after a restart.
+	Backend state.Store
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    map[string]*Job
+	pending []*Job
+	busy    map[string]bool
+	run     func(*Job) (*Result, error)
+	now     func() time.Time
+	standby bool
+
+	// early holds rollout outcomes that arrived before the job that started
+	// the rollout finished its attempt, by deployment.
+	early map[string]rolloutOutcome
+
+	// deferred holds changes to jobs that the pipeline made wait before
+	// their attempt finished, by job ID.
+	deferred map[string]func(*Job) error
+}
+
+func NewJobQueue(conf config.JobsConfig, logger *zap.Logger) *JobQueue {
+	q := &JobQueue{
+		Config:   conf,
+		Logger:   logger,
+		jobs:     map[string]*Job{},
+		busy:     map[string]bool{},
+		early:    map[string]rolloutOutcome{},
+		deferred: map[string]func(*Job) error{},
+		now:      time.Now,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Start runs the workers until the context is cancelled. run applies a
+// single attempt of a job.
+func (q *JobQueue) Start(ctx context.Context, run func(*Job) (*Result, error)) {
+	q.mu.Lock()
+	q.run = run
+	q.mu.Unlock()
+
+	workers := q.Config.Workers
+	if workers <= 0 {
+		workers = defaultJobWorkers
+	}
+	for i := 0; i < workers; i++ {
+		go q.work(ctx)
+	}
+	go func() {
+		<-ctx.Done()
+		q.mu.Lock()
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	}()
+}
+
+// SetStandby puts the queue in or out of standby. A queue in standby, on a
+// replica that isn't the leader, only saves new jobs to the backend for the
+// leader to run, and reads jobs back from the backend.
+func (q *JobQueue) SetStandby(standby bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.standby = standby
+}
+
+// Enqueue adds a job for the event and returns a copy of it.
+func (q *JobQueue) Enqueue(namespace string, deployment string, e *Event) (*Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune()
+	now := q.now()
+	job := &Job{
+		ID:         id,
+		Namespace:  namespace,
+		Deployment: deployment,
+		Provider:   e.Provider,
+		Event:      *e,
+		State:      JobQueued,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := q.persist(job); err != nil {
+		return nil, err
+	}
+	if q.standby && q.Backend != nil {
+		copied := *job
+		return &copied, nil
+	}
+	q.jobs[id] = job
+	q.pending = append(q.pending, job)
+	q.cond.Broadcast()
+	copied := *job
+	return &copied, nil
+}
+
+// Get returns a copy of a job, or nil if there is no such job.
+func (q *JobQueue) Get(id string) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.standby && q.Backend != nil {
+		job := &Job{}
+		if err := state.GetJSON(q.Backend, state.BucketJobs, id, job); err != nil {
+			return nil
+		}
+		return job
+	}
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil
+	}
+	copied := *job
+	return &copied
+}
+
+func (q *JobQueue) work(ctx context.Context) {
+	for {
+		job := q.next(ctx)
+		if job == nil {
+			return
+		}
+		q.process(ctx, job)
+
+		q.mu.Lock()
+		delete(q.busy, job.key())
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	}
+}
+
+// next blocks until there is a job whose deployment is not busy, and claims
+// it. It returns nil once the context is cancelled.
+func (q *JobQueue) next(ctx context.Context) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		for i, job := range q.pending {
+			if q.busy[job.key()] {
+				continue
+			}
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.busy[job.key()] = true
+			return job
+		}
+		q.cond.Wait()
+	}
+}
+
+// process runs a job until it succeeds or runs out of attempts.
+func (q *JobQueue) process(ctx context.Context, job *Job) {
+	attempts := q.Config.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultJobAttempts
+	}
+	for {
+		q.update(job, func() {
+			delete(q.early, job.key())
+			delete(q.deferred, job.ID)
+			job.State = JobApplying
+			job.Attempts++
+			if job.StartedAt == nil {
+				startedAt := q.now()
+				job.StartedAt = &startedAt
+			}
+		})
+		result, err := q.run(job)
+		if err == nil {
+			q.applied(job, result)
+			return
+		}
+
+		q.Logger.Info("Job attempt failed",
+			zap.String("job_id", job.ID),
+			zap.String("deployment", job.Deployment),
+			zap.Int("attempt", job.Attempts),
+			zap.Error(err))
+		if job.Attempts >= attempts {
+			q.update(job, func() {
+				job.State = JobFailed
+				job.Error = err.Error()
+				finishedAt := q.now()
+				job.FinishedAt = &finishedAt
+			})
+			return
+		}
+		q.update(job, func() {
+			job.State = JobQueued
+			job.Error = err.Error()
+		})
+
+		timer := time.NewTimer(q.backoff(job.Attempts))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// applied records the pipeline's result for a job. If the deployment was
+// updated and its rollout is being watched, the job stays open until
+// RolloutFinished. If the pipeline is holding the event, the job waits to be
+// released, closed or resolved.
+func (q *JobQueue) applied(job *Job, result *Result) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.record(job, result)
+}
+
+// record sets a job's state from the pipeline's result for it. The caller
+// must hold the lock.
+func (q *JobQueue) record(job *Job, result *Result) {
+	now := q.now()
+	defer q.save(job)
+	job.Error = ""
+	job.Reason = ""
+	job.Result = result
+	job.UpdatedAt = now
+	if result != nil {
+		job.OldImage, job.NewImage = result.PreviousImage, result.Image
+		job.Reason = result.Reason
+		switch result.Status {
+		case StatusSkipped, StatusIgnored, StatusCoalesced:
+			job.State = JobSkipped
+			job.FinishedAt = &now
+			return
+		case StatusRejected:
+			job.State = JobRejected
+			job.FinishedAt = &now
+			return
+		case StatusQueued, StatusPendingApproval, StatusCanary, StatusDebounced:
+			job.State = JobWaiting
+			if f, ok := q.deferred[job.ID]; ok {
+				delete(q.deferred, job.ID)
+				if err := f(job); err != nil {
+					q.Logger.Warn("Error while updating waiting job", zap.String("job_id", job.ID), zap.Error(err))
+				}
+			}
+			return
+		}
+	}
+	if result == nil || !result.rollingOut {
+		job.State = JobSucceeded
+		job.FinishedAt = &now
+		return
+	}
+	// Only the latest rollout of a deployment is watched, so an earlier job
+	// still waiting on its rollout will never hear how it went.
+	for _, other := range q.jobs {
+		if other != job && other.State == JobRollingOut && other.key() == job.key() {
+			other.State = JobFailed
+			other.Error = "superseded by job " + job.ID + " before its rollout finished"
+			other.UpdatedAt = now
+			other.FinishedAt = &now
+			q.save(other)
+		}
+	}
+	if outcome, ok := q.early[job.key()]; ok && outcome.image == job.NewImage {
+		delete(q.early, job.key())
+		job.State = outcome.state
+		job.Error = outcome.reason
+		job.FinishedAt = &now
+		return
+	}
+	job.State = JobRollingOut
+}
+
+// Release queues a waiting job again, to be applied with e, such as once it
+// has been approved or its debounce window has closed.
+func (q *JobQueue) Release(id string, e *Event) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.whenWaiting(id, func(job *Job) error {
+		released := *job
+		released.Event = *e
+		released.State = JobQueued
+		released.Reason = ""
+		released.UpdatedAt = q.now()
+		if err := q.persist(&released); err != nil {
+			return err
+		}
+		*job = released
+		if q.standby && q.Backend != nil {
+			// The leader picks the job up when it next polls.
+			return nil
+		}
+		q.pending = append(q.pending, job)
+		q.cond.Broadcast()
+		return nil
+	})
+}
+
+// Close finishes a waiting job without applying it again, such as when its
+// approval is rejected or a later event supersedes it.
+func (q *JobQueue) Close(id string, state JobState, reason string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.whenWaiting(id, func(job *Job) error {
+		now := q.now()
+		closed := *job
+		closed.State = state
+		if state == JobFailed {
+			closed.Error = reason
+		} else {
+			closed.Reason = reason
+		}
+		closed.UpdatedAt = now
+		closed.FinishedAt = &now
+		if err := q.persist(&closed); err != nil {
+			return err
+		}
+		*job = closed
+		return nil
+	})
+}
+
+// Resolve records the outcome of a waiting job that the pipeline went on to
+// apply itself, such as a canary that was promoted or aborted.
+func (q *JobQueue) Resolve(id string, result *Result, err error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.whenWaiting(id, func(job *Job) error {
+		if err == nil {
+			q.record(job, result)
+			return nil
+		}
+		now := q.now()
+		failed := *job
+		failed.State = JobFailed
+		failed.Error = err.Error()
+		failed.UpdatedAt = now
+		failed.FinishedAt = &now
+		if err := q.persist(&failed); err != nil {
+			return err
+		}
+		*job = failed
+		return nil
+	})
+}
+
+// whenWaiting calls f with the waiting job with the given ID. If the job is
+// still in the attempt that is about to make it wait, f is called once the
+// attempt has been recorded. In standby, the job is read from the backend.
+// The caller must hold the lock.
+func (q *JobQueue) whenWaiting(id string, f func(*Job) error) error {
+	var job *Job
+	if q.standby && q.Backend != nil {
+		job = &Job{}
+		if err := state.GetJSON(q.Backend, state.BucketJobs, id, job); err != nil {
+			return fmt.Errorf("job %s: %w", id, err)
+		}
+	} else {
+		job = q.jobs[id]
+		if job == nil {
+			return fmt.Errorf("job %s not found", id)
+		}
+		if job.State == JobApplying {
+			q.deferred[id] = f
+			return nil
+		}
+	}
+	if job.State != JobWaiting {
+		return fmt.Errorf("job %s is %s, not waiting", id, job.State)
+	}
+	return f(job)
+}
+
+// rolloutOutcome is how a deployment's rollout of an image finished.
+type rolloutOutcome struct {
+	image  string
+	state  JobState
+	reason string
+}
+
+// RolloutFinished closes the job waiting on a deployment's rollout of image
+// with the given state.
+func (q *JobQueue) RolloutFinished(namespace string, deployment string, image string, state JobState, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	found := false
+	for _, job := range q.jobs {
+		if job.State != JobRollingOut || job.Namespace != namespace || job.Deployment != deployment || job.NewImage != image {
+			continue
+		}
+		now := q.now()
+		job.State = state
+		job.Error = reason
+		job.UpdatedAt = now
+		job.FinishedAt = &now
+		q.save(job)
+		found = true
+	}
+	if !found {
+		// Quick rollouts can finish while the job is still in its attempt.
+		q.early[namespace+"/"+deployment] = rolloutOutcome{image: image, state: state, reason: reason}
+	}
+}
+
+// List returns copies of the jobs for a deployment, or for every deployment
+// if namespace and deployment are empty, newest first.
+func (q *JobQueue) List(namespace string, deployment string) []*Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := q.jobs
+	if q.standby {
+		saved, err := q.saved()
+		if err != nil {
+			q.Logger.Warn("Error while listing jobs", zap.Error(err))
+		}
+		jobs = saved
+	}
+	var list []*Job
+	for _, job := range jobs {
+		if namespace != "" && job.Namespace != namespace {
+			continue
+		}
+		if deployment != "" && job.Deployment != deployment {
+			continue
+		}
+		copied := *job
+		list = append(list, &copied)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].ID > list[j].ID
+		}
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// backoff is the delay after the given number of failed attempts.
+func (q *JobQueue) backoff(attempts int) time.Duration {
+	delay, max := q.Config.Backoff, q.Config.MaxBackoff
+	if delay <= 0 {
+		delay = defaultJobBackoff
+	}
+	if max <= 0 {
+		max = defaultJobMaxBackoff
+	}
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+func (q *JobQueue) update(job *Job, f func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	f()
+	job.UpdatedAt = q.now()
+	q.save(job)
+}
+
+// Load restores the jobs saved in the backend, such as after a restart.
+// Jobs that had not finished applying are queued again, as are jobs waiting
+// on something the pipeline only holds in memory, like a canary or a
+// debounce window; jobs that were rolling out are left for the pipeline to
+// watch again. It must be called before Start.
+func (q *JobQueue) Load() error {
+	if q.Backend == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	saved, err := q.saved()
+	if err != nil {
+		return err
+	}
+	for _, job := range saved {
+		if _, ok := q.jobs[job.ID]; ok {
+			continue
+		}
+		if job.State == JobApplying || job.State == JobWaiting && !job.awaitingApproval() {
+			job.State = JobQueued
+		}
+		q.jobs[job.ID] = job
+		if job.State == JobQueued {
+			q.pending = append(q.pending, job)
+		}
+	}
+	sort.Slice(q.pending, func(i, j int) bool {
+		return q.pending[i].CreatedAt.Before(q.pending[j].CreatedAt)
+	})
+	return nil
+}
+
+// Poll adds jobs that other replicas saved to the backend to the queue,
+// every Config.PollInterval until the context is cancelled.
+func (q *JobQueue) Poll(ctx context.Context) {
+	interval := q.Config.PollInterval
+	if interval <= 0 {
+		interval = defaultJobPoll
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := q.adopt(); err != nil {
+			q.Logger.Warn("Error while polling for jobs", zap.Error(err))
+		}
+	}
+}
+
+// adopt queues saved jobs the queue doesn't know about yet, and picks up
+// waiting jobs that another replica released or closed.
+func (q *JobQueue) adopt() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	saved, err := q.saved()
+	if err != nil {
+		return err
+	}
+	var adopted []*Job
+	for id, job := range saved {
+		known, ok := q.jobs[id]
+		if ok && known.State == JobWaiting && job.State != JobWaiting {
+			*known = *job
+			if known.State == JobQueued {
+				adopted = append(adopted, known)
+			}
+			continue
+		}
+		if ok || job.State != JobQueued {
+			continue
+		}
+		q.jobs[id] = job
+		adopted = append(adopted, job)
+	}
+	sort.Slice(adopted, func(i, j int) bool {
+		return adopted[i].CreatedAt.Before(adopted[j].CreatedAt)
+	})
+	if len(adopted) > 0 {
+		q.pending = append(q.pending, adopted...)
+		q.cond.Broadcast()
+	}
+	return nil
+}
+
+// saved returns the jobs in the backend by ID. The caller must hold the
+// lock.
+func (q *JobQueue) saved() (map[string]*Job, error) {
+	jobs := map[string]*Job{}
+	if q.Backend == nil {
+		return jobs, nil
+	}
+	saved, err := q.Backend.List(state.BucketJobs)
+	if err != nil {
+		return nil, err
+	}
+	for id, b := range saved {
+		job := &Job{}
+		if err := json.Unmarshal(b, job); err != nil {
+			return nil, err
+		}
+		jobs[id] = job
+	}
+	return jobs, nil
+}
+
+// persist writes a job to the backend. The caller must hold the lock.
+func (q *JobQueue) persist(job *Job) error {
+	if q.Backend == nil {
+		return nil
+	}
+	return state.PutJSON(q.Backend, state.BucketJobs, job.ID, job)
+}
+
+// save is persist for callers that cannot fail: the job carries on in
+// memory, and is only lost if rollingpin restarts before it is next saved.
+func (q *JobQueue) save(job *Job) {
+	if err := q.persist(job); err != nil {
+		q.Logger.Warn("Error while saving job", zap.String("job_id", job.ID), zap.Error(err))
+	}
+}
+
+// prune forgets finished jobs older than the retention period. The caller
+// must hold the lock.
+func (q *JobQueue) prune() {
+	retention := q.Config.Retention
+	if retention <= 0 {
+		retention = defaultJobRetention
+	}
+	cutoff := q.now().Add(-retention)
+	for id, job := range q.jobs {
+		if job.finished() && job.FinishedAt.Before(cutoff) {
+			delete(q.jobs, id)
+			if q.Backend != nil {
+				if err := q.Backend.Delete(state.BucketJobs, id); err != nil {
+					q.Logger.Warn("Error while deleting job", zap.String("job_id", id), zap.Error(err))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/approval"
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/rollout"
+	"go.b8s.dev/rollingpin/state"
+	"go.uber.org/zap"
+)
+
+// flakyClient fails the first few updates, like an overloaded API server.
+type flakyClient struct {
+	kube.IClient
+	mu       sync.Mutex
+	failures int
+}
+
+func (c *flakyClient) UpdateDeployment(ns string, name string, u *kube.ImageUpdate) error {
+	c.mu.Lock()
+	if c.failures > 0 {
+		c.failures--
+		c.mu.Unlock()
+		return errors.New("the server is currently unable to handle the request")
+	}
+	c.mu.Unlock()
+	return c.IClient.UpdateDeployment(ns, name, u)
+}
+
+func TestPipelineQueuesJobs(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	q := startJobs(t, p, 2, 5)
+
+	result, err := p.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil {
+		t.Fatalf("Deploy failed: %v", err)
+	}
+	if result.Status != StatusAccepted || result.ID == "" {
+		t.Fatalf("Deploy should queue a job, got %+v", result)
+	}
+
+	job := waitForJob(t, q, result.ID)
+	if job.State != JobSucceeded || job.Attempts != 3 {
+		t.Errorf("Job should succeed on its third attempt: %+v", job)
+	}
+	if job.Result == nil || job.Result.Status != StatusDeployed {
+		t.Errorf("Job should record the pipeline result: %+v", job.Result)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("Job did not update the deployment: %s", d.Containers[0].Image)
+	}
+}
+
+func TestPipelineJobFails(t *testing.T) {
+	p, _, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	q := startJobs(t, p, 10, 3)
+
+	result, _ := p.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+	job := waitForJob(t, q, result.ID)
+	if job.State != JobFailed || job.Attempts != 3 || job.Error == "" {
+		t.Errorf("Job should fail after three attempts: %+v", job)
+	}
+}
+
+func TestJobQueueSerialisesWorkloads(t *testing.T) {
+	q := NewJobQueue(config.JobsConfig{Workers: 4}, zap.NewNop())
+	var mu sync.Mutex
+	running := map[string]int{}
+	var order []string
+	overlap := false
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q.Start(ctx, func(j *Job) (*Result, error) {
+		mu.Lock()
+		running[j.key()]++
+		if running[j.key()] > 1 {
+			overlap = true
+		}
+		if j.Deployment == "app" {
+			order = append(order, j.Event.Tag)
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running[j.key()]--
+		mu.Unlock()
+		return &Result{Status: StatusDeployed}, nil
+	})
+
+	var ids []string
+	for _, tag := range []string{"1", "2", "3"} {
+		for _, deployment := range []string{"app", "other"} {
+			job, _ := q.Enqueue("default", deployment, &Event{Tag: tag})
+			ids = append(ids, job.ID)
+		}
+	}
+	for _, id := range ids {
+		waitForJob(t, q, id)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if overlap {
+		t.Errorf("Jobs for the same deployment ran concurrently")
+	}
+	if len(order) != 3 || order[0] != "1" || order[1] != "2" || order[2] != "3" {
+		t.Errorf("Jobs for a deployment should run in order, got %v", order)
+	}
+}
+
+func TestJobQueueBackoff(t *testing.T) {
+	q := NewJobQueue(config.JobsConfig{Backoff: time.Second, MaxBackoff: 10 * time.Second}, zap.NewNop())
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second}
+	for i, want := range expected {
+		if got := q.backoff(i + 1); got != want {
+			t.Errorf("backoff(%d) = %s, expected %s", i+1, got, want)
+		}
+	}
+}
+
+func TestJobRolledBackOnFailedRollout(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	q := startJobs(t, p, 0, 1)
+	p.Config.Mappings[0].RollbackOnFailure = true
+	p.History = &History{Backend: state.NewMemory()}
+	var mu sync.Mutex
+	var providers []string
+	p.OnResult(func(_ *config.ImageMapping, e *Event, _ *Result, _ error) {
+		mu.Lock()
+		defer mu.Unlock()
+		providers = append(providers, e.Provider)
+	})
+	client.SetDeploymentStatus("default", "app", &kube.RolloutStatus{Failed: true, Message: "progress deadline exceeded"})
+	watcher := &rollout.Watcher{Client: client, Logger: zap.NewNop(), Interval: 5 * time.Millisecond}
+	watcher.OnResult(p.RolloutFinished)
+	p.Rollouts = watcher
+
+	result, err := p.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil {
+		t.Fatalf("Deploy failed: %v", err)
+	}
+	job := waitForJob(t, q, result.ID)
+	if job.State != JobRolledBack || job.OldImage != "cr.b8s.dev/library/debian:1" || job.NewImage != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("Job should be rolled back: %+v", job)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Deployment should be back on its previous image, got %s", d.Containers[0].Image)
+	}
+	entries, _ := p.History.List("default", "app")
+	if len(entries) != 2 || entries[0].Image != "cr.b8s.dev/library/debian:1" || entries[0].Operator != autoRollbackOperator {
+		t.Errorf("Rollback should be recorded in the history: %+v", entries)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(providers) == 0 || providers[len(providers)-1] != rollbackProvider {
+		t.Errorf("Rollback should be reported to listeners, got %v", providers)
+	}
+}
+
+func TestJobNotRolledBackWhilePaused(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	startJobs(t, p, 0, 1)
+	m := &p.Config.Mappings[0]
+	m.RollbackOnFailure = true
+	p.Pauses = &Pauses{Backend: state.NewMemory()}
+	client.UpdateDeployment("default", "app", &kube.ImageUpdate{Image: "cr.b8s.dev/library/debian:2"})
+	if _, err := p.Pauses.Pause(m, "alice", "investigating"); err != nil {
+		t.Fatalf("Pause failed: %v", err)
+	}
+
+	p.RolloutFinished(&rollout.Result{Target: rollout.Target{
+		Namespace:     "default",
+		Deployment:    "app",
+		Image:         "cr.b8s.dev/library/debian:2",
+		PreviousImage: "cr.b8s.dev/library/debian:1",
+	}})
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("Paused deployment should not be rolled back, got %s", d.Containers[0].Image)
+	}
+}
+
+func TestJobRolloutStates(t *testing.T) {
+	q := NewJobQueue(config.JobsConfig{}, zap.NewNop())
+	first, _ := q.Enqueue("default", "app", &Event{ImageURL: "cr.b8s.dev/library/debian:2"})
+	second, _ := q.Enqueue("default", "app", &Event{ImageURL: "cr.b8s.dev/library/debian:3"})
+
+	q.applied(q.jobs[first.ID], &Result{Status: StatusDeployed, Image: "cr.b8s.dev/library/debian:2", rollingOut: true})
+	if job := q.Get(first.ID); job.State != JobRollingOut {
+		t.Fatalf("Job should be rolling out: %+v", job)
+	}
+
+	q.applied(q.jobs[second.ID], &Result{Status: StatusDeployed, Image: "cr.b8s.dev/library/debian:3", rollingOut: true})
+	if job := q.Get(first.ID); job.State != JobFailed {
+		t.Errorf("Superseded job should fail: %+v", job)
+	}
+
+	q.RolloutFinished("default", "app", "cr.b8s.dev/library/debian:3", JobSucceeded, "")
+	if job := q.Get(second.ID); job.State != JobSucceeded || job.FinishedAt == nil {
+		t.Errorf("Job should succeed once its rollout does: %+v", job)
+	}
+
+	if list := q.List("default", "app"); len(list) != 2 {
+		t.Errorf("Expected both jobs to be listed, got %d", len(list))
+	}
+	if list := q.List("other", ""); len(list) != 0 {
+		t.Errorf("Expected no jobs in other namespace, got %d", len(list))
+	}
+}
+
+func TestJobQueueLoad(t *testing.T) {
+	backend := state.NewMemory()
+	q := NewJobQueue(config.JobsConfig{}, zap.NewNop())
+	q.Backend = backend
+	queued, _ := q.Enqueue("default", "app", &Event{ImageURL: "cr.b8s.dev/library/debian:2"})
+	rolling, _ := q.Enqueue("default", "other", &Event{ImageURL: "cr.b8s.dev/library/debian:3"})
+	q.applied(q.jobs[rolling.ID], &Result{Status: StatusDeployed, Image: "cr.b8s.dev/library/debian:3", rollingOut: true})
+	debounced, _ := q.Enqueue("default", "debounced", &Event{ImageURL: "cr.b8s.dev/library/debian:4"})
+	q.applied(q.jobs[debounced.ID], &Result{Status: StatusDebounced})
+	pending, _ := q.Enqueue("default", "pending", &Event{ImageURL: "cr.b8s.dev/library/debian:5"})
+	q.applied(q.jobs[pending.ID], &Result{Status: StatusPendingApproval})
+
+	// A new queue stands in for rollingpin after a restart.
+	restored := NewJobQueue(config.JobsConfig{}, zap.NewNop())
+	restored.Backend = backend
+	if err := restored.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if job := restored.Get(queued.ID); job == nil || job.State != JobQueued || len(restored.pending) != 2 {
+		t.Errorf("Queued job should be queued again: %+v", job)
+	}
+	if job := restored.Get(debounced.ID); job == nil || job.State != JobQueued {
+		t.Errorf("Job held in memory should be queued again: %+v", job)
+	}
+	if job := restored.Get(pending.ID); job == nil || job.State != JobWaiting {
+		t.Errorf("Job awaiting approval should keep waiting: %+v", job)
+	}
+	if job := restored.Get(rolling.ID); job == nil || job.State != JobRollingOut || job.NewImage != "cr.b8s.dev/library/debian:3" {
+		t.Errorf("Rolling out job should be restored: %+v", job)
+	}
+
+	p, _, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.StartJobs(ctx, restored)
+	if job := waitForJob(t, restored, queued.ID); job.State != JobSucceeded {
+		t.Errorf("Restored job should run: %+v", job)
+	}
+	var saved Job
+	if err := state.GetJSON(backend, state.BucketJobs, queued.ID, &saved); err != nil || saved.State != JobSucceeded {
+		t.Errorf("Finished job should be saved: %+v %v", saved, err)
+	}
+}
+
+func TestJobQueueStandby(t *testing.T) {
+	backend := state.NewMemory()
+	leader, _, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	q := startJobs(t, leader, 0, 1)
+	q.Backend = backend
+	q.Config.PollInterval = 5 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Poll(ctx)
+
+	// A follower shares the leader's config and backend, but never runs
+	// jobs itself.
+	follower := &Pipeline{Config: leader.Config, Logger: zap.NewNop(), Client: leader.Client}
+	standby := NewJobQueue(config.JobsConfig{}, zap.NewNop())
+	standby.Backend = backend
+	standby.SetStandby(true)
+	follower.Jobs = standby
+
+	result, err := follower.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+	if err != nil {
+		t.Fatalf("Deploy failed: %v", err)
+	}
+	if result.Status != StatusAccepted {
+		t.Fatalf("Follower should queue the job, got %+v", result)
+	}
+	if job := waitForJob(t, q, result.ID); job.State != JobSucceeded {
+		t.Errorf("Leader should run the follower's job: %+v", job)
+	}
+	if job := standby.Get(result.ID); job == nil || job.State != JobSucceeded {
+		t.Errorf("Follower should read the job's state from the backend: %+v", job)
+	}
+	if list := standby.List("default", "app"); len(list) != 1 {
+		t.Errorf("Follower should list jobs from the backend, got %d", len(list))
+	}
+}
+
+func TestJobStatesFollowResults(t *testing.T) {
+	q := NewJobQueue(config.JobsConfig{}, zap.NewNop())
+	expected := map[Status]JobState{
+		StatusDeployed:        JobSucceeded,
+		StatusSkipped:         JobSkipped,
+		StatusCoalesced:       JobSkipped,
+		StatusRejected:        JobRejected,
+		StatusQueued:          JobWaiting,
+		StatusPendingApproval: JobWaiting,
+		StatusCanary:          JobWaiting,
+		StatusDebounced:       JobWaiting,
+	}
+	for status, want := range expected {
+		job, _ := q.Enqueue("default", "app", &Event{})
+		q.applied(q.jobs[job.ID], &Result{Status: status, Reason: "because"})
+		job = q.Get(job.ID)
+		if job.State != want || job.Reason != "because" {
+			t.Errorf("Result %s should leave the job %s, got %+v", status, want, job)
+		}
+		if job.finished() != (want != JobWaiting) {
+			t.Errorf("Job %s should only be finished if it isn't waiting", job.State)
+		}
+	}
+}
+
+func TestJobWaitsForDebounce(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	q := startJobs(t, p, 0, 1)
+	p.Config.Mappings[0].Debounce = &config.DebounceConfig{Window: 30 * time.Millisecond}
+
+	first, _ := p.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+	second, _ := p.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:3"})
+	if job := waitForJob(t, q, first.ID); job.State != JobSkipped || job.Reason != "superseded by 3" {
+		t.Errorf("Superseded job should be skipped: %+v", job)
+	}
+	job := waitForJob(t, q, second.ID)
+	if job.State != JobSucceeded || job.NewImage != "cr.b8s.dev/library/debian:3" || job.Attempts != 2 {
+		t.Errorf("Job should be applied again once the window closes: %+v", job)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:3" {
+		t.Errorf("Debounced job did not update the deployment: %s", d.Containers[0].Image)
+	}
+}
+
+func TestJobWaitsForApproval(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	q := startJobs(t, p, 0, 1)
+	p.Config.Mappings[0].RequireApproval = true
+
+	approved, _ := p.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+	rejected, _ := p.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:3"})
+	var requests []*approval.Request
+	waitFor(t, "two pending requests", func() bool {
+		requests, _ = p.Approvals.List(approval.StatePending)
+		return len(requests) == 2
+	})
+	if job := q.Get(approved.ID); job.State != JobWaiting {
+		t.Fatalf("Job should wait for approval: %+v", job)
+	}
+	for _, req := range requests {
+		if req.JobID == rejected.ID {
+			p.Reject(req.ID, "alice")
+		} else if _, result, err := p.Approve(req.ID, "bob"); err != nil || result.ID != approved.ID {
+			t.Fatalf("Approve should queue the waiting job again, got %+v, %v", result, err)
+		}
+	}
+
+	if job := waitForJob(t, q, approved.ID); job.State != JobSucceeded || job.Event.ApprovedBy != "bob" {
+		t.Errorf("Approved job should be applied: %+v", job)
+	}
+	if job := waitForJob(t, q, rejected.ID); job.State != JobRejected || job.Reason != "rejected by alice" {
+		t.Errorf("Rejected job should be closed: %+v", job)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("Approved job did not update the deployment: %s", d.Containers[0].Image)
+	}
+}
+
+func TestJobApprovalExpires(t *testing.T) {
+	p, _, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	q := startJobs(t, p, 0, 1)
+	p.Config.Mappings[0].RequireApproval = true
+	p.Approvals = approval.NewStore(10 * time.Millisecond)
+
+	accepted, _ := p.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2"})
+	waitFor(t, "the job to wait for approval", func() bool {
+		job := q.Get(accepted.ID)
+		return job != nil && job.State == JobWaiting
+	})
+	waitFor(t, "the request to expire", func() bool {
+		expired, _ := p.Approvals.List(approval.StateExpired)
+		return len(expired) == 1
+	})
+	if err := p.sweepApprovals(); err != nil {
+		t.Fatalf("sweepApprovals failed: %v", err)
+	}
+	if job := q.Get(accepted.ID); job.State != JobRejected || job.Reason != "approval expired" {
+		t.Errorf("Job waiting on an expired approval should be closed: %+v", job)
+	}
+}
@@ -0,0 +1,20 @@
+package deploy
+
+import (
+	"strings"
+)
+
+// ParseImage splits an image reference such as
+// `cr.b8s.dev/library/debian:1.4@sha256:...` into its name, tag and digest.
+// Missing parts are returned as empty strings.
+func ParseImage(ref string) (name string, tag string, digest string) {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref, digest = ref[:i], ref[i+1:]
+	}
+	// A colon after the last slash separates the tag; one before it belongs
+	// to a registry port.
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref, tag = ref[:i], ref[i+1:]
+	}
+	return ref, tag, digest
+}
@@ -0,0 +1,24 @@
+package deploy
+
+import (
+	"testing"
+)
+
+func TestParseImage(t *testing.T) {
+	cases := []struct {
+		ref, name, tag, digest string
+	}{
+		{"debian", "debian", "", ""},
+		{"cr.b8s.dev/library/debian:1.4", "cr.b8s.dev/library/debian", "1.4", ""},
+		{"localhost:5000/debian", "localhost:5000/debian", "", ""},
+		{"localhost:5000/debian:v2", "localhost:5000/debian", "v2", ""},
+		{"cr.b8s.dev/debian@sha256:abc", "cr.b8s.dev/debian", "", "sha256:abc"},
+		{"cr.b8s.dev/debian:1.4@sha256:abc", "cr.b8s.dev/debian", "1.4", "sha256:abc"},
+	}
+	for _, c := range cases {
+		name, tag, digest := ParseImage(c.ref)
+		if name != c.name || tag != c.tag || digest != c.digest {
+			t.Errorf("ParseImage(%q) = %q, %q, %q", c.ref, name, tag, digest)
+		}
+	}
+}
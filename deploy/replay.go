@@ -0,0 +1,98 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/state"
+)
+
+// ReplayGuard stops old events from being applied, so that a delayed retry
+// of an old push cannot roll a deployment backwards. Events without an
+// OccurAt are always allowed.
+type ReplayGuard struct {
+	Backend state.Store
+
+	// MaxAge is how old an event may be when it is received. Zero means any
+	// age.
+	MaxAge time.Duration
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+func NewReplayGuard(conf config.ReplayConfig, backend state.Store) *ReplayGuard {
+	return &ReplayGuard{Backend: backend, MaxAge: conf.MaxAge, now: time.Now}
+}
+
+// mappingKey identifies a mapping in the backend. Namespaces can't contain
+// dots, so the key is unambiguous.
+func mappingKey(m *config.ImageMapping) string {
+	return m.Namespace + "." + m.DeploymentName
+}
+
+// CheckAge returns why the event was too old when it was received, or "" if
+// it wasn't.
+func (g *ReplayGuard) CheckAge(e *Event) string {
+	if e.OccurAt.IsZero() || g.MaxAge <= 0 {
+		return ""
+	}
+	received := e.ReceivedAt
+	if received.IsZero() {
+		received = g.now()
+	}
+	if age := received.Sub(e.OccurAt); age > g.MaxAge {
+		return fmt.Sprintf("event is %s old, older than the maximum of %s", age.Round(time.Second), g.MaxAge)
+	}
+	return ""
+}
+
+// CheckOrder returns why the event may not be applied to the mapping,
+// because a newer event already has been, or "" if it may.
+func (g *ReplayGuard) CheckOrder(m *config.ImageMapping, e *Event) (string, error) {
+	if e.OccurAt.IsZero() {
+		return "", nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	last, err := g.lastApplied(m)
+	if err != nil {
+		return "", err
+	}
+	if e.OccurAt.Before(last) {
+		return fmt.Sprintf("event from %s predates the last applied event from %s",
+			e.OccurAt.UTC().Format(time.RFC3339), last.UTC().Format(time.RFC3339)), nil
+	}
+	return "", nil
+}
+
+// Applied records that the event was applied to the mapping.
+func (g *ReplayGuard) Applied(m *config.ImageMapping, e *Event) error {
+	if e.OccurAt.IsZero() {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	last, err := g.lastApplied(m)
+	if err != nil {
+		return err
+	}
+	if !e.OccurAt.After(last) {
+		return nil
+	}
+	return state.PutJSON(g.Backend, state.BucketApplied, mappingKey(m), e.OccurAt)
+}
+
+// lastApplied returns when the last event applied to the mapping happened.
+// The caller must hold the lock.
+func (g *ReplayGuard) lastApplied(m *config.ImageMapping) (time.Time, error) {
+	var last time.Time
+	err := state.GetJSON(g.Backend, state.BucketApplied, mappingKey(m), &last)
+	if errors.Is(err, state.ErrNotFound) {
+		return time.Time{}, nil
+	}
+	return last, err
+}
@@ -0,0 +1,75 @@
+package deploy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/state"
+)
+
+// replayGuard returns a guard against events over an hour old, whose clock
+// is stopped at now.
+func replayGuard(now time.Time) *ReplayGuard {
+	replay := NewReplayGuard(config.ReplayConfig{MaxAge: time.Hour}, state.NewMemory())
+	replay.now = func() time.Time { return now }
+	return replay
+}
+
+func TestReplayMaxAge(t *testing.T) {
+	now := time.Unix(1586922308, 0)
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	p.Replay = replayGuard(now)
+
+	result, err := p.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:2", OccurAt: now.Add(-2 * time.Hour)})
+	if err != nil {
+		t.Fatalf("Deploy failed: %v", err)
+	}
+	if result.Status != StatusSkipped || !strings.Contains(result.Reason, "older than the maximum") {
+		t.Errorf("Old event should be skipped, got %+v", result)
+	}
+	if d, _ := client.GetDeployment("default", "app"); d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Old event should not be deployed, image was %s", d.Containers[0].Image)
+	}
+
+	// An event that was held back is judged by when it was received.
+	result, _ = p.Deploy(&Event{
+		Repository: "library/debian",
+		ImageURL:   "cr.b8s.dev/library/debian:2",
+		OccurAt:    now.Add(-2 * time.Hour),
+		ReceivedAt: now.Add(-2*time.Hour + time.Minute),
+	})
+	if result.Status != StatusDeployed {
+		t.Errorf("Event received promptly should be deployed, got %+v", result)
+	}
+}
+
+func TestReplayOrder(t *testing.T) {
+	now := time.Unix(1586922308, 0)
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:1", config.ImageMapping{})
+	p.Replay = replayGuard(now)
+	deploy := func(tag string, occurAt time.Time) *Result {
+		result, err := p.Deploy(&Event{Repository: "library/debian", ImageURL: "cr.b8s.dev/library/debian:" + tag, OccurAt: occurAt})
+		if err != nil {
+			t.Fatalf("Deploy failed: %v", err)
+		}
+		return result
+	}
+
+	if result := deploy("3", now.Add(-time.Minute)); result.Status != StatusDeployed {
+		t.Fatalf("First event should be deployed, got %+v", result)
+	}
+	if result := deploy("2", now.Add(-2*time.Minute)); result.Status != StatusSkipped || !strings.Contains(result.Reason, "predates") {
+		t.Errorf("Earlier event should be skipped, got %+v", result)
+	}
+	if d, _ := client.GetDeployment("default", "app"); d.Containers[0].Image != "cr.b8s.dev/library/debian:3" {
+		t.Errorf("Deployment should not have gone backwards, image was %s", d.Containers[0].Image)
+	}
+	if result := deploy("4", now.Add(-time.Minute)); result.Status != StatusDeployed {
+		t.Errorf("Event from the same second should be deployed, got %+v", result)
+	}
+	if result := deploy("5", time.Time{}); result.Status != StatusDeployed {
+		t.Errorf("Event without a time should be deployed, got %+v", result)
+	}
+}
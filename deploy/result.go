@@ -0,0 +1,81 @@
+package deploy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Status is what the pipeline decided to do with an event.
+type Status string
+
+const (
+	// StatusDeployed means the deployment was updated.
+	StatusDeployed Status = "deployed"
+	// StatusSkipped means a policy decided the event should not be deployed.
+	StatusSkipped Status = "skipped"
+	// StatusIgnored means no mapping matched the event.
+	StatusIgnored Status = "ignored"
+	// StatusQueued means the event is being held and will be applied later.
+	StatusQueued Status = "queued"
+	// StatusRejected means the event was refused and will not be applied.
+	StatusRejected Status = "rejected"
+	// StatusPendingApproval means the event is waiting for someone to
+	// approve it.
+	StatusPendingApproval Status = "pending_approval"
+	// StatusCanary means the image is being tried out on a canary and the
+	// deployment will be updated if the canary stays healthy.
+	StatusCanary Status = "canary"
+	// StatusDebounced means the event is waiting for the mapping's debounce
+	// window to close and may still be superseded.
+	StatusDebounced Status = "debounced"
+	// StatusCoalesced means a later or higher-ranked event in the same
+	// debounce window was chosen instead.
+	StatusCoalesced Status = "coalesced"
+	// StatusAccepted means the event was queued as a job, identified by the
+	// result's ID.
+	StatusAccepted Status = "accepted"
+)
+
+// Result describes the outcome of running an event through the pipeline.
+type Result struct {
+	// ID identifies the approval request for pending events, or the job for
+	// accepted ones.
+	ID string `json:"id,omitempty"`
+
+	Status Status `json:"status"`
+	Reason string `json:"reason,omitempty"`
+
+	// OpensAt is when a queued or debounced event is expected to be applied.
+	OpensAt *time.Time `json:"opens_at,omitempty"`
+
+	// Image and PreviousImage are what a deployed event changed the
+	// deployment to and from.
+	Image         string `json:"image,omitempty"`
+	PreviousImage string `json:"previous_image,omitempty"`
+
+	// rollingOut is set when the deployment's rollout is being watched.
+	rollingOut bool
+}
+
+// Respond writes the webhook response for a pipeline result. Events that
+// were handled immediately get the plain `{"ok": true}` response; queued and
+// rejected events describe the decision so the sender knows why nothing
+// happened.
+func Respond(c *gin.Context, result *Result) {
+	if result == nil {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+		return
+	}
+	switch result.Status {
+	case StatusQueued, StatusPendingApproval, StatusCanary, StatusDebounced, StatusAccepted:
+		c.JSON(http.StatusAccepted, gin.H{"ok": true, "result": result})
+	case StatusCoalesced:
+		c.JSON(http.StatusOK, gin.H{"ok": true, "result": result})
+	case StatusRejected:
+		c.AbortWithStatusJSON(http.StatusLocked, gin.H{"ok": false, "result": result})
+	default:
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	}
+}
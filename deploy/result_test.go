@@ -0,0 +1,49 @@
+package deploy
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRespond(t *testing.T) {
+	opensAt := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	cases := []struct {
+		result *Result
+		code   int
+		body   string
+	}{
+		{nil, 200, `{"ok":true}`},
+		{&Result{Status: StatusDeployed}, 200, `{"ok":true}`},
+		{
+			&Result{Status: StatusQueued, Reason: "frozen", OpensAt: &opensAt},
+			202,
+			`{"ok":true,"result":{"status":"queued","reason":"frozen","opens_at":"2026-10-19T09:00:00Z"}}`,
+		},
+		{
+			&Result{ID: "abc123", Status: StatusPendingApproval},
+			202,
+			`{"ok":true,"result":{"id":"abc123","status":"pending_approval"}}`,
+		},
+		{
+			&Result{Status: StatusCoalesced, Reason: "superseded by 1.3.0"},
+			200,
+			`{"ok":true,"result":{"status":"coalesced","reason":"superseded by 1.3.0"}}`,
+		},
+		{
+			&Result{Status: StatusRejected, Reason: "frozen"},
+			423,
+			`{"ok":false,"result":{"status":"rejected","reason":"frozen"}}`,
+		},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		Respond(ctx, c.result)
+		if w.Code != c.code || w.Body.String() != c.body {
+			t.Errorf("Respond(%+v) = %d %s", c.result, w.Code, w.Body.String())
+		}
+	}
+}
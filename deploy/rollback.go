@@ -0,0 +1,106 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.uber.org/zap"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// rollbackProvider is the provider of the events Rollback applies.
+const rollbackProvider = "rollback"
+
+// Rollback puts a mapping's deployment back on an earlier image, given
+// either a revision from its ReplicaSets or the image itself. It is a
+// deliberate action by an operator, so it skips the pipeline's gates and
+// goes straight to the update. by is recorded in the deploy history, and the
+// outcome is passed to the OnResult listeners. If the pipeline has a job
+// queue, the rollback is queued as a job like any other deploy, so that only
+// the leader writes to the deployment.
+func (p *Pipeline) Rollback(m *config.ImageMapping, revision int64, image string, by string) (*Result, error) {
+	if (revision == 0) == (image == "") {
+		return nil, errors.New("exactly one of a revision or an image is required")
+	}
+	if revision != 0 {
+		revisions, err := p.Client.Revisions(m.Namespace, m.DeploymentName)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range revisions {
+			if r.Number == revision {
+				image = revisionImage(m, r)
+			}
+		}
+		if image == "" {
+			return nil, fmt.Errorf("%w: %d", ErrRevisionNotFound, revision)
+		}
+	}
+
+	p.Logger.Info("Rolling back deployment",
+		zap.String("image_name", m.ImageName),
+		zap.String("deployment", m.DeploymentName),
+		zap.Int64("revision", revision),
+		zap.String("image", image),
+		zap.String("triggered_by", by))
+	e := &Event{Provider: rollbackProvider, Repository: m.ImageName, ImageURL: image, Operator: by}
+	if p.Jobs != nil {
+		job, err := p.Jobs.Enqueue(m.Namespace, m.DeploymentName, e)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{ID: job.ID, Status: StatusAccepted}, nil
+	}
+	result, err := p.rollBackTo(m, e)
+	p.report(m, e, result, err)
+	return result, err
+}
+
+// rollBackTo updates the deployment to a rollback event's image.
+func (p *Pipeline) rollBackTo(m *config.ImageMapping, e *Event) (*Result, error) {
+	update := &kube.ImageUpdate{Image: e.ImageURL, Container: m.Container, Annotations: map[string]string{}}
+	_, e.Tag, e.Digest = ParseImage(e.ImageURL)
+	if e.Tag == "" && e.Digest != "" {
+		// Images pinned to a digest keep their tag in an annotation, which
+		// has to go back to the one the image was deployed with.
+		e.Tag = p.historicalTag(m, e.ImageURL)
+		if e.Tag != "" {
+			update.Annotations[kube.TagAnnotation] = e.Tag
+		}
+	}
+	return p.update(m, e, update, nil)
+}
+
+// revisionImage returns the image the mapping's container ran in a revision.
+func revisionImage(m *config.ImageMapping, r *kube.Revision) string {
+	if m.Container == "" {
+		return r.Image
+	}
+	for _, c := range r.Containers {
+		if c.Name == m.Container {
+			return c.Image
+		}
+	}
+	return ""
+}
+
+// historicalTag returns the tag an image was last deployed with, according
+// to the deploy history.
+func (p *Pipeline) historicalTag(m *config.ImageMapping, image string) string {
+	if p.History == nil {
+		return ""
+	}
+	entries, err := p.History.List(m.Namespace, m.DeploymentName)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if entry.Image == image {
+			return entry.Tag
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package deploy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/state"
+	"go.uber.org/zap"
+)
+
+// createRevisions gives the deployment two earlier revisions, the first
+// pinned to a digest.
+func createRevisions(client *kube.Client) {
+	created := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	client.CreateReplicaSet("default", "app", &kube.Revision{Number: 1, Image: "cr.b8s.dev/library/debian@sha256:111", ReplicaSet: "app-1", CreatedAt: created})
+	client.CreateReplicaSet("default", "app", &kube.Revision{Number: 2, Image: "cr.b8s.dev/library/debian:2", ReplicaSet: "app-2", CreatedAt: created})
+}
+
+func TestRollbackToRevision(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:3", config.ImageMapping{})
+	createRevisions(client)
+	p.History = &History{Backend: state.NewMemory()}
+	result, err := p.Rollback(&p.Config.Mappings[0], 2, "", "alice")
+	if err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if result.Status != StatusDeployed || result.PreviousImage != "cr.b8s.dev/library/debian:3" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if d, _ := client.GetDeployment("default", "app"); d.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("Deployment should be on revision 2's image, got %s", d.Containers[0].Image)
+	}
+	entries, _ := p.History.List("default", "app")
+	if len(entries) != 1 || entries[0].Provider != "rollback" || entries[0].Operator != "alice" {
+		t.Errorf("Rollback should be recorded against who triggered it: %+v", entries)
+	}
+
+	if _, err := p.Rollback(&p.Config.Mappings[0], 9, "", "alice"); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("Unknown revision should fail with ErrRevisionNotFound, got %v", err)
+	}
+	if _, err := p.Rollback(&p.Config.Mappings[0], 0, "", "alice"); err == nil {
+		t.Errorf("Rollback without a revision or image should fail")
+	}
+}
+
+func TestRollbackRestoresPinnedTag(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:3", config.ImageMapping{})
+	createRevisions(client)
+	p.History = &History{Backend: state.NewMemory()}
+	p.History.Record(&HistoryEntry{
+		Namespace:  "default",
+		Deployment: "app",
+		Image:      "cr.b8s.dev/library/debian@sha256:111",
+		Tag:        "1",
+		DeployedAt: time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC),
+	})
+
+	if _, err := p.Rollback(&p.Config.Mappings[0], 1, "", "alice"); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian@sha256:111" || d.Annotations[kube.TagAnnotation] != "1" {
+		t.Errorf("Deployment should be back on the pinned image and its tag: %s %v", d.Containers[0].Image, d.Annotations)
+	}
+}
+
+func TestRollbackQueuedAsJob(t *testing.T) {
+	p, client, _ := testPipeline(t, "cr.b8s.dev/library/debian:3", config.ImageMapping{})
+	createRevisions(client)
+	p.History = &History{Backend: state.NewMemory()}
+	backend := state.NewMemory()
+	standby := NewJobQueue(config.JobsConfig{}, zap.NewNop())
+	standby.Backend = backend
+	standby.SetStandby(true)
+	p.Jobs = standby
+
+	result, err := p.Rollback(&p.Config.Mappings[0], 2, "", "alice")
+	if err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if result.Status != StatusAccepted || result.ID == "" {
+		t.Fatalf("Rollback on a follower should be queued, got %+v", result)
+	}
+	if d, _ := client.GetDeployment("default", "app"); d.Containers[0].Image != "cr.b8s.dev/library/debian:3" {
+		t.Errorf("Follower should not update the deployment, got %s", d.Containers[0].Image)
+	}
+
+	leader := NewJobQueue(config.JobsConfig{}, zap.NewNop())
+	leader.Backend = backend
+	if err := leader.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	p.Jobs = nil
+	applied, err := p.runJob(leader.Get(result.ID))
+	if err != nil || applied.Status != StatusDeployed {
+		t.Fatalf("Leader should apply the rollback, got %+v, %v", applied, err)
+	}
+	if d, _ := client.GetDeployment("default", "app"); d.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("Deployment should be on revision 2's image, got %s", d.Containers[0].Image)
+	}
+}
@@ -0,0 +1,62 @@
+package deploy
+
+import (
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/rollout"
+	"go.uber.org/zap"
+)
+
+// autoRollbackOperator is who the deploy history says rolled back a failed
+// rollout for a mapping with RollbackOnFailure.
+const autoRollbackOperator = "rollingpin"
+
+// RolloutFinished handles the result of watching a deployment's rollout. If
+// the rollout failed and the mapping asks for it, the deployment is rolled
+// back to its previous image. The job that made the update is closed.
+func (p *Pipeline) RolloutFinished(r *rollout.Result) {
+	state, reason := JobSucceeded, ""
+	if !r.Succeeded {
+		state, reason = JobFailed, r.Reason
+		m := config.FindMapping(p.Config, r.Namespace, r.Deployment)
+		if m != nil && m.RollbackOnFailure && p.rollBack(m, r) {
+			state = JobRolledBack
+		}
+	}
+	if p.Jobs != nil {
+		p.Jobs.RolloutFinished(r.Namespace, r.Deployment, r.Image, state, reason)
+	}
+}
+
+// rollBack restores the image a failed rollout replaced through Rollback, so
+// that it is recorded and reported like any other rollback, and reports
+// whether it did.
+func (p *Pipeline) rollBack(m *config.ImageMapping, r *rollout.Result) bool {
+	// A rollback that fails is left alone, rather than rolled forward again.
+	if r.Rollback || r.PreviousImage == "" || r.PreviousImage == r.Image {
+		return false
+	}
+	d, err := p.Client.GetDeployment(r.Namespace, r.Deployment)
+	if err != nil {
+		p.Logger.Info("Error while rolling back deployment", zap.String("deployment", r.Deployment), zap.Error(err))
+		return false
+	}
+	// Leave deployments that have since moved on to another image alone.
+	if runningImage(m, d) != r.Image {
+		return false
+	}
+	e := &Event{Provider: rollbackProvider, Repository: m.ImageName, ImageURL: r.PreviousImage, Operator: autoRollbackOperator}
+	if result, err := p.checkPaused(m, e, d); result != nil || err != nil {
+		return false
+	}
+	if _, err := p.Rollback(m, 0, r.PreviousImage, autoRollbackOperator); err != nil {
+		p.Logger.Info("Error while rolling back deployment", zap.String("deployment", r.Deployment), zap.Error(err))
+		return false
+	}
+	p.Logger.Warn("Rolled back failed rollout",
+		zap.String("namespace", r.Namespace),
+		zap.String("deployment", r.Deployment),
+		zap.String("image", r.Image),
+		zap.String("previous_image", r.PreviousImage),
+		zap.String("reason", r.Reason))
+	return true
+}
@@ -0,0 +1,209 @@
+// Package discovery builds mappings from annotations on Deployments, so that
+// teams can opt their own Deployments in without editing the central config.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// ImageAnnotation is the image name, as in a mapping's `image`, that a
+	// Deployment should be updated to. Deployments without it are ignored.
+	ImageAnnotation = "rollingpin.b8s.dev/image"
+
+	// ContainerAnnotation names the container to update. Defaults to the
+	// first container.
+	ContainerAnnotation = "rollingpin.b8s.dev/container"
+
+	// ProvidersAnnotation is a comma-separated list of the providers to
+	// accept events from. Defaults to the discovery config's providers.
+	ProvidersAnnotation = "rollingpin.b8s.dev/providers"
+)
+
+const defaultResyncPeriod = 10 * time.Minute
+
+// Discoverer watches Deployments in the configured namespaces and keeps the
+// config's discovered mappings up to date.
+type Discoverer struct {
+	Client kubernetes.Interface
+	Config *config.Config
+	Logger *zap.Logger
+
+	mu      sync.Mutex
+	listers []appslisters.DeploymentLister
+	known   map[string]bool
+}
+
+// Start begins watching Deployments until ctx is done. It returns once the
+// Deployments that already exist have been discovered.
+func (d *Discoverer) Start(ctx context.Context) error {
+	conf := d.Config.Discovery
+	resync := conf.ResyncPeriod
+	if resync <= 0 {
+		resync = defaultResyncPeriod
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { d.rebuild() },
+		UpdateFunc: func(old interface{}, new interface{}) {
+			if mappingChanged(old, new) {
+				d.rebuild()
+			}
+		},
+		DeleteFunc: func(interface{}) { d.rebuild() },
+	}
+	var factories []informers.SharedInformerFactory
+	var synced []cache.InformerSynced
+	d.mu.Lock()
+	for _, ns := range conf.Namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(d.Client, resync, informers.WithNamespace(ns))
+		deployments := factory.Apps().V1().Deployments()
+		informer := deployments.Informer()
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			d.mu.Unlock()
+			return err
+		}
+		d.listers = append(d.listers, deployments.Lister())
+		synced = append(synced, informer.HasSynced)
+		factories = append(factories, factory)
+	}
+	d.mu.Unlock()
+
+	for _, factory := range factories {
+		factory.Start(ctx.Done())
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return errors.New("discovery: timed out waiting for deployments to sync")
+	}
+	d.rebuild()
+	return nil
+}
+
+// mappingChanged reports whether an update to a Deployment may change the
+// mapping it describes: its annotations or its containers. Status updates,
+// scaling and the image updates rollingpin makes itself don't.
+func mappingChanged(old interface{}, new interface{}) bool {
+	a, okA := old.(*appsv1.Deployment)
+	b, okB := new.(*appsv1.Deployment)
+	if !okA || !okB {
+		return true
+	}
+	for _, annotation := range []string{ImageAnnotation, ContainerAnnotation, ProvidersAnnotation} {
+		if a.Annotations[annotation] != b.Annotations[annotation] {
+			return true
+		}
+	}
+	ca, cb := a.Spec.Template.Spec.Containers, b.Spec.Template.Spec.Containers
+	if len(ca) != len(cb) {
+		return true
+	}
+	for i := range ca {
+		if ca[i].Name != cb[i].Name {
+			return true
+		}
+	}
+	return false
+}
+
+// rebuild replaces the discovered mappings with the ones described by the
+// Deployments currently in the informers' caches.
+func (d *Discoverer) rebuild() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var mappings []config.ImageMapping
+	for _, lister := range d.listers {
+		deployments, err := lister.List(labels.Everything())
+		if err != nil {
+			d.Logger.Warn("Error while listing deployments for discovery", zap.Error(err))
+			return
+		}
+		for _, deployment := range deployments {
+			if m, ok := d.mappingFor(deployment); ok {
+				mappings = append(mappings, m)
+			}
+		}
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		return key(&mappings[i]) < key(&mappings[j])
+	})
+	d.Config.SetDiscovered(config.SourceAnnotations, mappings)
+
+	known := map[string]bool{}
+	for i := range mappings {
+		m := &mappings[i]
+		known[key(m)] = true
+		if !d.known[key(m)] {
+			d.Logger.Info("Discovered mapping",
+				zap.String("image_name", m.ImageName),
+				zap.String("namespace", m.Namespace),
+				zap.String("deployment", m.DeploymentName),
+				zap.String("container", m.Container))
+		}
+	}
+	for k := range d.known {
+		if !known[k] {
+			d.Logger.Info("Mapping is no longer discovered", zap.String("mapping", k))
+		}
+	}
+	d.known = known
+}
+
+// mappingFor returns the mapping a Deployment's annotations describe, and
+// whether it has one.
+func (d *Discoverer) mappingFor(deployment *appsv1.Deployment) (config.ImageMapping, bool) {
+	image := strings.TrimSpace(deployment.Annotations[ImageAnnotation])
+	if image == "" {
+		return config.ImageMapping{}, false
+	}
+	m := config.ImageMapping{
+		ImageName:      image,
+		DeploymentName: deployment.Name,
+		Namespace:      deployment.Namespace,
+		Container:      strings.TrimSpace(deployment.Annotations[ContainerAnnotation]),
+		Providers:      d.Config.Discovery.Providers,
+	}
+	if providers := deployment.Annotations[ProvidersAnnotation]; providers != "" {
+		m.Providers = nil
+		for _, p := range strings.Split(providers, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				m.Providers = append(m.Providers, p)
+			}
+		}
+	}
+	if m.Container != "" && !hasContainer(deployment, m.Container) {
+		d.Logger.Warn("Ignoring annotated deployment without the named container",
+			zap.String("namespace", deployment.Namespace),
+			zap.String("deployment", deployment.Name),
+			zap.String("container", m.Container))
+		return config.ImageMapping{}, false
+	}
+	return m, true
+}
+
+func hasContainer(deployment *appsv1.Deployment, name string) bool {
+	for _, c := range deployment.Spec.Template.Spec.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func key(m *config.ImageMapping) string {
+	return m.Namespace + "/" + m.DeploymentName
+}
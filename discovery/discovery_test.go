@@ -0,0 +1,134 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func annotated(ns string, name string, annotations map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns, Annotations: annotations},
+		Spec: appsv1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{Containers: []v1.Container{
+					{Name: "sidecar", Image: "cr.b8s.dev/library/proxy:1"},
+					{Name: "app", Image: "cr.b8s.dev/library/someapp:1"},
+				}},
+			},
+		},
+	}
+}
+
+func create(t *testing.T, client kubernetes.Interface, d *appsv1.Deployment) {
+	if _, err := client.AppsV1().Deployments(d.Namespace).Create(context.TODO(), d, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Creating deployment failed: %v", err)
+	}
+}
+
+// waitForMappings polls until the config has n mappings.
+func waitForMappings(t *testing.T, conf *config.Config, n int) []config.ImageMapping {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mappings := conf.AllMappings()
+		if len(mappings) == n {
+			return mappings
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d mappings, got %+v", n, mappings)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	create(t, client, annotated("team-a", "someapp", map[string]string{
+		ImageAnnotation:     "library/someapp",
+		ContainerAnnotation: "app",
+		ProvidersAnnotation: "direct, generic",
+	}))
+	create(t, client, annotated("team-a", "unannotated", nil))
+	create(t, client, annotated("team-b", "other", map[string]string{ImageAnnotation: "library/other"}))
+	create(t, client, annotated("default", "static", map[string]string{ImageAnnotation: "library/discovered"}))
+	create(t, client, annotated("default", "nocontainer", map[string]string{
+		ImageAnnotation:     "library/nocontainer",
+		ContainerAnnotation: "missing",
+	}))
+	create(t, client, annotated("kube-system", "forbidden", map[string]string{ImageAnnotation: "library/forbidden"}))
+
+	conf := &config.Config{
+		Mappings: []config.ImageMapping{
+			{ImageName: "library/static", DeploymentName: "static", Namespace: "default"},
+		},
+		Discovery: config.DiscoveryConfig{
+			Enabled:    true,
+			Namespaces: []string{"default", "team-a", "team-b"},
+			Providers:  []string{"harbor"},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &Discoverer{Client: client, Config: conf, Logger: zap.NewNop()}
+	if err := d.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	mappings := waitForMappings(t, conf, 3)
+	if mappings[0].ImageName != "library/static" {
+		t.Errorf("Static mapping should take precedence, got %+v", mappings[0])
+	}
+	m := config.FindMapping(conf, "team-a", "someapp")
+	if m == nil || m.ImageName != "library/someapp" || m.Container != "app" {
+		t.Fatalf("Annotated deployment should be discovered, got %+v", m)
+	}
+	if len(m.Providers) != 2 || m.Providers[0] != "direct" || m.Providers[1] != "generic" {
+		t.Errorf("Providers annotation should be parsed, got %v", m.Providers)
+	}
+	if m := config.FindMapping(conf, "team-b", "other"); m == nil || len(m.Providers) != 1 || m.Providers[0] != "harbor" {
+		t.Errorf("Discovered mapping should default to the configured providers, got %+v", m)
+	}
+	if config.FindMapping(conf, "kube-system", "forbidden") != nil {
+		t.Errorf("Deployments outside the allowed namespaces should not be discovered")
+	}
+
+	create(t, client, annotated("team-b", "new", map[string]string{ImageAnnotation: "library/new"}))
+	waitForMappings(t, conf, 4)
+
+	if err := client.AppsV1().Deployments("team-a").Delete(context.TODO(), "someapp", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Deleting deployment failed: %v", err)
+	}
+	waitForMappings(t, conf, 3)
+	if config.FindMapping(conf, "team-a", "someapp") != nil {
+		t.Errorf("Deleted deployment should no longer be mapped")
+	}
+}
+
+func TestMappingChanged(t *testing.T) {
+	old := annotated("team-a", "someapp", map[string]string{ImageAnnotation: "library/someapp"})
+	updated := old.DeepCopy()
+	updated.Status.UpdatedReplicas = 2
+	updated.Spec.Template.Spec.Containers[1].Image = "cr.b8s.dev/library/someapp:2"
+	updated.Annotations["deployment.kubernetes.io/revision"] = "2"
+	if mappingChanged(old, updated) {
+		t.Errorf("Status, image and unrelated annotation updates should not change the mapping")
+	}
+	reannotated := old.DeepCopy()
+	reannotated.Annotations[ContainerAnnotation] = "app"
+	if !mappingChanged(old, reannotated) {
+		t.Errorf("Changing a discovery annotation should change the mapping")
+	}
+	renamed := old.DeepCopy()
+	renamed.Spec.Template.Spec.Containers[1].Name = "main"
+	if !mappingChanged(old, renamed) {
+		t.Errorf("Renaming a container should change the mapping")
+	}
+}
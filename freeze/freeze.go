@@ -0,0 +1,133 @@
+package freeze
+
+import (
+	"fmt"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+)
+
+// maxSteps bounds the search for the end of a freeze, which could otherwise
+// loop forever on pathological schedules.
+const maxSteps = 1000
+
+// Policy decides whether a deploy may happen at a given time.
+type Policy struct {
+	Mode    string
+	allowed []window
+	blocked []window
+}
+
+// Decision is the outcome of checking a Policy.
+type Decision struct {
+	Frozen bool
+	Reason string
+
+	// OpensAt is when deploys are next allowed. It is the zero time if the
+	// freeze never ends.
+	OpensAt time.Time
+}
+
+// New combines the global and mapping-level freeze config into a Policy.
+// Either may be nil. It returns nil if neither defines any windows.
+func New(global *config.FreezeConfig, mapping *config.FreezeConfig) (*Policy, error) {
+	if global == nil && mapping == nil {
+		return nil, nil
+	}
+	p := &Policy{Mode: "reject"}
+	for _, fc := range []*config.FreezeConfig{global, mapping} {
+		if fc == nil {
+			continue
+		}
+		if fc.Mode != "" {
+			p.Mode = fc.Mode
+		}
+		if len(fc.Allowed) > 0 {
+			allowed, err := compileWindows(fc.Allowed, fc.Timezone)
+			if err != nil {
+				return nil, err
+			}
+			p.allowed = allowed
+		}
+		blocked, err := compileWindows(fc.Blocked, fc.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		p.blocked = append(p.blocked, blocked...)
+	}
+	if p.Mode != "reject" && p.Mode != "queue" {
+		return nil, fmt.Errorf("unknown freeze mode %q", p.Mode)
+	}
+	return p, nil
+}
+
+func compileWindows(configs []config.FreezeWindow, timezone string) ([]window, error) {
+	var windows []window
+	for _, wc := range configs {
+		w, err := compileWindow(wc, timezone)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// Check decides whether deploys are frozen at time t. A nil Policy never
+// freezes.
+func (p *Policy) Check(t time.Time) Decision {
+	if p == nil {
+		return Decision{OpensAt: t}
+	}
+	var reason string
+	at := t
+	for i := 0; i < maxSteps; i++ {
+		if w, end := p.blockedAt(at); w != nil {
+			if reason == "" {
+				reason = fmt.Sprintf("inside blocked window %q", w.name())
+			}
+			at = end
+			continue
+		}
+		if len(p.allowed) > 0 && !p.allowedAt(at) {
+			if reason == "" {
+				reason = "outside all allowed windows"
+			}
+			at = p.nextAllowed(at)
+			if at.IsZero() {
+				break
+			}
+			continue
+		}
+		return Decision{Frozen: !at.Equal(t), Reason: reason, OpensAt: at}
+	}
+	return Decision{Frozen: true, Reason: reason}
+}
+
+func (p *Policy) blockedAt(t time.Time) (window, time.Time) {
+	for _, w := range p.blocked {
+		if ok, end := w.contains(t); ok {
+			return w, end
+		}
+	}
+	return nil, time.Time{}
+}
+
+func (p *Policy) allowedAt(t time.Time) bool {
+	for _, w := range p.allowed {
+		if ok, _ := w.contains(t); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) nextAllowed(t time.Time) time.Time {
+	var next time.Time
+	for _, w := range p.allowed {
+		if start := w.nextStart(t); !start.IsZero() && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+	return next
+}
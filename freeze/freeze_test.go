@@ -0,0 +1,119 @@
+package freeze
+
+import (
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+)
+
+func mustParse(t *testing.T, value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("Bad test time %q: %v", value, err)
+	}
+	return parsed
+}
+
+func TestPolicyNil(t *testing.T) {
+	p, err := New(nil, nil)
+	if err != nil || p != nil {
+		t.Fatalf("New should return nil without any config: %v, %v", p, err)
+	}
+	if d := p.Check(time.Now()); d.Frozen {
+		t.Errorf("A nil Policy should never be frozen")
+	}
+}
+
+func TestPolicyBlockedSchedule(t *testing.T) {
+	// Fridays from 17:00 London time until Monday 08:00.
+	p, err := New(&config.FreezeConfig{
+		Timezone: "Europe/London",
+		Blocked: []config.FreezeWindow{
+			{Name: "weekend", Schedule: "0 17 * * 5", Duration: 63 * time.Hour},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// 2026-10-16 is a Friday; London is on BST (UTC+1).
+	d := p.Check(mustParse(t, "2026-10-16T15:59:00Z"))
+	if d.Frozen {
+		t.Errorf("Friday afternoon should not be frozen: %+v", d)
+	}
+	d = p.Check(mustParse(t, "2026-10-17T12:00:00Z"))
+	if !d.Frozen {
+		t.Errorf("Saturday should be frozen: %+v", d)
+	}
+	if !d.OpensAt.Equal(mustParse(t, "2026-10-19T07:00:00Z")) {
+		t.Errorf("Freeze should end Monday 08:00 London, got: %v", d.OpensAt)
+	}
+}
+
+func TestPolicyAllowedWindows(t *testing.T) {
+	p, err := New(&config.FreezeConfig{
+		Allowed: []config.FreezeWindow{
+			{Name: "office hours", Schedule: "0 9 * * 1-5", Duration: 8 * time.Hour},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if d := p.Check(mustParse(t, "2026-10-19T10:00:00Z")); d.Frozen {
+		t.Errorf("Monday morning should be allowed: %+v", d)
+	}
+	d := p.Check(mustParse(t, "2026-10-19T18:00:00Z"))
+	if !d.Frozen || !d.OpensAt.Equal(mustParse(t, "2026-10-20T09:00:00Z")) {
+		t.Errorf("Monday evening should be frozen until Tuesday 09:00: %+v", d)
+	}
+}
+
+func TestPolicyMappingWindows(t *testing.T) {
+	global := &config.FreezeConfig{
+		Blocked: []config.FreezeWindow{
+			{
+				Name:  "holidays",
+				Start: mustParse(t, "2026-12-24T00:00:00Z"),
+				End:   mustParse(t, "2026-12-27T00:00:00Z"),
+			},
+		},
+	}
+	mapping := &config.FreezeConfig{
+		Mode: "queue",
+		Blocked: []config.FreezeWindow{
+			{
+				Name:  "incident",
+				Start: mustParse(t, "2026-12-26T12:00:00Z"),
+				End:   mustParse(t, "2026-12-28T00:00:00Z"),
+			},
+		},
+	}
+	p, err := New(global, mapping)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if p.Mode != "queue" {
+		t.Errorf("The mapping's mode should override the global one: %s", p.Mode)
+	}
+	d := p.Check(mustParse(t, "2026-12-25T00:00:00Z"))
+	if !d.Frozen || !d.OpensAt.Equal(mustParse(t, "2026-12-28T00:00:00Z")) {
+		t.Errorf("Back-to-back freezes should end after the last: %+v", d)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	configs := []*config.FreezeConfig{
+		{Mode: "sometimes"},
+		{Blocked: []config.FreezeWindow{{Schedule: "not cron", Duration: time.Hour}}},
+		{Blocked: []config.FreezeWindow{{Schedule: "0 17 * * 5"}}},
+		{Blocked: []config.FreezeWindow{{Name: "backwards", Start: time.Now(), End: time.Now().Add(-time.Hour)}}},
+		{Timezone: "Mars/Olympus_Mons", Blocked: []config.FreezeWindow{{Schedule: "0 17 * * 5", Duration: time.Hour}}},
+	}
+	for _, fc := range configs {
+		if _, err := New(fc, nil); err == nil {
+			t.Errorf("New should have rejected %+v", fc)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package freeze
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.b8s.dev/rollingpin/config"
+)
+
+// window is a span of time that deploys are either allowed or blocked in.
+type window interface {
+	// contains reports whether t falls inside the window, and if so when
+	// that occurrence of the window ends.
+	contains(t time.Time) (bool, time.Time)
+
+	// nextStart returns the next time the window opens after t, or the zero
+	// time if it never will.
+	nextStart(t time.Time) time.Time
+
+	name() string
+}
+
+func compileWindow(wc config.FreezeWindow, timezone string) (window, error) {
+	schedule, err := wc.ParseSchedule(timezone)
+	if err != nil {
+		return nil, err
+	}
+	if schedule != nil {
+		return &cronWindow{label: wc.Name, schedule: schedule, duration: wc.Duration}, nil
+	}
+	return &fixedWindow{label: wc.Name, start: wc.Start, end: wc.End}, nil
+}
+
+// cronWindow opens each time its schedule fires and stays open for a fixed
+// duration.
+type cronWindow struct {
+	label    string
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+func (w *cronWindow) contains(t time.Time) (bool, time.Time) {
+	// Any occurrence covering t must have started in (t-duration, t]. Walk
+	// them all in case occurrences overlap, keeping the latest end.
+	var end time.Time
+	for start := w.schedule.Next(t.Add(-w.duration)); !start.IsZero() && !start.After(t); start = w.schedule.Next(start) {
+		if e := start.Add(w.duration); e.After(t) && e.After(end) {
+			end = e
+		}
+	}
+	return !end.IsZero(), end
+}
+
+func (w *cronWindow) nextStart(t time.Time) time.Time {
+	return w.schedule.Next(t)
+}
+
+func (w *cronWindow) name() string {
+	return w.label
+}
+
+// fixedWindow is a single span of time, such as a holiday or an incident.
+type fixedWindow struct {
+	label string
+	start time.Time
+	end   time.Time
+}
+
+func (w *fixedWindow) contains(t time.Time) (bool, time.Time) {
+	if !t.Before(w.start) && t.Before(w.end) {
+		return true, w.end
+	}
+	return false, time.Time{}
+}
+
+func (w *fixedWindow) nextStart(t time.Time) time.Time {
+	if w.start.After(t) {
+		return w.start
+	}
+	return time.Time{}
+}
+
+func (w *fixedWindow) name() string {
+	return w.label
+}
@@ -0,0 +1,146 @@
+package kube
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TrackLabel marks canary Deployments and their pods. It is added to the
+// canary's selector, so the canary only counts its own pods. The primary's
+// selector is left as it is and so also matches the canary's pods, which is
+// what sends the canary a share of a Service's traffic. Kubernetes warns
+// against overlapping selectors like this: the Deployments still only manage
+// their own ReplicaSets, which they own, and each ReplicaSet's selector
+// includes its pod-template-hash, but anything else listing the primary's
+// pods by its selector, like `kubectl get pods -l`, sees the canary's too.
+const TrackLabel = "rollingpin.b8s.dev/track"
+
+// CanaryName is the name of the canary Deployment for a primary.
+func CanaryName(name string) string {
+	return name + "-canary"
+}
+
+// PodHealth summarises the pods that belong to a Deployment.
+type PodHealth struct {
+	Pods     int32
+	Ready    int32
+	Restarts int32
+}
+
+// ApplyCanary creates or updates the canary Deployment for the primary
+// `name`, copying the primary's pod template with the update applied.
+func (c *Client) ApplyCanary(ns string, name string, u *ImageUpdate, replicas int32) error {
+	client := c.clientset.AppsV1().Deployments(ns)
+	primary, err := client.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	canary := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      CanaryName(name),
+			Namespace: ns,
+			Labels:    withTrack(primary.Labels),
+		},
+		Spec: *primary.Spec.DeepCopy(),
+	}
+	canary.Spec.Replicas = &replicas
+	selector := &metav1.LabelSelector{}
+	if primary.Spec.Selector != nil {
+		selector = primary.Spec.Selector.DeepCopy()
+	}
+	selector.MatchLabels = withTrack(selector.MatchLabels)
+	canary.Spec.Selector = selector
+	canary.Spec.Template.Labels = withTrack(canary.Spec.Template.Labels)
+	if err := setImage(&canary.Spec.Template.Spec, u); err != nil {
+		return err
+	}
+	if len(u.PodAnnotations) > 0 && canary.Spec.Template.Annotations == nil {
+		canary.Spec.Template.Annotations = map[string]string{}
+	}
+	for k, v := range u.PodAnnotations {
+		canary.Spec.Template.Annotations[k] = v
+	}
+
+	existing, err := client.Get(context.TODO(), canary.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = client.Create(context.TODO(), canary, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	existing.Labels = canary.Labels
+	existing.Spec = canary.Spec
+	_, err = client.Update(context.TODO(), existing, metav1.UpdateOptions{})
+	return err
+}
+
+// DeleteDeployment deletes a Deployment and, in the background, its pods.
+func (c *Client) DeleteDeployment(ns string, name string) error {
+	propagation := metav1.DeletePropagationBackground
+	err := c.clientset.AppsV1().Deployments(ns).Delete(context.TODO(), name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// DeleteCanary deletes the canary Deployment for the primary `name`, if
+// there is one. A Deployment that happens to have the canary's name but not
+// TrackLabel is left alone.
+func (c *Client) DeleteCanary(ns string, name string) error {
+	canary, err := c.clientset.AppsV1().Deployments(ns).Get(context.TODO(), CanaryName(name), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if canary.Labels[TrackLabel] != "canary" {
+		return nil
+	}
+	return c.DeleteDeployment(ns, canary.Name)
+}
+
+// PodHealth counts the ready pods and container restarts of a Deployment.
+func (c *Client) PodHealth(ns string, name string) (*PodHealth, error) {
+	deployment, err := c.clientset.AppsV1().Deployments(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := c.clientset.CoreV1().Pods(ns).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	health := &PodHealth{}
+	for _, pod := range pods.Items {
+		health.Pods++
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == v1.PodReady && cond.Status == v1.ConditionTrue {
+				health.Ready++
+			}
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			health.Restarts += status.RestartCount
+		}
+	}
+	return health, nil
+}
+
+func withTrack(labels map[string]string) map[string]string {
+	copied := map[string]string{TrackLabel: "canary"}
+	for k, v := range labels {
+		if k != TrackLabel {
+			copied[k] = v
+		}
+	}
+	return copied
+}
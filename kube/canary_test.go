@@ -0,0 +1,99 @@
+package kube
+
+import (
+	"testing"
+)
+
+func TestClientApplyCanary(t *testing.T) {
+	client, _ := NewFake()
+	client.CreateDeployment(&Deployment{
+		Name:       "myapp",
+		Namespace:  "default",
+		Containers: []*Container{{Name: "app", Image: "nginx:1.20"}},
+	})
+
+	if err := client.ApplyCanary("default", "myapp", &ImageUpdate{Image: "nginx:1.21"}, 1); err != nil {
+		t.Fatalf("ApplyCanary failed to create the canary: %v", err)
+	}
+	if err := client.ApplyCanary("default", "myapp", &ImageUpdate{Image: "nginx:1.21"}, 3); err != nil {
+		t.Fatalf("ApplyCanary failed to update the canary: %v", err)
+	}
+
+	canary, err := client.GetDeployment("default", "myapp-canary")
+	if err != nil {
+		t.Fatalf("Canary deployment was not created: %v", err)
+	}
+	if canary.Containers[0].Image != "nginx:1.21" {
+		t.Errorf("Canary has the wrong image: %s", canary.Containers[0].Image)
+	}
+	if canary.Status.Replicas != 3 {
+		t.Errorf("Canary should have been scaled to 3, has %d", canary.Status.Replicas)
+	}
+	primary, _ := client.GetDeployment("default", "myapp")
+	if primary.Containers[0].Image != "nginx:1.20" {
+		t.Errorf("ApplyCanary changed the primary: %s", primary.Containers[0].Image)
+	}
+
+	if err := client.DeleteDeployment("default", "myapp-canary"); err != nil {
+		t.Fatalf("DeleteDeployment failed: %v", err)
+	}
+	if _, err := client.GetDeployment("default", "myapp-canary"); err == nil {
+		t.Errorf("Canary was not deleted")
+	}
+	if err := client.DeleteDeployment("default", "myapp-canary"); err != nil {
+		t.Errorf("Deleting a missing deployment should not fail: %v", err)
+	}
+}
+
+func TestClientPodHealth(t *testing.T) {
+	client, _ := NewFake()
+	client.CreateDeployment(&Deployment{
+		Name:       "myapp",
+		Namespace:  "default",
+		Containers: []*Container{{Name: "app", Image: "nginx:1.20"}},
+	})
+	client.ApplyCanary("default", "myapp", &ImageUpdate{Image: "nginx:1.21"}, 2)
+	canary := map[string]string{TrackLabel: "canary"}
+	client.CreatePod(&Pod{Namespace: "default", Name: "myapp-canary-a", Labels: canary, Ready: true})
+	client.CreatePod(&Pod{Namespace: "default", Name: "myapp-canary-b", Labels: canary, Restarts: 2})
+	client.CreatePod(&Pod{Namespace: "default", Name: "myapp-a", Ready: true})
+
+	health, err := client.PodHealth("default", "myapp-canary")
+	if err != nil {
+		t.Fatalf("PodHealth failed: %v", err)
+	}
+	if health.Pods != 2 || health.Ready != 1 || health.Restarts != 2 {
+		t.Errorf("PodHealth counted the wrong pods: %+v", health)
+	}
+}
+
+func TestClientDeleteCanary(t *testing.T) {
+	client, _ := NewFake()
+	client.CreateDeployment(&Deployment{
+		Name:       "myapp",
+		Namespace:  "default",
+		Containers: []*Container{{Name: "app", Image: "nginx:1.20"}},
+	})
+	client.CreateDeployment(&Deployment{
+		Name:       "other-canary",
+		Namespace:  "default",
+		Containers: []*Container{{Name: "app", Image: "nginx:1.20"}},
+	})
+	client.ApplyCanary("default", "myapp", &ImageUpdate{Image: "nginx:1.21"}, 1)
+
+	if err := client.DeleteCanary("default", "myapp"); err != nil {
+		t.Fatalf("DeleteCanary failed: %v", err)
+	}
+	if _, err := client.GetDeployment("default", "myapp-canary"); err == nil {
+		t.Errorf("Canary was not deleted")
+	}
+	if err := client.DeleteCanary("default", "other"); err != nil {
+		t.Fatalf("DeleteCanary failed: %v", err)
+	}
+	if _, err := client.GetDeployment("default", "other-canary"); err != nil {
+		t.Errorf("A Deployment without the track label should not be deleted: %v", err)
+	}
+	if err := client.DeleteCanary("default", "missing"); err != nil {
+		t.Errorf("Deleting a missing canary should not fail: %v", err)
+	}
+}
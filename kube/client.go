@@ -2,21 +2,60 @@ package kube
 
 import (
 	"context"
+	"fmt"
 
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
 )
 
+// TagAnnotation records the human-readable tag on deployments whose image
+// has been pinned to a digest.
+const TagAnnotation = "rollingpin.b8s.dev/tag"
+
+// PausedAnnotation, set to "true" on a deployment, stops rollingpin from
+// updating it.
+const PausedAnnotation = "rollingpin.b8s.dev/paused"
+
+// RestartedAtAnnotation is the pod template annotation `kubectl rollout
+// restart` bumps to force a rollout without changing the image.
+const RestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
 type IClient interface {
 	GetDeployment(string, string) (*Deployment, error)
 	UpdateDeploymentImage(string, string, string) error
+	UpdateDeployment(string, string, *ImageUpdate) error
 	CreateDeployment(*Deployment) error
+	ApplyCanary(string, string, *ImageUpdate, int32) error
+	DeleteDeployment(string, string) error
+	DeleteCanary(string, string) error
+	PodHealth(string, string) (*PodHealth, error)
+	Revisions(string, string) ([]*Revision, error)
+}
+
+// ImageUpdate describes a change to a deployment's container image, along
+// with any annotations to set on the deployment at the same time.
+type ImageUpdate struct {
+	Image       string
+	Annotations map[string]string
+
+	// Container is the name of the container to update. Defaults to the
+	// first container.
+	Container string
+
+	// PodAnnotations are set on the pod template, so changing them rolls out
+	// new pods even if the image is unchanged.
+	PodAnnotations map[string]string
 }
 
 type Client struct {
 	clientset kubernetes.Interface
+	dynamic   dynamic.Interface
 }
 
 func New() (*Client, error) {
@@ -28,11 +67,26 @@ func New() (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Client{clientset: kube}, nil
+	dyn, err := dynamic.NewForConfig(clusterConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{clientset: kube, dynamic: dyn}, nil
 }
 
 func NewFake() (*Client, error) {
-	return &Client{clientset: fake.NewSimpleClientset()}, nil
+	return &Client{clientset: fake.NewSimpleClientset(), dynamic: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())}, nil
+}
+
+// Clientset returns the underlying Kubernetes client, for packages that
+// need more than IClient offers.
+func (c *Client) Clientset() kubernetes.Interface {
+	return c.clientset
+}
+
+// Dynamic returns a client for custom resources.
+func (c *Client) Dynamic() dynamic.Interface {
+	return c.dynamic
 }
 
 func (c *Client) GetDeployment(ns string, name string) (*Deployment, error) {
@@ -46,15 +100,46 @@ func (c *Client) GetDeployment(ns string, name string) (*Deployment, error) {
 }
 
 func (c *Client) UpdateDeploymentImage(ns string, name string, image string) error {
+	return c.UpdateDeployment(ns, name, &ImageUpdate{Image: image})
+}
+
+func (c *Client) UpdateDeployment(ns string, name string, u *ImageUpdate) error {
 	client := c.clientset.AppsV1().Deployments(ns)
 	deployment, err := client.Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
-	// TODO: support specifying which container to update
-	deployment.Spec.Template.Spec.Containers[0].Image = image
+	if err := setImage(&deployment.Spec.Template.Spec, u); err != nil {
+		return fmt.Errorf("deployment %s/%s: %w", ns, name, err)
+	}
+	if len(u.Annotations) > 0 && deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+	for k, v := range u.Annotations {
+		deployment.Annotations[k] = v
+	}
+	if len(u.PodAnnotations) > 0 && deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = map[string]string{}
+	}
+	for k, v := range u.PodAnnotations {
+		deployment.Spec.Template.Annotations[k] = v
+	}
 	_, err = client.Update(context.TODO(), deployment, metav1.UpdateOptions{})
-	return nil
+	return err
+}
+
+// setImage changes the image of the container the update is for.
+func setImage(spec *v1.PodSpec, u *ImageUpdate) error {
+	for i := range spec.Containers {
+		if u.Container == "" || spec.Containers[i].Name == u.Container {
+			spec.Containers[i].Image = u.Image
+			return nil
+		}
+	}
+	if u.Container == "" {
+		return fmt.Errorf("no containers")
+	}
+	return fmt.Errorf("no container named %q", u.Container)
 }
 
 // XXX: this is purely for testing, feels a bit weird to have it as part of the
@@ -65,3 +150,24 @@ func (c *Client) CreateDeployment(d *Deployment) error {
 	_, err := c.clientset.AppsV1().Deployments(d.Namespace).Create(context.TODO(), deploy, metav1.CreateOptions{})
 	return err
 }
+
+// SetDeploymentStatus overwrites a deployment's rollout status. Like
+// CreateDeployment, it exists for tests to simulate the deployment
+// controller.
+func (c *Client) SetDeploymentStatus(ns string, name string, s *RolloutStatus) error {
+	client := c.clientset.AppsV1().Deployments(ns)
+	deployment, err := client.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	s.ToKubernetes(deployment)
+	_, err = client.UpdateStatus(context.TODO(), deployment, metav1.UpdateOptions{})
+	return err
+}
+
+// CreatePod exists for tests to stand in for the pods a Deployment would
+// create.
+func (c *Client) CreatePod(p *Pod) error {
+	_, err := c.clientset.CoreV1().Pods(p.Namespace).Create(context.TODO(), p.ToKubernetes(), metav1.CreateOptions{})
+	return err
+}
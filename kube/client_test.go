@@ -34,3 +34,62 @@ func TestClientUpdateDeploymentImage(t *testing.T) {
 		t.Errorf("UpdateDeploymentImage did not update image. Was: %s", d.Containers[0].Image)
 	}
 }
+
+func TestClientUpdateDeploymentAnnotations(t *testing.T) {
+	client, _ := NewFake()
+	client.CreateDeployment(
+		&Deployment{
+			Name:      "myapp",
+			Namespace: "default",
+			Containers: []*Container{
+				{Name: "app", Image: "nginx:latest"},
+			},
+		},
+	)
+
+	err := client.UpdateDeployment("default", "myapp", &ImageUpdate{
+		Image:       "nginx@sha256:abc",
+		Annotations: map[string]string{TagAnnotation: "1.21-alpine"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateDeployment failed: %v", err)
+	}
+
+	d, _ := client.GetDeployment("default", "myapp")
+	if d.Containers[0].Image != "nginx@sha256:abc" {
+		t.Errorf("UpdateDeployment did not update image. Was: %s", d.Containers[0].Image)
+	}
+	if d.Annotations[TagAnnotation] != "1.21-alpine" {
+		t.Errorf("UpdateDeployment did not set annotations. Was: %v", d.Annotations)
+	}
+}
+
+func TestClientUpdateDeploymentContainer(t *testing.T) {
+	client, _ := NewFake()
+	client.CreateDeployment(&Deployment{
+		Name:      "myapp",
+		Namespace: "default",
+		Containers: []*Container{
+			{Name: "sidecar", Image: "envoy:1"},
+			{Name: "app", Image: "nginx:1"},
+		},
+	})
+
+	if err := client.UpdateDeployment("default", "myapp", &ImageUpdate{Image: "nginx:2", Container: "app"}); err != nil {
+		t.Fatalf("UpdateDeployment failed: %v", err)
+	}
+	d, _ := client.GetDeployment("default", "myapp")
+	if d.Image("sidecar") != "envoy:1" || d.Image("app") != "nginx:2" {
+		t.Errorf("UpdateDeployment should only update the named container, got %s and %s", d.Image("sidecar"), d.Image("app"))
+	}
+	if err := client.UpdateDeployment("default", "myapp", &ImageUpdate{Image: "nginx:3", Container: "missing"}); err == nil {
+		t.Errorf("UpdateDeployment should fail for a missing container")
+	}
+}
+
+func TestClientUpdateDeploymentMissing(t *testing.T) {
+	client, _ := NewFake()
+	if err := client.UpdateDeploymentImage("default", "nope", "nginx:latest"); err == nil {
+		t.Errorf("UpdateDeploymentImage should fail for a missing deployment")
+	}
+}
@@ -7,9 +7,15 @@ import (
 )
 
 type Deployment struct {
-	Namespace  string
-	Name       string
-	Containers []*Container
+	Namespace      string
+	Name           string
+	Annotations    map[string]string
+	PodAnnotations map[string]string
+	Containers     []*Container
+
+	// Status is the deployment's rollout progress. It is only read from the
+	// cluster; updates ignore it.
+	Status *RolloutStatus
 }
 
 type Container struct {
@@ -17,6 +23,17 @@ type Container struct {
 	Image string
 }
 
+// Image returns the image of the named container, or of the first container
+// if name is empty. It returns "" if there is no such container.
+func (d *Deployment) Image(container string) string {
+	for _, c := range d.Containers {
+		if container == "" || c.Name == container {
+			return c.Image
+		}
+	}
+	return ""
+}
+
 func (d *Deployment) FromKubernetes(kd *appsv1.Deployment) {
 	var containers []*Container
 	for _, c := range kd.Spec.Template.Spec.Containers {
@@ -24,7 +41,11 @@ func (d *Deployment) FromKubernetes(kd *appsv1.Deployment) {
 	}
 	d.Namespace = kd.Namespace
 	d.Name = kd.Name
+	d.Annotations = kd.Annotations
+	d.PodAnnotations = kd.Spec.Template.Annotations
 	d.Containers = containers
+	d.Status = &RolloutStatus{}
+	d.Status.FromKubernetes(kd)
 }
 
 func (d *Deployment) ToKubernetes() *appsv1.Deployment {
@@ -32,14 +53,19 @@ func (d *Deployment) ToKubernetes() *appsv1.Deployment {
 	for _, c := range d.Containers {
 		containers = append(containers, v1.Container{Name: c.Name, Image: c.Image})
 	}
-	return &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace},
+	kd := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace, Annotations: d.Annotations},
 		Spec: appsv1.DeploymentSpec{
 			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Annotations: d.PodAnnotations},
 				Spec: v1.PodSpec{
 					Containers: containers,
 				},
 			},
 		},
 	}
+	if d.Status != nil {
+		d.Status.ToKubernetes(kd)
+	}
+	return kd
 }
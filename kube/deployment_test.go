@@ -88,3 +88,22 @@ func TestDeploymentToKubernetes(t *testing.T) {
 		)
 	}
 }
+
+func TestDeploymentAnnotationsRoundTrip(t *testing.T) {
+	source := &Deployment{
+		Namespace:      "default",
+		Name:           "myapp",
+		Annotations:    map[string]string{TagAnnotation: "1.4.2"},
+		PodAnnotations: map[string]string{RestartedAtAnnotation: "2026-10-19T12:00:00Z"},
+	}
+
+	d := &Deployment{}
+	d.FromKubernetes(source.ToKubernetes())
+
+	if d.Annotations[TagAnnotation] != "1.4.2" {
+		t.Errorf("Annotations did not round trip: %v", d.Annotations)
+	}
+	if d.PodAnnotations[RestartedAtAnnotation] != "2026-10-19T12:00:00Z" {
+		t.Errorf("PodAnnotations did not round trip: %v", d.PodAnnotations)
+	}
+}
@@ -0,0 +1,19 @@
+package kube
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// CurrentNamespace returns the namespace rollingpin is running in, from its
+// service account.
+func CurrentNamespace() (string, error) {
+	b, err := os.ReadFile(serviceAccountNamespace)
+	if err != nil {
+		return "", fmt.Errorf("could not determine the current namespace: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
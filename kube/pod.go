@@ -0,0 +1,28 @@
+package kube
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type Pod struct {
+	Namespace string
+	Name      string
+	Labels    map[string]string
+	Ready     bool
+	Restarts  int32
+}
+
+func (p *Pod) ToKubernetes() *v1.Pod {
+	ready := v1.ConditionFalse
+	if p.Ready {
+		ready = v1.ConditionTrue
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: p.Name, Namespace: p.Namespace, Labels: p.Labels},
+		Status: v1.PodStatus{
+			Conditions:        []v1.PodCondition{{Type: v1.PodReady, Status: ready}},
+			ContainerStatuses: []v1.ContainerStatus{{Name: "app", Ready: p.Ready, RestartCount: p.Restarts}},
+		},
+	}
+}
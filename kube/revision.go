@@ -0,0 +1,99 @@
+package kube
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RevisionAnnotation is set on each of a Deployment's ReplicaSets by the
+// deployment controller, numbering the revisions `kubectl rollout history`
+// lists.
+const RevisionAnnotation = "deployment.kubernetes.io/revision"
+
+// Revision is a revision of a Deployment, as recorded by its ReplicaSets.
+type Revision struct {
+	Number int64 `json:"revision"`
+
+	// Image is the image of the first container. Containers lists them all.
+	Image      string       `json:"image"`
+	Containers []*Container `json:"containers,omitempty"`
+
+	ReplicaSet string    `json:"replica_set"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Revisions returns the revisions of a Deployment still held by its
+// ReplicaSets, newest first.
+func (c *Client) Revisions(ns string, name string) ([]*Revision, error) {
+	deployment, err := c.clientset.AppsV1().Deployments(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	sets, err := c.clientset.AppsV1().ReplicaSets(ns).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	var revisions []*Revision
+	for i := range sets.Items {
+		rs := &sets.Items[i]
+		if !ownedBy(rs, name) {
+			continue
+		}
+		number, err := strconv.ParseInt(rs.Annotations[RevisionAnnotation], 10, 64)
+		if err != nil {
+			continue
+		}
+		r := &Revision{Number: number, ReplicaSet: rs.Name, CreatedAt: rs.CreationTimestamp.Time}
+		for _, c := range rs.Spec.Template.Spec.Containers {
+			r.Containers = append(r.Containers, &Container{Name: c.Name, Image: c.Image})
+		}
+		if len(r.Containers) > 0 {
+			r.Image = r.Containers[0].Image
+		}
+		revisions = append(revisions, r)
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Number > revisions[j].Number
+	})
+	return revisions, nil
+}
+
+func ownedBy(rs *appsv1.ReplicaSet, deployment string) bool {
+	for _, ref := range rs.OwnerReferences {
+		if ref.Kind == "Deployment" && ref.Name == deployment {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateReplicaSet exists for tests to stand in for the ReplicaSet the
+// deployment controller would create for a revision.
+func (c *Client) CreateReplicaSet(ns string, deployment string, r *Revision) error {
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              r.ReplicaSet,
+			Namespace:         ns,
+			Annotations:       map[string]string{RevisionAnnotation: strconv.FormatInt(r.Number, 10)},
+			OwnerReferences:   []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: deployment}},
+			CreationTimestamp: metav1.NewTime(r.CreatedAt),
+		},
+		Spec: appsv1.ReplicaSetSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{Containers: []v1.Container{{Name: "app", Image: r.Image}}},
+			},
+		},
+	}
+	_, err := c.clientset.AppsV1().ReplicaSets(ns).Create(context.TODO(), rs, metav1.CreateOptions{})
+	return err
+}
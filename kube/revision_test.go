@@ -0,0 +1,33 @@
+package kube
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevisions(t *testing.T) {
+	client, _ := NewFake()
+	client.CreateDeployment(&Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*Container{{Name: "app", Image: "debian:3"}},
+	})
+	created := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	client.CreateReplicaSet("default", "app", &Revision{Number: 1, Image: "debian:1", ReplicaSet: "app-1", CreatedAt: created})
+	client.CreateReplicaSet("default", "app", &Revision{Number: 3, Image: "debian:3", ReplicaSet: "app-3", CreatedAt: created})
+	client.CreateReplicaSet("default", "app", &Revision{Number: 2, Image: "debian:2", ReplicaSet: "app-2", CreatedAt: created})
+	client.CreateReplicaSet("default", "other", &Revision{Number: 7, Image: "other:7", ReplicaSet: "other-7", CreatedAt: created})
+
+	revisions, err := client.Revisions("default", "app")
+	if err != nil {
+		t.Fatalf("Revisions failed: %v", err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("Expected the deployment's three revisions, got %d", len(revisions))
+	}
+	for i, want := range []string{"debian:3", "debian:2", "debian:1"} {
+		if revisions[i].Image != want {
+			t.Errorf("Revision %d should be %s, got %+v", i, want, revisions[i])
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package kube
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// RolloutStatus is the progress of a deployment's most recent rollout, as
+// reported in its status.
+type RolloutStatus struct {
+	Generation         int64
+	ObservedGeneration int64
+
+	// Replicas is the desired number of pods.
+	Replicas int32
+	// CurrentReplicas is the number of pods, old and new, that exist. A
+	// status written without it is taken to have no old pods left.
+	CurrentReplicas   int32
+	UpdatedReplicas   int32
+	ReadyReplicas     int32
+	AvailableReplicas int32
+
+	// Failed is set once the deployment has exceeded its progress deadline.
+	Failed  bool
+	Message string
+}
+
+// Complete reports whether every desired pod is running the latest pod
+// template and is available, and no old pods are left, like `kubectl
+// rollout status`.
+func (s *RolloutStatus) Complete() bool {
+	return s.ObservedGeneration >= s.Generation &&
+		s.UpdatedReplicas == s.Replicas &&
+		s.CurrentReplicas == s.UpdatedReplicas &&
+		s.AvailableReplicas == s.Replicas
+}
+
+func (s *RolloutStatus) FromKubernetes(kd *appsv1.Deployment) {
+	s.Generation = kd.Generation
+	s.ObservedGeneration = kd.Status.ObservedGeneration
+	s.Replicas = 1
+	if kd.Spec.Replicas != nil {
+		s.Replicas = *kd.Spec.Replicas
+	}
+	s.CurrentReplicas = kd.Status.Replicas
+	s.UpdatedReplicas = kd.Status.UpdatedReplicas
+	s.ReadyReplicas = kd.Status.ReadyReplicas
+	s.AvailableReplicas = kd.Status.AvailableReplicas
+	for _, c := range kd.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Status == v1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded" {
+			s.Failed = true
+			s.Message = c.Message
+		}
+	}
+}
+
+// ToKubernetes writes the status onto a deployment.
+func (s *RolloutStatus) ToKubernetes(kd *appsv1.Deployment) {
+	replicas := s.Replicas
+	kd.Spec.Replicas = &replicas
+	kd.Generation = s.Generation
+	kd.Status.ObservedGeneration = s.ObservedGeneration
+	kd.Status.Replicas = s.CurrentReplicas
+	if kd.Status.Replicas == 0 {
+		kd.Status.Replicas = s.UpdatedReplicas
+	}
+	kd.Status.UpdatedReplicas = s.UpdatedReplicas
+	kd.Status.ReadyReplicas = s.ReadyReplicas
+	kd.Status.AvailableReplicas = s.AvailableReplicas
+	kd.Status.Conditions = nil
+	if s.Failed {
+		kd.Status.Conditions = []appsv1.DeploymentCondition{{
+			Type:    appsv1.DeploymentProgressing,
+			Status:  v1.ConditionFalse,
+			Reason:  "ProgressDeadlineExceeded",
+			Message: s.Message,
+		}}
+	}
+}
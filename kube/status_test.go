@@ -0,0 +1,48 @@
+package kube
+
+import (
+	"testing"
+)
+
+func TestRolloutStatusComplete(t *testing.T) {
+	cases := []struct {
+		status   RolloutStatus
+		complete bool
+	}{
+		{RolloutStatus{Replicas: 3, CurrentReplicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}, true},
+		// Old pods are still terminating.
+		{RolloutStatus{Replicas: 3, CurrentReplicas: 4, UpdatedReplicas: 3, AvailableReplicas: 3}, false},
+		{RolloutStatus{Replicas: 3, UpdatedReplicas: 2, AvailableReplicas: 3}, false},
+		{RolloutStatus{Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 2}, false},
+		{RolloutStatus{Generation: 2, ObservedGeneration: 1, Replicas: 1, CurrentReplicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}, false},
+	}
+	for _, c := range cases {
+		if c.status.Complete() != c.complete {
+			t.Errorf("Complete() for %+v should be %v", c.status, c.complete)
+		}
+	}
+}
+
+func TestClientSetDeploymentStatus(t *testing.T) {
+	client, _ := NewFake()
+	client.CreateDeployment(&Deployment{
+		Name:       "myapp",
+		Namespace:  "default",
+		Containers: []*Container{{Name: "app", Image: "nginx:latest"}},
+	})
+
+	err := client.SetDeploymentStatus("default", "myapp", &RolloutStatus{
+		Replicas: 2, UpdatedReplicas: 1, Failed: true, Message: "deadline exceeded",
+	})
+	if err != nil {
+		t.Fatalf("SetDeploymentStatus failed: %v", err)
+	}
+
+	d, _ := client.GetDeployment("default", "myapp")
+	if d.Status.Replicas != 2 || d.Status.CurrentReplicas != 1 || d.Status.UpdatedReplicas != 1 {
+		t.Errorf("Status replicas did not round trip: %+v", d.Status)
+	}
+	if !d.Status.Failed || d.Status.Message != "deadline exceeded" {
+		t.Errorf("Progress deadline condition did not round trip: %+v", d.Status)
+	}
+}
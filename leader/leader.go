@@ -0,0 +1,137 @@
+// Package leader elects a single rollingpin replica to apply deploys, using
+// a coordination.k8s.io Lease.
+package leader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	defaultLeaseName     = "rollingpin"
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+)
+
+// Elector campaigns for the lease until its context is cancelled.
+type Elector struct {
+	Client kubernetes.Interface
+	Config config.LeaderElectionConfig
+	Logger *zap.Logger
+
+	// Namespace holds the lease, if the config doesn't say.
+	Namespace string
+
+	// Identity distinguishes this replica from the others, such as its pod
+	// name.
+	Identity string
+
+	// OnStartedLeading is called in a new goroutine when this replica
+	// becomes the leader. Its context is cancelled when it stops leading.
+	OnStartedLeading func(context.Context)
+
+	// OnStoppedLeading is called when this replica stops leading, including
+	// when the elector's context is cancelled.
+	OnStoppedLeading func()
+
+	mu      sync.Mutex
+	leader  string
+	leading bool
+}
+
+// Run campaigns for the lease, and holds it while it can. It returns once
+// this replica loses the lease or the context is cancelled, releasing the
+// lease if it is held.
+func (e *Elector) Run(ctx context.Context) error {
+	namespace := e.Config.Namespace
+	if namespace == "" {
+		namespace = e.Namespace
+	}
+	name := e.Config.LeaseName
+	if name == "" {
+		name = defaultLeaseName
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Client:     e.Client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: e.Identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   duration(e.Config.LeaseDuration, defaultLeaseDuration),
+		RenewDeadline:   duration(e.Config.RenewDeadline, defaultRenewDeadline),
+		RetryPeriod:     duration(e.Config.RetryPeriod, defaultRetryPeriod),
+		ReleaseOnCancel: true,
+		Name:            name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				e.Logger.Info("Started leading", zap.String("identity", e.Identity))
+				e.setLeading(true)
+				if e.OnStartedLeading != nil {
+					e.OnStartedLeading(ctx)
+				}
+			},
+			OnStoppedLeading: func() {
+				// client-go calls this when Run returns, even if this
+				// replica never led.
+				if !e.IsLeader() {
+					return
+				}
+				e.Logger.Info("Stopped leading", zap.String("identity", e.Identity))
+				e.setLeading(false)
+				if e.OnStoppedLeading != nil {
+					e.OnStoppedLeading()
+				}
+			},
+			OnNewLeader: func(identity string) {
+				e.mu.Lock()
+				e.leader = identity
+				e.mu.Unlock()
+				if identity != e.Identity {
+					e.Logger.Info("Following new leader", zap.String("leader", identity))
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	elector.Run(ctx)
+	return nil
+}
+
+// Leader returns the identity of the current leader, if it is known.
+func (e *Elector) Leader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// IsLeader reports whether this replica is the leader.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading
+}
+
+func (e *Elector) setLeading(leading bool) {
+	e.mu.Lock()
+	e.leading = leading
+	e.mu.Unlock()
+}
+
+func duration(d time.Duration, fallback time.Duration) time.Duration {
+	if d <= 0 {
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,85 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testElector(client kubernetes.Interface, identity string) (*Elector, chan struct{}) {
+	started := make(chan struct{}, 1)
+	e := &Elector{
+		Client:    client,
+		Logger:    zap.NewNop(),
+		Namespace: "rollingpin",
+		Identity:  identity,
+		Config: config.LeaderElectionConfig{
+			LeaseDuration: time.Second,
+			RenewDeadline: 500 * time.Millisecond,
+			RetryPeriod:   100 * time.Millisecond,
+		},
+		OnStartedLeading: func(context.Context) { started <- struct{}{} },
+	}
+	return e, started
+}
+
+func waitFor(t *testing.T, c chan struct{}, what string) {
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %s", what)
+	}
+}
+
+func TestElection(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	first, firstStarted := testElector(client, "rollingpin-0")
+	second, secondStarted := testElector(client, "rollingpin-1")
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		first.Run(firstCtx)
+		close(firstDone)
+	}()
+	waitFor(t, firstStarted, "first replica to lead")
+	if !first.IsLeader() {
+		t.Errorf("First replica should be the leader")
+	}
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	go second.Run(secondCtx)
+
+	lease, err := client.CoordinationV1().Leases("rollingpin").Get(context.TODO(), "rollingpin", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Lease should have been created: %v", err)
+	}
+	if *lease.Spec.HolderIdentity != "rollingpin-0" {
+		t.Errorf("Lease should be held by the first replica, got %s", *lease.Spec.HolderIdentity)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if second.IsLeader() {
+		t.Errorf("Second replica should not lead while the first holds the lease")
+	}
+	if second.Leader() != "rollingpin-0" {
+		t.Errorf("Second replica should know who leads, got %q", second.Leader())
+	}
+
+	// The first replica shutting down releases the lease to the second.
+	stopFirst()
+	waitFor(t, firstDone, "first replica to stop")
+	if first.IsLeader() {
+		t.Errorf("First replica should no longer lead")
+	}
+	waitFor(t, secondStarted, "second replica to take over")
+	if !second.IsLeader() {
+		t.Errorf("Second replica should be the leader")
+	}
+}
@@ -1,20 +1,45 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.b8s.dev/rollingpin/admin"
+	"go.b8s.dev/rollingpin/approval"
+	"go.b8s.dev/rollingpin/cli"
 	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/crd"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.b8s.dev/rollingpin/discovery"
 	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/leader"
+	"go.b8s.dev/rollingpin/notify"
+	"go.b8s.dev/rollingpin/poller"
+	"go.b8s.dev/rollingpin/promote"
 	"go.b8s.dev/rollingpin/providers/direct"
+	"go.b8s.dev/rollingpin/providers/generic"
 	"go.b8s.dev/rollingpin/providers/harbor"
+	"go.b8s.dev/rollingpin/rollout"
+	"go.b8s.dev/rollingpin/state"
 	"go.uber.org/zap"
 )
 
 var configPath = flag.String("config", "config.yaml", "Path to the config file.")
 
 func main() {
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		if err := cli.Run(os.Args[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	flag.Parse()
+
 	logger, err := zap.NewProduction()
 	if err != nil {
 		panic(err)
@@ -28,12 +53,144 @@ func main() {
 		panic(err)
 	}
 
-	r := buildRouter(conf, logger, kube)
+	if conf.Discovery.Enabled {
+		d := &discovery.Discoverer{Client: kube.Clientset(), Config: conf, Logger: logger}
+		if err := d.Start(context.Background()); err != nil {
+			panic(err)
+		}
+	}
+
+	store, err := state.Open(conf.State, kube.Clientset())
+	if err != nil {
+		panic(err)
+	}
+	defer store.Close()
+
+	approvals := approval.NewStore(conf.Approvals.Expiry)
+	approvals.Backend = store
+	approvals.Retention = conf.Approvals.Retention
+	if err := approvals.Load(); err != nil {
+		panic(err)
+	}
+	pipeline := &deploy.Pipeline{
+		Config:      conf,
+		Logger:      logger,
+		Client:      kube,
+		Approvals:   approvals,
+		History:     &deploy.History{Backend: store},
+		Idempotency: deploy.NewIdempotency(conf.Idempotency, store, logger),
+		Replay:      deploy.NewReplayGuard(conf.Replay, store),
+		Pauses:      &deploy.Pauses{Backend: store},
+	}
+
+	if conf.Resources.Enabled {
+		controller := &crd.Controller{Client: kube.Dynamic(), Config: conf, Logger: logger}
+		if err := controller.Start(context.Background()); err != nil {
+			panic(err)
+		}
+		pipeline.OnResult(controller.HandleResult)
+	}
+
+	pipeline.Rollouts = &rollout.Watcher{
+		Client:   kube,
+		Logger:   logger,
+		Interval: conf.Rollouts.Interval,
+		Timeout:  conf.Rollouts.Timeout,
+	}
+	pipeline.Rollouts.OnResult(pipeline.RolloutFinished)
+
+	jobs := deploy.NewJobQueue(conf.Jobs, logger)
+	jobs.Backend = store
+
+	var promoter *promote.Promoter
+	if len(conf.Promotions) > 0 {
+		promoter, err = promote.New(conf, logger, pipeline)
+		if err != nil {
+			panic(err)
+		}
+		promoter.Backend = store
+		pipeline.Rollouts.OnResult(promoter.HandleRollout)
+		pipeline.OnResult(promoter.HandleResult)
+	}
+
+	// start begins applying deploys, which only the leader does when there
+	// are several replicas.
+	start := func(ctx context.Context) {
+		pipeline.RemoveCanaries()
+		if err := jobs.Load(); err != nil {
+			panic(err)
+		}
+		pipeline.StartJobs(ctx, jobs)
+		go pipeline.SweepApprovals(ctx)
+		if promoter != nil {
+			if err := promoter.Load(); err != nil {
+				panic(err)
+			}
+		}
+		if poller.Enabled(conf) {
+			p := &poller.Poller{Config: conf, Logger: logger, Pipeline: pipeline}
+			go p.Run(ctx)
+		}
+	}
+	if conf.LeaderElection.Enabled {
+		if err := runElection(conf, logger, kube, pipeline, jobs, start); err != nil {
+			panic(err)
+		}
+	} else {
+		start(context.Background())
+	}
+
+	r, err := buildRouter(conf, logger, pipeline, promoter, store)
+	if err != nil {
+		panic(err)
+	}
 
 	logger.Info("Server started on :8080.")
 	r.Run(":8080")
 }
 
+// runElection campaigns for leadership in the background. Until this
+// replica leads, its webhooks and rollbacks only queue jobs for the leader to
+// run. Everything else replicas share, like pushes waiting on a scan and
+// halted promotion chains, is kept in the state store. A replica that loses
+// the lease exits, so that it restarts as a follower without any deploys
+// still in progress.
+func runElection(conf *config.Config, logger *zap.Logger, client *kube.Client, pipeline *deploy.Pipeline, jobs *deploy.JobQueue, start func(context.Context)) error {
+	namespace, err := kube.CurrentNamespace()
+	if err != nil && conf.LeaderElection.Namespace == "" {
+		return err
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	jobs.SetStandby(true)
+	pipeline.Jobs = jobs
+	elector := &leader.Elector{
+		Client:    client.Clientset(),
+		Config:    conf.LeaderElection,
+		Logger:    logger,
+		Namespace: namespace,
+		Identity:  identity,
+		OnStartedLeading: func(ctx context.Context) {
+			jobs.SetStandby(false)
+			start(ctx)
+			go jobs.Poll(ctx)
+		},
+		OnStoppedLeading: func() {
+			logger.Warn("Lost leadership, exiting")
+			os.Exit(1)
+		},
+	}
+	go func() {
+		if err := elector.Run(context.Background()); err != nil {
+			logger.Fatal("Leader election failed", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
 func requestLogger(logger *zap.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		requestStart := time.Now()
@@ -48,20 +205,39 @@ func requestLogger(logger *zap.Logger) gin.HandlerFunc {
 	}
 }
 
-func buildRouter(conf *config.Config, logger *zap.Logger, client kube.IClient) *gin.Engine {
+func buildRouter(conf *config.Config, logger *zap.Logger, pipeline *deploy.Pipeline, promoter *promote.Promoter, store state.Store) (*gin.Engine, error) {
 	r := gin.New()
 	r.SetTrustedProxies(nil)
 	r.Use(gin.Recovery(), requestLogger(logger))
 
 	if config.ProviderEnabled(conf, "harbor") {
-		harborRouter := &harbor.Router{Config: conf, Logger: logger, Client: client}
+		harborRouter := &harbor.Router{
+			Config:   conf,
+			Logger:   logger,
+			Client:   pipeline.Client,
+			Pipeline: pipeline,
+			Notifier: notify.New(conf),
+			State:    store,
+		}
 		harborRouter.Mount(r.Group("/webhooks/harbor"))
 	}
 
 	if config.ProviderEnabled(conf, "direct") {
-		directRouter := &direct.Router{Config: conf, Logger: logger, Client: client}
+		directRouter := &direct.Router{Config: conf, Logger: logger, Pipeline: pipeline}
 		directRouter.Mount(r.Group("/webhooks/direct"))
 	}
 
-	return r
+	if config.ProviderEnabled(conf, "generic") {
+		genericRouter := &generic.Router{Config: conf, Logger: logger, Pipeline: pipeline}
+		if err := genericRouter.Mount(r.Group("/webhooks/generic")); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.AdminToken != "" {
+		adminRouter := &admin.Router{Config: conf, Logger: logger, Pipeline: pipeline, Promoter: promoter}
+		adminRouter.Mount(r.Group("/api"))
+	}
+
+	return r, nil
 }
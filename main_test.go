@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
 	"go.b8s.dev/rollingpin/kube"
 	"go.uber.org/zap"
 )
@@ -56,13 +60,14 @@ func TestHarborWebhookEndToEnd(t *testing.T) {
 				Namespace:      "default",
 				DeploymentName: "test-deployment",
 				ImageName:      "library/debian",
+				Providers:      []string{"harbor"},
 			},
 		},
 	}
 	log, _ := zap.NewProduction()
 
 	// Execute request
-	r := buildRouter(conf, log, fakeClient)
+	r, _ := buildRouter(conf, log, &deploy.Pipeline{Config: conf, Logger: log, Client: fakeClient}, nil, nil)
 	r.ServeHTTP(resp, req)
 
 	// Assertions
@@ -110,13 +115,14 @@ func TestDirectWebhookEndToEnd(t *testing.T) {
 				Namespace:      "default",
 				DeploymentName: "test-deployment",
 				ImageName:      "library/debian",
+				Providers:      []string{"direct"},
 			},
 		},
 	}
 	log, _ := zap.NewProduction()
 
 	// Execute request
-	r := buildRouter(conf, log, fakeClient)
+	r, _ := buildRouter(conf, log, &deploy.Pipeline{Config: conf, Logger: log, Client: fakeClient}, nil, nil)
 	r.ServeHTTP(resp, req)
 
 	// Assertions
@@ -133,3 +139,136 @@ func TestDirectWebhookEndToEnd(t *testing.T) {
 		t.Errorf("Expected deployment to be updated but was not! Image was: %s", newImageName)
 	}
 }
+
+func TestGenericWebhookEndToEnd(t *testing.T) {
+	// Set up test HTTP request
+	payload := `{
+		"kind": "image.published",
+		"image": {"name": "library/debian", "version": "v2"}
+	}`
+	req, _ := http.NewRequest("POST", "/webhooks/generic/internal-ci", bytes.NewBufferString(payload))
+	req.Header.Add("authorization", "Bearer abc1234")
+	resp := httptest.NewRecorder()
+
+	// Set up fake kubernetes api client
+	fakeClient, _ := kube.NewFake()
+	fakeClient.CreateDeployment(
+		&kube.Deployment{
+			Namespace: "default",
+			Name:      "test-deployment",
+			Containers: []*kube.Container{
+				{Name: "app", Image: "cr.b8s.dev/library/debian:v1"},
+			},
+		},
+	)
+
+	// Set up our own app's stuff
+	conf := &config.Config{
+		AuthToken: "abc1234",
+		Mappings: []config.ImageMapping{
+			{
+				Namespace:      "default",
+				DeploymentName: "test-deployment",
+				ImageName:      "library/debian",
+				Providers:      []string{"generic"},
+			},
+		},
+		Generic: config.GenericConfig{
+			Routes: []config.GenericRoute{
+				{
+					Name:       "internal-ci",
+					Path:       "internal-ci",
+					Filter:     `.kind == "image.published"`,
+					Repository: ".image.name",
+					Tag:        ".image.version",
+					Registry:   "cr.b8s.dev",
+				},
+			},
+		},
+	}
+	log, _ := zap.NewProduction()
+
+	// Execute request
+	r, err := buildRouter(conf, log, &deploy.Pipeline{Config: conf, Logger: log, Client: fakeClient}, nil, nil)
+	if err != nil {
+		t.Fatalf("buildRouter failed: %v", err)
+	}
+	r.ServeHTTP(resp, req)
+
+	// Assertions
+	if resp.Code != 200 {
+		t.Errorf("Expected 200 response got: %d", resp.Code)
+	}
+	if resp.Body.String() != `{"ok":true}` {
+		t.Errorf("Expected OK response got: %s", resp.Body.String())
+	}
+
+	newDeploy, _ := fakeClient.GetDeployment("default", "test-deployment")
+	newImageName := newDeploy.Containers[0].Image
+	if newImageName != "cr.b8s.dev/library/debian:v2" {
+		t.Errorf("Expected deployment to be updated but was not! Image was: %s", newImageName)
+	}
+}
+
+func TestDirectWebhookQueuesJob(t *testing.T) {
+	payload := `{
+		"image_url": "cr.b8s.dev/library/debian:v2",
+		"repository_name": "library/debian"
+	}`
+	req, _ := http.NewRequest("POST", "/webhooks/direct", bytes.NewBufferString(payload))
+	req.Header.Add("authorization", "Bearer abc1234")
+	resp := httptest.NewRecorder()
+
+	fakeClient, _ := kube.NewFake()
+	fakeClient.CreateDeployment(
+		&kube.Deployment{
+			Namespace: "default",
+			Name:      "test-deployment",
+			Containers: []*kube.Container{
+				{Name: "app", Image: "cr.b8s.dev/library/debian:v1"},
+			},
+		},
+	)
+	conf := &config.Config{
+		AuthToken: "abc1234",
+		Mappings: []config.ImageMapping{
+			{
+				Namespace:      "default",
+				DeploymentName: "test-deployment",
+				ImageName:      "library/debian",
+				Providers:      []string{"direct"},
+			},
+		},
+	}
+	log, _ := zap.NewProduction()
+	pipeline := &deploy.Pipeline{Config: conf, Logger: log, Client: fakeClient}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pipeline.StartJobs(ctx, deploy.NewJobQueue(conf.Jobs, log))
+
+	r, _ := buildRouter(conf, log, pipeline, nil, nil)
+	r.ServeHTTP(resp, req)
+
+	if resp.Code != 202 {
+		t.Errorf("Expected 202 response got: %d", resp.Code)
+	}
+	var body struct {
+		Result deploy.Result `json:"result"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	if body.Result.Status != deploy.StatusAccepted || body.Result.ID == "" {
+		t.Fatalf("Expected a job ID in the response got: %s", resp.Body.String())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if job := pipeline.Jobs.Get(body.Result.ID); job.State == deploy.JobSucceeded {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	newDeploy, _ := fakeClient.GetDeployment("default", "test-deployment")
+	if newDeploy.Containers[0].Image != "cr.b8s.dev/library/debian:v2" {
+		t.Errorf("Expected the job to update the deployment! Image was: %s", newDeploy.Containers[0].Image)
+	}
+}
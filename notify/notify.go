@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+)
+
+// Notification is an operational event that a human should hear about, such
+// as an in-use image being deleted from the registry.
+type Notification struct {
+	Level   string            `json:"level"`
+	Event   string            `json:"event"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+type Notifier interface {
+	Notify(*Notification) error
+}
+
+// New builds the Notifier described by the config, or a no-op Notifier if
+// notifications are not configured.
+func New(conf *config.Config) Notifier {
+	if conf.Notifications.WebhookURL == "" {
+		return &Noop{}
+	}
+	return &WebhookNotifier{
+		URL:    conf.Notifications.WebhookURL,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Noop discards all notifications.
+type Noop struct{}
+
+func (n *Noop) Notify(*Notification) error {
+	return nil
+}
+
+// WebhookNotifier POSTs each notification as JSON to a URL.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(notification *Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	resp, err := n.Client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.b8s.dev/rollingpin/config"
+)
+
+func TestNewUnconfigured(t *testing.T) {
+	n := New(&config.Config{})
+	if _, ok := n.(*Noop); !ok {
+		t.Errorf("New should return a Noop notifier when unconfigured, got: %T", n)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var received Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	n := New(&config.Config{Notifications: config.NotificationConfig{WebhookURL: server.URL}})
+	err := n.Notify(&Notification{Level: "warning", Event: "artifact_deleted", Message: "oh no"})
+	if err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if received.Event != "artifact_deleted" || received.Message != "oh no" {
+		t.Errorf("Notify sent incorrect notification: %v", received)
+	}
+}
+
+func TestWebhookNotifierFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	n := &WebhookNotifier{URL: server.URL, Client: server.Client()}
+	if err := n.Notify(&Notification{}); err == nil {
+		t.Errorf("Notify should fail on a non-2xx response")
+	}
+}
@@ -0,0 +1,104 @@
+// Package policy evaluates CEL expressions that decide whether an event may
+// be deployed.
+package policy
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+)
+
+var (
+	envOnce sync.Once
+	env     *cel.Env
+	envErr  error
+
+	// programs caches compiled expressions by source, since the same
+	// mapping's policies are evaluated for every event.
+	programs sync.Map
+)
+
+// Policy is a set of expressions that must all evaluate to true.
+type Policy struct {
+	programs []*program
+}
+
+type program struct {
+	source string
+	prg    cel.Program
+}
+
+// Input is what expressions are evaluated against. Event is exposed as
+// `event` and Workload, the deployment's current state, as `workload`.
+type Input struct {
+	Event    map[string]interface{}
+	Workload map[string]interface{}
+}
+
+func environment() (*cel.Env, error) {
+	envOnce.Do(func() {
+		env, envErr = cel.NewEnv(
+			cel.Variable("event", cel.MapType(cel.StringType, cel.DynType)),
+			cel.Variable("workload", cel.MapType(cel.StringType, cel.DynType)),
+		)
+	})
+	return env, envErr
+}
+
+// Compile parses and type-checks the expressions. Each must produce a bool.
+func Compile(sources []string) (*Policy, error) {
+	p := &Policy{}
+	for _, source := range sources {
+		prg, err := compile(source)
+		if err != nil {
+			return nil, err
+		}
+		p.programs = append(p.programs, prg)
+	}
+	return p, nil
+}
+
+func compile(source string) (*program, error) {
+	if cached, ok := programs.Load(source); ok {
+		return cached.(*program), nil
+	}
+	e, err := environment()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := e.Compile(source)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid policy %q: %w", source, issues.Err())
+	}
+	if !cel.BoolType.IsAssignableType(ast.OutputType()) {
+		return nil, fmt.Errorf("invalid policy %q: produces %s, expected bool", source, ast.OutputType())
+	}
+	prg, err := e.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %q: %w", source, err)
+	}
+	compiled := &program{source: source, prg: prg}
+	programs.Store(source, compiled)
+	return compiled, nil
+}
+
+// Eval reports whether every expression allows the input. If one does not,
+// its source is returned as the reason.
+func (p *Policy) Eval(in *Input) (bool, string, error) {
+	vars := map[string]interface{}{"event": in.Event, "workload": in.Workload}
+	for _, prg := range p.programs {
+		out, _, err := prg.prg.Eval(vars)
+		if err != nil {
+			return false, prg.source, fmt.Errorf("evaluating policy %q: %w", prg.source, err)
+		}
+		allowed, ok := out.Value().(bool)
+		if !ok {
+			return false, prg.source, fmt.Errorf("policy %q produced %v, expected bool", prg.source, out.Value())
+		}
+		if !allowed {
+			return false, prg.source, nil
+		}
+	}
+	return true, "", nil
+}
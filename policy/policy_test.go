@@ -0,0 +1,90 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func testInput() *Input {
+	return &Input{
+		Event: map[string]interface{}{
+			"repository": "library/app",
+			"tag":        "1.4.2",
+			"digest":     "sha256:abc",
+			"operator":   "ci-bot",
+			"provider":   "harbor",
+			"labels":     map[string]string{"branch": "main"},
+			"occur_at":   time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+		},
+		Workload: map[string]interface{}{
+			"tag":         "1.4.1",
+			"annotations": map[string]string{"team": "payments"},
+			"replicas":    3,
+		},
+	}
+}
+
+func TestPolicyEval(t *testing.T) {
+	cases := []struct {
+		source  string
+		allowed bool
+	}{
+		{`event.operator == "ci-bot" && !event.tag.startsWith("pr-")`, true},
+		{`event.operator == "someone-else"`, false},
+		{`event.labels["branch"] == "main"`, true},
+		{`event.provider in ["harbor", "generic"]`, true},
+		{`workload.tag != event.tag`, true},
+		{`workload.annotations["team"] == "payments" && workload.replicas > 1`, true},
+		{`event.occur_at > timestamp("2026-10-19T00:00:00Z")`, true},
+		{`event.tag.matches("^1\\.5\\.")`, false},
+	}
+	for _, c := range cases {
+		p, err := Compile([]string{c.source})
+		if err != nil {
+			t.Errorf("Compile(%q) failed: %v", c.source, err)
+			continue
+		}
+		allowed, reason, err := p.Eval(testInput())
+		if err != nil {
+			t.Errorf("Eval(%q) failed: %v", c.source, err)
+			continue
+		}
+		if allowed != c.allowed {
+			t.Errorf("Eval(%q) = %v, expected %v", c.source, allowed, c.allowed)
+		}
+		if !allowed && reason != c.source {
+			t.Errorf("Eval(%q) gave reason %q", c.source, reason)
+		}
+	}
+}
+
+func TestPolicyAllMustPass(t *testing.T) {
+	p, _ := Compile([]string{`event.provider == "harbor"`, `event.tag == "2"`})
+	allowed, reason, _ := p.Eval(testInput())
+	if allowed || reason != `event.tag == "2"` {
+		t.Errorf("Every expression should have to pass, got %v %q", allowed, reason)
+	}
+	empty, _ := Compile(nil)
+	if allowed, _, _ := empty.Eval(testInput()); !allowed {
+		t.Errorf("An empty policy should allow everything")
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	for _, source := range []string{
+		`event.tag ==`,
+		`"not a bool"`,
+		`unknown.field == 1`,
+	} {
+		if _, err := Compile([]string{source}); err == nil {
+			t.Errorf("Compile(%q) should have failed", source)
+		}
+	}
+}
+
+func TestEvalMissingField(t *testing.T) {
+	p, _ := Compile([]string{`event.nonexistent == "x"`})
+	if _, _, err := p.Eval(testInput()); err == nil {
+		t.Errorf("Referencing a missing field should fail")
+	}
+}
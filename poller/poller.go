@@ -0,0 +1,102 @@
+package poller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.uber.org/zap"
+)
+
+const defaultInterval = 5 * time.Minute
+
+// Poller periodically checks registries for new images on behalf of mappings
+// with a `poll` config, feeding anything new into the deploy pipeline just
+// like a webhook would.
+type Poller struct {
+	Config   *config.Config
+	Logger   *zap.Logger
+	Pipeline *deploy.Pipeline
+}
+
+// Enabled reports whether any mapping is polled: it has a poll config and
+// accepts the `poll` provider, as every mapping that lists no providers does.
+func Enabled(conf *config.Config) bool {
+	for i := range conf.Mappings {
+		if polled(&conf.Mappings[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func polled(m *config.ImageMapping) bool {
+	return m.Poll != nil && m.AcceptsProvider("poll")
+}
+
+// Run polls every configured mapping until the context is cancelled.
+func (p *Poller) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := range p.Config.Mappings {
+		m := &p.Config.Mappings[i]
+		if !polled(m) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.watch(ctx, m)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Poller) watch(ctx context.Context, m *config.ImageMapping) {
+	t := newTarget(m)
+	interval := m.Poll.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	p.Logger.Info("Polling registry",
+		zap.String("image_name", m.ImageName),
+		zap.String("registry", t.client.Host()),
+		zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.poll(t)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Poller) poll(t *target) {
+	event, err := t.check()
+	if err != nil {
+		p.Logger.Info("Error while polling registry",
+			zap.String("image_name", t.mapping.ImageName),
+			zap.Error(err))
+		return
+	}
+	if event == nil {
+		return
+	}
+	p.Logger.Info("Detected new image in registry",
+		zap.String("image_name", t.mapping.ImageName),
+		zap.String("tag", event.Tag),
+		zap.String("digest", event.Digest))
+	result, err := p.Pipeline.Apply(t.mapping, event)
+	if err != nil {
+		p.Logger.Info("Error while updating deployment", zap.Error(err))
+		return
+	}
+	p.Logger.Info("Processed polled image",
+		zap.String("image_name", t.mapping.ImageName),
+		zap.String("status", string(result.Status)))
+}
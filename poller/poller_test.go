@@ -0,0 +1,83 @@
+package poller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/registry/registrytest"
+	"go.uber.org/zap"
+)
+
+func TestEnabled(t *testing.T) {
+	conf := &config.Config{Mappings: []config.ImageMapping{{ImageName: "vendor/app"}}}
+	if Enabled(conf) {
+		t.Errorf("Mappings without a poll config should not be polled")
+	}
+	conf.Mappings[0].Poll = &config.PollConfig{Registry: "https://registry.b8s.dev"}
+	if !Enabled(conf) {
+		t.Errorf("Mappings with a poll config and no providers should be polled")
+	}
+	conf.Mappings[0].Providers = []string{"harbor"}
+	if Enabled(conf) {
+		t.Errorf("Mappings that don't accept the poll provider should not be polled")
+	}
+}
+
+func TestPollerRun(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	fake.Push("vendor/app", "1.0.0", "sha256:aaa")
+
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: fake.Host() + "/vendor/app:1.0.0"}},
+	})
+	conf := &config.Config{
+		Mappings: []config.ImageMapping{
+			{
+				ImageName:      "vendor/app",
+				DeploymentName: "app",
+				Namespace:      "default",
+				// Without providers, the mapping accepts polled events.
+				Poll: &config.PollConfig{Registry: fake.URL, Interval: 10 * time.Millisecond},
+			},
+		},
+	}
+	logger := zap.NewNop()
+	p := &Poller{
+		Config:   conf,
+		Logger:   logger,
+		Pipeline: &deploy.Pipeline{Config: conf, Logger: logger, Client: client},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	fake.Push("vendor/app", "1.1.0", "sha256:bbb")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		d, _ := client.GetDeployment("default", "app")
+		if d.Containers[0].Image == fake.Host()+"/vendor/app:1.1.0" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != fake.Host()+"/vendor/app:1.1.0" {
+		t.Errorf("Poller did not deploy the new tag, image was: %s", d.Containers[0].Image)
+	}
+}
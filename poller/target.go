@@ -0,0 +1,107 @@
+package poller
+
+import (
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.b8s.dev/rollingpin/registry"
+)
+
+// target tracks what has already been seen in the registry for one mapping,
+// so that only changes produce events. The first check only records the
+// registry's current state.
+type target struct {
+	mapping    *config.ImageMapping
+	client     *registry.Client
+	repository string
+
+	primed bool
+	seen   map[string]bool
+	digest string
+}
+
+func newTarget(m *config.ImageMapping) *target {
+	repository := m.Poll.Repository
+	if repository == "" {
+		repository = m.ImageName
+	}
+	return &target{
+		mapping:    m,
+		client:     registry.New(m.Poll.Registry, m.Poll.Username, m.Poll.Password),
+		repository: repository,
+		seen:       map[string]bool{},
+	}
+}
+
+// check polls the registry once and returns an Event if something new has
+// appeared since the last check.
+func (t *target) check() (*deploy.Event, error) {
+	if t.mapping.Poll.Tag != "" {
+		return t.checkDigest()
+	}
+	return t.checkTags()
+}
+
+// checkDigest watches a single tag for a change in the digest it points to.
+func (t *target) checkDigest() (*deploy.Event, error) {
+	tag := t.mapping.Poll.Tag
+	digest, err := t.client.ResolveDigest(t.repository, tag)
+	if err != nil {
+		return nil, err
+	}
+	changed := t.primed && digest != t.digest
+	t.primed = true
+	t.digest = digest
+	if !changed {
+		return nil, nil
+	}
+	return t.event(tag, digest), nil
+}
+
+// checkTags watches the repository for tags that have not been seen before.
+// If several appear at once, the one the mapping's tag policy ranks highest
+// is deployed, falling back to the registry's lexical ordering.
+func (t *target) checkTags() (*deploy.Event, error) {
+	tags, err := t.client.ListTags(t.repository)
+	if err != nil {
+		return nil, err
+	}
+	var newest string
+	for _, tag := range tags {
+		if t.seen[tag] || deploy.AdmitTag(t.mapping, tag) != nil {
+			continue
+		}
+		if newest == "" || deploy.CompareTags(t.mapping, tag, newest) >= 0 {
+			newest = tag
+		}
+	}
+	if !t.primed || newest == "" {
+		t.markSeen(tags)
+		return nil, nil
+	}
+	digest, err := t.client.ResolveDigest(t.repository, newest)
+	if err != nil {
+		return nil, err
+	}
+	t.markSeen(tags)
+	return t.event(newest, digest), nil
+}
+
+func (t *target) markSeen(tags []string) {
+	t.primed = true
+	for _, tag := range tags {
+		t.seen[tag] = true
+	}
+}
+
+func (t *target) event(tag string, digest string) *deploy.Event {
+	return &deploy.Event{
+		Provider:   "poll",
+		Repository: t.mapping.ImageName,
+		Tag:        tag,
+		Digest:     digest,
+		ImageURL:   t.client.Host() + "/" + t.repository + ":" + tag,
+		OccurAt:    time.Now(),
+	}
+}
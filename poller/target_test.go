@@ -0,0 +1,92 @@
+package poller
+
+import (
+	"testing"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/registry/registrytest"
+)
+
+func TestTargetCheckTags(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	fake.Push("vendor/app", "1.0.0", "sha256:aaa")
+
+	tg := newTarget(&config.ImageMapping{
+		ImageName: "vendor/app",
+		Poll:      &config.PollConfig{Registry: fake.URL},
+	})
+	if event, err := tg.check(); event != nil || err != nil {
+		t.Errorf("The first check should only prime the target: %v, %v", event, err)
+	}
+	if event, _ := tg.check(); event != nil {
+		t.Errorf("check should not report unchanged tags: %v", event)
+	}
+
+	fake.Push("vendor/app", "1.1.0", "sha256:bbb")
+	event, err := tg.check()
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if event == nil || event.Tag != "1.1.0" || event.Digest != "sha256:bbb" {
+		t.Fatalf("check did not report the new tag: %v", event)
+	}
+	if event.ImageURL != fake.Host()+"/vendor/app:1.1.0" {
+		t.Errorf("check built incorrect ImageURL: %s", event.ImageURL)
+	}
+}
+
+func TestTargetCheckDigest(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	fake.Username = "robot"
+	fake.Password = "hunter2"
+	fake.Push("upstream/app", "stable", "sha256:aaa")
+
+	tg := newTarget(&config.ImageMapping{
+		ImageName: "vendor/app",
+		Poll: &config.PollConfig{
+			Registry:   fake.URL,
+			Repository: "upstream/app",
+			Tag:        "stable",
+			Username:   "robot",
+			Password:   "hunter2",
+		},
+	})
+	if event, err := tg.check(); event != nil || err != nil {
+		t.Errorf("The first check should only prime the target: %v, %v", event, err)
+	}
+
+	fake.Push("upstream/app", "stable", "sha256:bbb")
+	event, err := tg.check()
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if event == nil || event.Digest != "sha256:bbb" || event.Repository != "vendor/app" {
+		t.Errorf("check did not report the new digest: %v", event)
+	}
+}
+
+func TestTargetCheckTagsPolicyOrder(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	fake.Push("vendor/app", "main-9", "sha256:aaa")
+
+	tg := newTarget(&config.ImageMapping{
+		ImageName: "vendor/app",
+		Poll:      &config.PollConfig{Registry: fake.URL},
+		TagPolicy: &config.TagPolicy{Pattern: `main-(?P<build>\d+)`, Order: "numeric", OrderBy: "build"},
+	})
+	tg.check()
+
+	fake.Push("vendor/app", "main-10", "sha256:bbb")
+	fake.Push("vendor/app", "main-11", "sha256:ccc")
+	fake.Push("vendor/app", "pr-99", "sha256:ddd")
+	event, err := tg.check()
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if event == nil || event.Tag != "main-11" {
+		t.Errorf("check should report the highest ranked new tag: %v", event)
+	}
+}
@@ -0,0 +1,599 @@
+// Package promote moves images along promotion chains: once an image has
+// rolled out at one stage and soaked there, the same image is deployed to the
+// next stage.
+package promote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.b8s.dev/rollingpin/rollout"
+	"go.b8s.dev/rollingpin/state"
+	"go.uber.org/zap"
+)
+
+// historyLimit is how many records are kept per chain.
+const historyLimit = 100
+
+// promotionProvider is the provider of the events that promote an image to
+// the next stage.
+const promotionProvider = "promotion"
+
+// validName matches the chain names that can be used as state store keys.
+var validName = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+var ErrUnknownChain = errors.New("unknown promotion chain")
+
+// State is what happened to a promotion.
+type State string
+
+const (
+	// StateScheduled means the image is soaking and will be promoted when
+	// the soak ends.
+	StateScheduled State = "scheduled"
+	// StatePending means the promotion was handed to the next stage, which
+	// is holding it: it was queued as a job, or waits on an approval, a
+	// freeze, a canary or a debounce window.
+	StatePending  State = "pending"
+	StatePromoted State = "promoted"
+	// StateCancelled means a scheduled promotion was dropped, because the
+	// chain was halted or the source stage moved to another image.
+	StateCancelled State = "cancelled"
+	// StateBlocked means the source rollout failed, or the chain was halted
+	// when the rollout finished, so nothing was scheduled.
+	StateBlocked State = "blocked"
+	StateFailed  State = "failed"
+	StateHalted  State = "halted"
+	StateResumed State = "resumed"
+)
+
+// Record is an entry in a chain's promotion history.
+type Record struct {
+	// ID orders the records of a chain. It is the key of the record in the
+	// state store.
+	ID     string        `json:"id"`
+	Chain  string        `json:"chain"`
+	State  State         `json:"state"`
+	From   string        `json:"from,omitempty"`
+	To     string        `json:"to,omitempty"`
+	Image  string        `json:"image,omitempty"`
+	Tag    string        `json:"tag,omitempty"`
+	Digest string        `json:"digest,omitempty"`
+	Result deploy.Status `json:"result,omitempty"`
+	Reason string        `json:"reason,omitempty"`
+	By     string        `json:"by,omitempty"`
+	At     time.Time     `json:"at"`
+
+	PromoteAt *time.Time `json:"promote_at,omitempty"`
+}
+
+// Chain is the current state of a promotion chain.
+type Chain struct {
+	Name   string        `json:"name"`
+	Stages []string      `json:"stages"`
+	Soak   time.Duration `json:"soak"`
+	Halted bool          `json:"halted"`
+}
+
+// Promoter listens for rollout results and promotes images along the
+// configured chains.
+type Promoter struct {
+	Logger   *zap.Logger
+	Pipeline *deploy.Pipeline
+
+	// Backend, if set, records which chains are halted, so that a halt sent
+	// to any replica stops the chains of the one running promotions. It
+	// also keeps the promotion history, so that every replica serves it and
+	// scheduled promotions survive a restart or a change of leader.
+	Backend state.Store
+
+	mu      sync.Mutex
+	chains  []*chain
+	history map[string][]*Record
+	seq     int
+	now     func() time.Time
+}
+
+type chain struct {
+	config.Promotion
+	stages []*config.ImageMapping
+	halted bool
+	// pending holds soak timers keyed by the stage being promoted to.
+	pending map[int]*time.Timer
+}
+
+// New validates the configured chains against the mappings.
+func New(conf *config.Config, logger *zap.Logger, pipeline *deploy.Pipeline) (*Promoter, error) {
+	p := &Promoter{Logger: logger, Pipeline: pipeline, history: map[string][]*Record{}}
+	names := map[string]bool{}
+	for _, promotion := range conf.Promotions {
+		if promotion.Name == "" {
+			return nil, fmt.Errorf("promotion chains must have a name")
+		}
+		if !validName.MatchString(promotion.Name) {
+			return nil, fmt.Errorf("promotion chain %q: names may only contain letters, digits, `-`, `_` and `.`", promotion.Name)
+		}
+		if names[promotion.Name] {
+			return nil, fmt.Errorf("duplicate promotion chain %q", promotion.Name)
+		}
+		names[promotion.Name] = true
+		if len(promotion.Stages) < 2 {
+			return nil, fmt.Errorf("promotion chain %q needs at least two stages", promotion.Name)
+		}
+		c := &chain{Promotion: promotion, pending: map[int]*time.Timer{}}
+		for _, stage := range promotion.Stages {
+			namespace, deployment, ok := cut(stage, "/")
+			m := config.FindMapping(conf, namespace, deployment)
+			if !ok || m == nil {
+				return nil, fmt.Errorf("promotion chain %q: no mapping for stage %q", promotion.Name, stage)
+			}
+			c.stages = append(c.stages, m)
+		}
+		p.chains = append(p.chains, c)
+	}
+	return p, nil
+}
+
+// HandleRollout schedules a promotion to the next stage of every chain the
+// rolled out deployment is part of. Rollbacks are never promoted.
+func (p *Promoter) HandleRollout(r *rollout.Result) {
+	if r.Rollback {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.chains {
+		for i, m := range c.stages[:len(c.stages)-1] {
+			if m.Namespace != r.Namespace || m.DeploymentName != r.Deployment {
+				continue
+			}
+			p.schedule(c, i+1, r)
+		}
+	}
+}
+
+// schedule starts the soak before promoting to stage next. The caller must
+// hold the lock.
+func (p *Promoter) schedule(c *chain, next int, r *rollout.Result) {
+	record := &Record{
+		Chain:  c.Name,
+		From:   c.Stages[next-1],
+		To:     c.Stages[next],
+		Image:  r.Image,
+		Tag:    r.Tag,
+		Digest: r.Digest,
+		At:     p.clock(),
+	}
+	if timer, ok := c.pending[next]; ok {
+		timer.Stop()
+		delete(c.pending, next)
+	}
+	switch {
+	case !r.Succeeded:
+		record.State = StateBlocked
+		record.Reason = "rollout failed: " + r.Reason
+		p.record(record)
+		return
+	case p.halted(c):
+		record.State = StateBlocked
+		record.Reason = "chain is halted"
+		p.record(record)
+		return
+	}
+
+	promoteAt := record.At.Add(c.Soak)
+	record.State = StateScheduled
+	record.PromoteAt = &promoteAt
+	p.record(record)
+	p.soak(c, next, r, c.Soak)
+}
+
+// soak promotes r to stage next once d has passed, unless the chain is
+// halted by then. The caller must hold the lock.
+func (p *Promoter) soak(c *chain, next int, r *rollout.Result, d time.Duration) {
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		p.mu.Lock()
+		if c.pending[next] != timer {
+			p.mu.Unlock()
+			return
+		}
+		delete(c.pending, next)
+		if p.halted(c) {
+			// Another replica halted the chain during the soak.
+			p.record(&Record{Chain: c.Name, State: StateCancelled, To: c.Stages[next], Reason: "chain halted", At: p.clock()})
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+		p.promote(c, next, r)
+	})
+	c.pending[next] = timer
+}
+
+// promote deploys the source stage's image to stage next, provided the
+// source is still running it. The promotion is recorded as pending until
+// HandleResult hears how it went.
+func (p *Promoter) promote(c *chain, next int, r *rollout.Result) {
+	source, target := c.stages[next-1], c.stages[next]
+	record := &Record{
+		Chain:  c.Name,
+		From:   c.Stages[next-1],
+		To:     c.Stages[next],
+		Image:  r.Image,
+		Tag:    r.Tag,
+		Digest: r.Digest,
+		State:  StatePending,
+	}
+
+	d, err := p.Pipeline.Client.GetDeployment(source.Namespace, source.DeploymentName)
+	if err == nil && d.Image(source.Container) != r.Image {
+		err = fmt.Errorf("%s no longer runs %s", record.From, r.Image)
+		record.State = StateCancelled
+	}
+	if err != nil {
+		if record.State == StatePending {
+			record.State = StateFailed
+		}
+		record.Reason = err.Error()
+	}
+	p.mu.Lock()
+	record.At = p.clock()
+	p.record(record)
+	p.mu.Unlock()
+
+	if err == nil {
+		p.Pipeline.Apply(target, &deploy.Event{
+			Provider:   promotionProvider,
+			Repository: target.ImageName,
+			Tag:        r.Tag,
+			Digest:     r.Digest,
+			ImageURL:   promotedImage(r.Image, r.Digest),
+		})
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Logger.Info("Promoting image",
+		zap.String("chain", c.Name),
+		zap.String("from", record.From),
+		zap.String("to", record.To),
+		zap.String("image", record.Image),
+		zap.String("state", string(record.State)),
+		zap.String("reason", record.Reason))
+}
+
+// HandleResult records the outcome of a promotion's deploy to the next
+// stage. A promotion is only promoted once the stage is updated: until then,
+// while the stage holds it, it stays pending, and if the stage skips or
+// rejects it, it is blocked.
+func (p *Promoter) HandleResult(m *config.ImageMapping, e *deploy.Event, result *deploy.Result, err error) {
+	if e.Provider != promotionProvider {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.chains {
+		for i, stage := range c.stages[1:] {
+			if stage.Namespace != m.Namespace || stage.DeploymentName != m.DeploymentName {
+				continue
+			}
+			if record := p.pending(c.Name, c.Stages[i+1], e.ImageURL); record != nil {
+				p.settle(record, result, err)
+				p.save(record)
+			}
+		}
+	}
+}
+
+// settle updates a pending promotion with the result of its deploy. The
+// caller must hold the lock.
+func (p *Promoter) settle(record *Record, result *deploy.Result, err error) {
+	record.At = p.clock()
+	if err != nil {
+		// Jobs are retried, so a later attempt may still promote.
+		record.State = StateFailed
+		record.Result = ""
+		record.Reason = err.Error()
+		return
+	}
+	record.Result = result.Status
+	record.Reason = result.Reason
+	switch result.Status {
+	case deploy.StatusDeployed:
+		record.State = StatePromoted
+	case deploy.StatusAccepted, deploy.StatusQueued, deploy.StatusPendingApproval, deploy.StatusCanary, deploy.StatusDebounced:
+		record.State = StatePending
+	default:
+		record.State = StateBlocked
+	}
+}
+
+// pending returns the latest promotion of image to a stage that is still
+// waiting on its outcome, or nil. Failed promotions are included, since
+// their job may still be retried. The caller must hold the lock.
+func (p *Promoter) pending(chain string, stage string, image string) *Record {
+	history := p.history[chain]
+	for i := len(history) - 1; i >= 0; i-- {
+		r := history[i]
+		if r.To != stage || (r.State != StatePending && r.State != StateFailed) {
+			continue
+		}
+		if promotedImage(r.Image, r.Digest) == image {
+			return r
+		}
+	}
+	return nil
+}
+
+// promotedImage references the image by digest when it is known, so the next
+// stage receives exactly what was soaked even if the tag has since moved.
+func promotedImage(image string, knownDigest string) string {
+	name, _, digest := deploy.ParseImage(image)
+	if digest == "" && knownDigest != "" {
+		return name + "@" + knownDigest
+	}
+	return image
+}
+
+// Halt stops a chain: scheduled promotions are cancelled and no new ones are
+// scheduled until it is resumed.
+func (p *Promoter) Halt(name string, by string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.find(name)
+	if c == nil {
+		return ErrUnknownChain
+	}
+	if p.Backend != nil {
+		if err := state.PutJSON(p.Backend, state.BucketHalts, name, &halt{By: by, At: p.clock()}); err != nil {
+			return err
+		}
+	}
+	for next, timer := range c.pending {
+		timer.Stop()
+		delete(c.pending, next)
+		p.record(&Record{Chain: name, State: StateCancelled, To: c.Stages[next], Reason: "chain halted", At: p.clock()})
+	}
+	c.halted = true
+	p.record(&Record{Chain: name, State: StateHalted, By: by, At: p.clock()})
+	p.Logger.Info("Promotion chain halted", zap.String("chain", name), zap.String("by", by))
+	return nil
+}
+
+// Resume lets a halted chain schedule promotions again. Rollouts that
+// finished while it was halted are not replayed.
+func (p *Promoter) Resume(name string, by string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.find(name)
+	if c == nil {
+		return ErrUnknownChain
+	}
+	if p.Backend != nil {
+		if err := p.Backend.Delete(state.BucketHalts, name); err != nil {
+			return err
+		}
+	}
+	c.halted = false
+	p.record(&Record{Chain: name, State: StateResumed, By: by, At: p.clock()})
+	p.Logger.Info("Promotion chain resumed", zap.String("chain", name), zap.String("by", by))
+	return nil
+}
+
+// Chains returns the state of every chain.
+func (p *Promoter) Chains() []*Chain {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var chains []*Chain
+	for _, c := range p.chains {
+		chains = append(chains, &Chain{Name: c.Name, Stages: c.Stages, Soak: c.Soak, Halted: p.halted(c)})
+	}
+	return chains
+}
+
+// History returns a chain's promotion history, oldest first. With a backend,
+// it is the history recorded by whichever replica ran the promotions.
+func (p *Promoter) History(name string) ([]*Record, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.find(name) == nil {
+		return nil, ErrUnknownChain
+	}
+	if p.Backend != nil {
+		histories, err := p.load()
+		if err != nil {
+			return nil, err
+		}
+		return histories[name], nil
+	}
+	// Records are copied, since pending ones are updated in place.
+	history := make([]*Record, len(p.history[name]))
+	for i, r := range p.history[name] {
+		copied := *r
+		history[i] = &copied
+	}
+	return history, nil
+}
+
+// Load reads the promotion history from the backend and reschedules the
+// promotions that were still soaking, promoting at once those whose soak
+// ended in the meantime. It is called when this replica starts running
+// promotions.
+func (p *Promoter) Load() error {
+	if p.Backend == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	histories, err := p.load()
+	if err != nil {
+		return err
+	}
+	for chain, history := range histories {
+		if len(history) > historyLimit {
+			for _, forgotten := range history[:len(history)-historyLimit] {
+				p.forget(forgotten)
+			}
+			histories[chain] = history[len(history)-historyLimit:]
+		}
+	}
+	p.history = histories
+	for _, c := range p.chains {
+		for next, timer := range c.pending {
+			timer.Stop()
+			delete(c.pending, next)
+		}
+		for next := 1; next < len(c.stages); next++ {
+			record := p.latest(c.Name, c.Stages[next])
+			if record == nil || record.State != StateScheduled || record.PromoteAt == nil {
+				continue
+			}
+			source := c.stages[next-1]
+			r := &rollout.Result{
+				Target: rollout.Target{
+					Namespace:  source.Namespace,
+					Deployment: source.DeploymentName,
+					Image:      record.Image,
+					Tag:        record.Tag,
+					Digest:     record.Digest,
+				},
+				Succeeded: true,
+			}
+			d := record.PromoteAt.Sub(p.clock())
+			if d < 0 {
+				d = 0
+			}
+			p.soak(c, next, r, d)
+			p.Logger.Info("Rescheduled promotion",
+				zap.String("chain", c.Name),
+				zap.String("to", record.To),
+				zap.String("image", record.Image),
+				zap.Time("promote_at", *record.PromoteAt))
+		}
+	}
+	return nil
+}
+
+// load reads every chain's history from the backend, oldest first.
+func (p *Promoter) load() (map[string][]*Record, error) {
+	saved, err := p.Backend.List(state.BucketPromotions)
+	if err != nil {
+		return nil, err
+	}
+	histories := map[string][]*Record{}
+	for _, b := range saved {
+		record := &Record{}
+		if err := json.Unmarshal(b, record); err != nil {
+			return nil, err
+		}
+		histories[record.Chain] = append(histories[record.Chain], record)
+	}
+	for _, history := range histories {
+		sort.Slice(history, func(i, j int) bool { return history[i].ID < history[j].ID })
+	}
+	return histories, nil
+}
+
+// latest returns the last record of a promotion to a stage, or nil. The
+// caller must hold the lock.
+func (p *Promoter) latest(chain string, stage string) *Record {
+	history := p.history[chain]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].To == stage {
+			return history[i]
+		}
+	}
+	return nil
+}
+
+// halt is what is stored in the backend for a halted chain.
+type halt struct {
+	By string    `json:"by"`
+	At time.Time `json:"at"`
+}
+
+// halted reports whether a chain is halted, on this replica or any other.
+// The caller must hold the lock.
+func (p *Promoter) halted(c *chain) bool {
+	if p.Backend == nil {
+		return c.halted
+	}
+	_, err := p.Backend.Get(state.BucketHalts, c.Name)
+	if err != nil && !errors.Is(err, state.ErrNotFound) {
+		p.Logger.Warn("Error while checking whether chain is halted", zap.String("chain", c.Name), zap.Error(err))
+		return c.halted
+	}
+	return err == nil
+}
+
+func (p *Promoter) find(name string) *chain {
+	for _, c := range p.chains {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// record appends to a chain's history. The caller must hold the lock.
+func (p *Promoter) record(r *Record) {
+	// The sequence number orders records created at the same instant.
+	p.seq = (p.seq + 1) % 1000000
+	r.ID = fmt.Sprintf("%s.%019d.%06d", r.Chain, r.At.UnixNano(), p.seq)
+	p.save(r)
+
+	history := append(p.history[r.Chain], r)
+	if len(history) > historyLimit {
+		for _, forgotten := range history[:len(history)-historyLimit] {
+			p.forget(forgotten)
+		}
+		history = history[len(history)-historyLimit:]
+	}
+	p.history[r.Chain] = history
+}
+
+// save stores a record in the backend. Failures are only logged: the record
+// is still kept in memory, and promotions go on. The caller must hold the
+// lock.
+func (p *Promoter) save(r *Record) {
+	if p.Backend == nil {
+		return
+	}
+	if err := state.PutJSON(p.Backend, state.BucketPromotions, r.ID, r); err != nil {
+		p.Logger.Warn("Error while saving promotion record", zap.String("chain", r.Chain), zap.Error(err))
+	}
+}
+
+// forget deletes a record that fell out of the history from the backend.
+// The caller must hold the lock.
+func (p *Promoter) forget(r *Record) {
+	if p.Backend == nil {
+		return
+	}
+	if err := p.Backend.Delete(state.BucketPromotions, r.ID); err != nil {
+		p.Logger.Warn("Error while deleting promotion record", zap.String("chain", r.Chain), zap.Error(err))
+	}
+}
+
+func (p *Promoter) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+func cut(s string, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,263 @@
+package promote
+
+import (
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/approval"
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/rollout"
+	"go.b8s.dev/rollingpin/state"
+	"go.uber.org/zap"
+)
+
+const digest = "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func testPromoter(t *testing.T, soak time.Duration) (*Promoter, *kube.Client) {
+	client, _ := kube.NewFake()
+	for _, ns := range []string{"staging", "production"} {
+		client.CreateDeployment(&kube.Deployment{
+			Name:       "app",
+			Namespace:  ns,
+			Containers: []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/app:1"}},
+		})
+	}
+	conf := &config.Config{
+		Mappings: []config.ImageMapping{
+			{ImageName: "library/app", DeploymentName: "app", Namespace: "staging"},
+			{ImageName: "library/app", DeploymentName: "app", Namespace: "production"},
+		},
+		Promotions: []config.Promotion{
+			{Name: "app", Stages: []string{"staging/app", "production/app"}, Soak: soak},
+		},
+	}
+	pipeline := &deploy.Pipeline{Config: conf, Logger: zap.NewNop(), Client: client}
+	p, err := New(conf, zap.NewNop(), pipeline)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	pipeline.OnResult(p.HandleResult)
+	return p, client
+}
+
+func stagingResult(client *kube.Client, succeeded bool) *rollout.Result {
+	client.UpdateDeploymentImage("staging", "app", "cr.b8s.dev/library/app:2")
+	return &rollout.Result{
+		Target: rollout.Target{
+			Namespace:  "staging",
+			Deployment: "app",
+			Image:      "cr.b8s.dev/library/app:2",
+			Tag:        "2",
+			Digest:     digest,
+		},
+		Succeeded: succeeded,
+	}
+}
+
+// waitFor polls the chain's history until its latest record is in state.
+func waitFor(t *testing.T, p *Promoter, state State) *Record {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		history, _ := p.History("app")
+		if len(history) > 0 && history[len(history)-1].State == state {
+			return history[len(history)-1]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	history, _ := p.History("app")
+	t.Fatalf("Chain never reached %s: %+v", state, history)
+	return nil
+}
+
+func TestPromoteAfterSoak(t *testing.T) {
+	p, client := testPromoter(t, 20*time.Millisecond)
+
+	p.HandleRollout(stagingResult(client, true))
+	history, _ := p.History("app")
+	if len(history) != 1 || history[0].State != StateScheduled || history[0].PromoteAt == nil {
+		t.Fatalf("Promotion should be scheduled: %+v", history)
+	}
+	d, _ := client.GetDeployment("production", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/app:1" {
+		t.Errorf("Production should not change before the soak ends")
+	}
+
+	record := waitFor(t, p, StatePromoted)
+	if record.Result != deploy.StatusDeployed {
+		t.Errorf("Promotion should have deployed, got %s", record.Result)
+	}
+	d, _ = client.GetDeployment("production", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/app@"+digest {
+		t.Errorf("Production should run the staging digest, got %s", d.Containers[0].Image)
+	}
+}
+
+func TestFailedRolloutBlocksPromotion(t *testing.T) {
+	p, client := testPromoter(t, 0)
+
+	p.HandleRollout(stagingResult(client, false))
+	history, _ := p.History("app")
+	if len(history) != 1 || history[0].State != StateBlocked {
+		t.Fatalf("Failed rollout should block promotion: %+v", history)
+	}
+	time.Sleep(20 * time.Millisecond)
+	d, _ := client.GetDeployment("production", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/app:1" {
+		t.Errorf("Production changed after a failed rollout: %s", d.Containers[0].Image)
+	}
+}
+
+func TestHaltCancelsPromotion(t *testing.T) {
+	p, client := testPromoter(t, 50*time.Millisecond)
+
+	p.HandleRollout(stagingResult(client, true))
+	if err := p.Halt("app", "alice"); err != nil {
+		t.Fatalf("Halt failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	d, _ := client.GetDeployment("production", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/app:1" {
+		t.Errorf("Halted chain was promoted: %s", d.Containers[0].Image)
+	}
+
+	p.HandleRollout(stagingResult(client, true))
+	record := waitFor(t, p, StateBlocked)
+	if record.Reason != "chain is halted" {
+		t.Errorf("Rollouts on a halted chain should be blocked: %+v", record)
+	}
+
+	if err := p.Resume("app", "alice"); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	p.HandleRollout(stagingResult(client, true))
+	waitFor(t, p, StatePromoted)
+	if chains := p.Chains(); chains[0].Halted {
+		t.Errorf("Chain should no longer be halted")
+	}
+}
+
+func TestHaltOnAnotherReplica(t *testing.T) {
+	leader, client := testPromoter(t, 50*time.Millisecond)
+	follower, _ := testPromoter(t, 50*time.Millisecond)
+	backend := state.NewMemory()
+	leader.Backend = backend
+	follower.Backend = backend
+
+	leader.HandleRollout(stagingResult(client, true))
+	if err := follower.Halt("app", "alice"); err != nil {
+		t.Fatalf("Halt failed: %v", err)
+	}
+	record := waitFor(t, leader, StateCancelled)
+	if record.Reason != "chain halted" {
+		t.Errorf("Soak should be cancelled by the other replica's halt: %+v", record)
+	}
+	d, _ := client.GetDeployment("production", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/app:1" {
+		t.Errorf("Chain halted on another replica was promoted: %s", d.Containers[0].Image)
+	}
+	if chains := leader.Chains(); !chains[0].Halted {
+		t.Errorf("Leader should see the chain as halted")
+	}
+
+	if err := follower.Resume("app", "alice"); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	leader.HandleRollout(stagingResult(client, true))
+	waitFor(t, leader, StatePromoted)
+}
+
+func TestScheduledPromotionSurvivesRestart(t *testing.T) {
+	previous, client := testPromoter(t, time.Hour)
+	backend := state.NewMemory()
+	previous.Backend = backend
+	previous.HandleRollout(stagingResult(client, true))
+
+	// The replica taking over shares the cluster and the backend, and finds
+	// the soak over by the time it loads.
+	p, _ := testPromoter(t, time.Hour)
+	p.Pipeline.Client = client
+	p.Backend = backend
+	p.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	history, err := p.History("app")
+	if err != nil || len(history) != 1 || history[0].State != StateScheduled {
+		t.Fatalf("Scheduled promotion should be served from the backend: %+v, %v", history, err)
+	}
+	if err := p.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	waitFor(t, p, StatePromoted)
+	d, _ := client.GetDeployment("production", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/app@"+digest {
+		t.Errorf("Rescheduled promotion should deploy the soaked image: %s", d.Containers[0].Image)
+	}
+}
+
+func TestPromotionPendingUntilApproved(t *testing.T) {
+	p, client := testPromoter(t, 0)
+	p.chains[0].stages[1].RequireApproval = true
+	p.Pipeline.Approvals = approval.NewStore(time.Hour)
+
+	p.HandleRollout(stagingResult(client, true))
+	record := waitFor(t, p, StatePending)
+	if record.Result != deploy.StatusPendingApproval {
+		t.Errorf("Promotion waiting on approval should be pending, got %+v", record)
+	}
+
+	pending, _ := p.Pipeline.Approvals.List(approval.StatePending)
+	if len(pending) != 1 {
+		t.Fatalf("Expected one pending approval, got %d", len(pending))
+	}
+	if _, _, err := p.Pipeline.Approve(pending[0].ID, "alice"); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	record = waitFor(t, p, StatePromoted)
+	if record.Result != deploy.StatusDeployed {
+		t.Errorf("Approved promotion should have deployed, got %+v", record)
+	}
+	if history, _ := p.History("app"); len(history) != 2 {
+		t.Errorf("Pending promotion should be updated, not recorded again: %+v", history)
+	}
+}
+
+func TestPromotionBlockedByStage(t *testing.T) {
+	p, client := testPromoter(t, 0)
+	p.chains[0].stages[1].TagPolicy = &config.TagPolicy{Semver: ">=3"}
+
+	p.HandleRollout(stagingResult(client, true))
+	record := waitFor(t, p, StateBlocked)
+	if record.Result != deploy.StatusSkipped {
+		t.Errorf("Promotion the stage skipped should be blocked, got %+v", record)
+	}
+}
+
+func TestPromotionCancelledWhenSourceMoves(t *testing.T) {
+	p, client := testPromoter(t, 20*time.Millisecond)
+
+	p.HandleRollout(stagingResult(client, true))
+	client.UpdateDeploymentImage("staging", "app", "cr.b8s.dev/library/app:3")
+	waitFor(t, p, StateCancelled)
+	d, _ := client.GetDeployment("production", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/app:1" {
+		t.Errorf("Production should not receive an image staging no longer runs: %s", d.Containers[0].Image)
+	}
+}
+
+func TestNewValidatesStages(t *testing.T) {
+	conf := &config.Config{
+		Mappings: []config.ImageMapping{{ImageName: "library/app", DeploymentName: "app", Namespace: "staging"}},
+		Promotions: []config.Promotion{
+			{Name: "app", Stages: []string{"staging/app", "production/app"}},
+		},
+	}
+	if _, err := New(conf, zap.NewNop(), nil); err == nil {
+		t.Errorf("New should reject stages without a mapping")
+	}
+	if _, err := New(conf, zap.NewNop(), nil); err != nil && err.Error() != `promotion chain "app": no mapping for stage "production/app"` {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := (&Promoter{}).Halt("missing", "alice"); err != ErrUnknownChain {
+		t.Errorf("Halting an unknown chain should fail, got %v", err)
+	}
+}
@@ -5,7 +5,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.b8s.dev/rollingpin/config"
-	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/deploy"
 	"go.uber.org/zap"
 )
 
@@ -15,9 +15,9 @@ type DirectWebhook struct {
 }
 
 type Router struct {
-	Config *config.Config
-	Logger *zap.Logger
-	Client kube.IClient
+	Config   *config.Config
+	Logger   *zap.Logger
+	Pipeline *deploy.Pipeline
 }
 
 func (r *Router) Mount(g *gin.RouterGroup) {
@@ -29,28 +29,26 @@ func (r *Router) Mount(g *gin.RouterGroup) {
 			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"ok": false})
 			return
 		}
-		err = r.handleWebhook(&webhook)
+		result, err := r.handleWebhook(&webhook, c.GetHeader("Idempotency-Key"))
 		if err != nil {
 			r.Logger.Info("Error while updating deployment", zap.Error(err))
 			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"ok": false})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"ok": true})
+		deploy.Respond(c, result)
 	})
 }
 
-func (r *Router) handleWebhook(w *DirectWebhook) error {
+// handleWebhook deploys the webhook's image. Requests with the same
+// idempotency key are only applied once.
+func (r *Router) handleWebhook(w *DirectWebhook, idempotencyKey string) (*deploy.Result, error) {
 	r.Logger.Info("Received direct webhook", zap.String("repository_name", w.RepositoryName))
-	for _, m := range r.Config.Mappings {
-		if w.RepositoryName == m.ImageName {
-			err := r.Client.UpdateDeploymentImage(m.Namespace, m.DeploymentName, w.ImageURL)
-			r.Logger.Info("Updated deployment",
-				zap.String("image_name", m.ImageName),
-				zap.String("deployment", m.DeploymentName))
-			return err
-		}
-	}
-	return nil
+	return r.Pipeline.Deploy(&deploy.Event{
+		Provider:   "direct",
+		ID:         idempotencyKey,
+		Repository: w.RepositoryName,
+		ImageURL:   w.ImageURL,
+	})
 }
 
 func (r *Router) auth() gin.HandlerFunc {
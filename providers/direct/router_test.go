@@ -9,6 +9,10 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/state"
+	"go.uber.org/zap"
 )
 
 func TestUnmarshalWebhook(t *testing.T) {
@@ -67,3 +71,80 @@ func buildTestConn(req *http.Request) (*gin.Context, *httptest.ResponseRecorder)
 	conn.Request = req
 	return conn, w
 }
+
+func TestHandleWebhookTagPolicy(t *testing.T) {
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/debian:1.4.0"}},
+	})
+	conf := &config.Config{
+		Mappings: []config.ImageMapping{
+			{
+				ImageName:      "library/debian",
+				DeploymentName: "app",
+				Namespace:      "default",
+				TagPolicy:      &config.TagPolicy{Semver: "~1.4"},
+			},
+		},
+	}
+	logger := zap.NewNop()
+	r := &Router{Config: conf, Logger: logger, Pipeline: &deploy.Pipeline{Config: conf, Logger: logger, Client: client}}
+
+	r.handleWebhook(&DirectWebhook{RepositoryName: "library/debian", ImageURL: "cr.b8s.dev/library/debian:pr-123"}, "")
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1.4.0" {
+		t.Errorf("handleWebhook should have rejected the tag, image was: %s", d.Containers[0].Image)
+	}
+
+	r.handleWebhook(&DirectWebhook{RepositoryName: "library/debian", ImageURL: "cr.b8s.dev/library/debian:1.4.1"}, "")
+	d, _ = client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1.4.1" {
+		t.Errorf("handleWebhook should have deployed the tag, image was: %s", d.Containers[0].Image)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/debian:1"}},
+	})
+	conf := &config.Config{
+		AuthToken: "test1234",
+		Mappings: []config.ImageMapping{
+			{ImageName: "library/debian", DeploymentName: "app", Namespace: "default"},
+		},
+	}
+	logger := zap.NewNop()
+	pipeline := &deploy.Pipeline{
+		Config:      conf,
+		Logger:      logger,
+		Client:      client,
+		Idempotency: deploy.NewIdempotency(config.IdempotencyConfig{}, state.NewMemory(), logger),
+	}
+	engine := gin.New()
+	(&Router{Config: conf, Logger: logger, Pipeline: pipeline}).Mount(engine.Group("/webhooks/direct"))
+
+	send := func(image string) {
+		body := `{"repository_name": "library/debian", "image_url": "` + image + `"}`
+		req, _ := http.NewRequest("POST", "/webhooks/direct", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer test1234")
+		req.Header.Set("Idempotency-Key", "build-42")
+		resp := httptest.NewRecorder()
+		engine.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", resp.Code)
+		}
+	}
+	send("cr.b8s.dev/library/debian:2")
+	send("cr.b8s.dev/library/debian:3")
+
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("A request reusing an idempotency key should not deploy, image was: %s", d.Containers[0].Image)
+	}
+}
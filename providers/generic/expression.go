@@ -0,0 +1,85 @@
+package generic
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/itchyny/gojq"
+)
+
+// Expression is a compiled jq program that extracts a single value from a
+// decoded webhook body.
+type Expression struct {
+	source string
+	code   *gojq.Code
+}
+
+// CompileExpression parses and compiles a jq program. An empty source yields
+// a nil Expression, which always evaluates to nothing.
+func CompileExpression(source string) (*Expression, error) {
+	if source == "" {
+		return nil, nil
+	}
+	query, err := gojq.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+	return &Expression{source: source, code: code}, nil
+}
+
+// Eval runs the expression against the input and returns its first result.
+func (e *Expression) Eval(input interface{}) (interface{}, error) {
+	if e == nil {
+		return nil, nil
+	}
+	iter := e.code.Run(input)
+	v, ok := iter.Next()
+	if !ok {
+		return nil, nil
+	}
+	if err, ok := v.(error); ok {
+		return nil, fmt.Errorf("evaluating %q: %w", e.source, err)
+	}
+	return v, nil
+}
+
+// EvalString runs the expression and converts the result to a string. A null
+// result becomes the empty string.
+func (e *Expression) EvalString(input interface{}) (string, error) {
+	v, err := e.Eval(input)
+	if err != nil || v == nil {
+		return "", err
+	}
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case float64:
+		// Not fmt.Sprint, which would print large numbers such as numeric
+		// tags in exponent form.
+		return strconv.FormatFloat(s, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(s), nil
+	case bool:
+		return strconv.FormatBool(s), nil
+	default:
+		return "", fmt.Errorf("expression %q produced %T, expected a string", e.source, v)
+	}
+}
+
+// EvalBool runs the expression and reports whether the result is `true`. A
+// nil Expression always passes.
+func (e *Expression) EvalBool(input interface{}) (bool, error) {
+	if e == nil {
+		return true, nil
+	}
+	v, err := e.Eval(input)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	return ok && b, nil
+}
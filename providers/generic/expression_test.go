@@ -0,0 +1,61 @@
+package generic
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExpressionEvalString(t *testing.T) {
+	var body interface{}
+	json.Unmarshal([]byte(`{"repo": {"name": "library/debian", "build": 42}}`), &body)
+
+	expr, err := CompileExpression(".repo.name")
+	if err != nil {
+		t.Fatalf("Failed to compile expression: %v", err)
+	}
+	v, err := expr.EvalString(body)
+	if err != nil || v != "library/debian" {
+		t.Errorf("EvalString returned incorrect value: %v (%v)", v, err)
+	}
+
+	expr, _ = CompileExpression(`"main-\(.repo.build)"`)
+	v, _ = expr.EvalString(body)
+	if v != "main-42" {
+		t.Errorf("EvalString returned incorrect interpolated value: %v", v)
+	}
+
+	expr, _ = CompileExpression(".repo.missing")
+	v, err = expr.EvalString(body)
+	if err != nil || v != "" {
+		t.Errorf("EvalString should return empty string for null: %v (%v)", v, err)
+	}
+
+	expr, _ = CompileExpression(".repo")
+	if _, err = expr.EvalString(body); err == nil {
+		t.Errorf("EvalString should fail for non-scalar results")
+	}
+}
+
+func TestExpressionEvalBool(t *testing.T) {
+	var body interface{}
+	json.Unmarshal([]byte(`{"event": "push"}`), &body)
+
+	expr, _ := CompileExpression(`.event == "push"`)
+	if ok, _ := expr.EvalBool(body); !ok {
+		t.Errorf("EvalBool should have matched")
+	}
+	expr, _ = CompileExpression(`.event == "delete"`)
+	if ok, _ := expr.EvalBool(body); ok {
+		t.Errorf("EvalBool should not have matched")
+	}
+	expr, _ = CompileExpression("")
+	if ok, _ := expr.EvalBool(body); !ok {
+		t.Errorf("Empty expression should always pass")
+	}
+}
+
+func TestCompileExpressionInvalid(t *testing.T) {
+	if _, err := CompileExpression(".foo | ]"); err == nil {
+		t.Errorf("CompileExpression should have rejected invalid syntax")
+	}
+}
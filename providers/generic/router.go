@@ -0,0 +1,308 @@
+package generic
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.uber.org/zap"
+)
+
+// GenericWebhook holds the image details extracted from an arbitrary JSON
+// payload by a route's expressions.
+type GenericWebhook struct {
+	Repository string
+	Tag        string
+	Digest     string
+	ImageURL   string
+	Operator   string
+	ID         string
+	Labels     map[string]string
+}
+
+type Router struct {
+	Config   *config.Config
+	Logger   *zap.Logger
+	Pipeline *deploy.Pipeline
+}
+
+type route struct {
+	config.GenericRoute
+	filter     *Expression
+	repository *Expression
+	tag        *Expression
+	digest     *Expression
+	image      *Expression
+	operator   *Expression
+	id         *Expression
+	labels     map[string]*Expression
+}
+
+// Mount registers every configured generic route on the group. It fails if
+// any route has an invalid expression, so misconfiguration is caught at
+// startup rather than on the first webhook.
+func (r *Router) Mount(g *gin.RouterGroup) error {
+	paths := map[string]string{}
+	for _, rc := range r.Config.Generic.Routes {
+		path := "/" + strings.TrimPrefix(rc.Path, "/")
+		if other, ok := paths[path]; ok {
+			return fmt.Errorf("generic route %q: path %s is already used by route %q", rc.Name, path, other)
+		}
+		paths[path] = rc.Name
+		if err := validateAuth(rc.Auth, r.Config.AuthToken); err != nil {
+			return fmt.Errorf("generic route %q: %w", rc.Name, err)
+		}
+		rt, err := compileRoute(rc)
+		if err != nil {
+			return fmt.Errorf("generic route %q: %w", rc.Name, err)
+		}
+		g.POST(path, r.auth(rt), r.handle(rt))
+	}
+	return nil
+}
+
+// validateAuth checks that a route's requests can be authenticated: the
+// method is known, it has a header to read if it needs one, and there is a
+// token to compare against.
+func validateAuth(auth config.GenericAuth, globalToken string) error {
+	switch auth.Method {
+	case "none":
+		return nil
+	case "", "bearer":
+	case "header", "hmac-sha256":
+		if auth.Header == "" {
+			return fmt.Errorf("auth method %s needs a header", auth.Method)
+		}
+	default:
+		return fmt.Errorf("unknown auth method %q", auth.Method)
+	}
+	if auth.Token == "" && globalToken == "" {
+		return fmt.Errorf("auth method needs a token, or the global auth_token")
+	}
+	return nil
+}
+
+func compileRoute(rc config.GenericRoute) (*route, error) {
+	if rc.Repository == "" {
+		return nil, fmt.Errorf("a repository expression is required")
+	}
+	if rc.Image == "" && rc.Registry == "" {
+		return nil, fmt.Errorf("one of image or registry is required")
+	}
+	rt := &route{GenericRoute: rc}
+	exprs := []struct {
+		source string
+		target **Expression
+	}{
+		{rc.Filter, &rt.filter},
+		{rc.Repository, &rt.repository},
+		{rc.Tag, &rt.tag},
+		{rc.Digest, &rt.digest},
+		{rc.Image, &rt.image},
+		{rc.Operator, &rt.operator},
+		{rc.ID, &rt.id},
+	}
+	for _, e := range exprs {
+		compiled, err := CompileExpression(e.source)
+		if err != nil {
+			return nil, err
+		}
+		*e.target = compiled
+	}
+	for name, source := range rc.Labels {
+		compiled, err := CompileExpression(source)
+		if err != nil {
+			return nil, fmt.Errorf("label %q: %w", name, err)
+		}
+		if rt.labels == nil {
+			rt.labels = map[string]*Expression{}
+		}
+		rt.labels[name] = compiled
+	}
+	return rt, nil
+}
+
+func (r *Router) handle(rt *route) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body interface{}
+		raw, err := io.ReadAll(c.Request.Body)
+		if err == nil {
+			err = json.Unmarshal(raw, &body)
+		}
+		if err != nil {
+			r.Logger.Info("JSON unmarshal failure", zap.String("route", rt.Name), zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"ok": false})
+			return
+		}
+		pass, err := rt.filter.EvalBool(body)
+		if err != nil {
+			r.Logger.Info("Filter evaluation failure", zap.String("route", rt.Name), zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"ok": false})
+			return
+		}
+		if !pass {
+			r.Logger.Info("Ignoring filtered generic webhook", zap.String("route", rt.Name))
+			c.JSON(http.StatusOK, gin.H{"ok": true})
+			return
+		}
+		webhook, err := rt.extract(body)
+		if err != nil {
+			r.Logger.Info("Extraction failure", zap.String("route", rt.Name), zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"ok": false})
+			return
+		}
+		result, err := r.handleWebhook(rt, webhook)
+		if err != nil {
+			r.Logger.Info("Error while updating deployment", zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"ok": false})
+			return
+		}
+		deploy.Respond(c, result)
+	}
+}
+
+func (rt *route) extract(body interface{}) (*GenericWebhook, error) {
+	w := &GenericWebhook{}
+	var err error
+	if w.Repository, err = rt.repository.EvalString(body); err != nil {
+		return nil, err
+	}
+	if w.Repository == "" {
+		return nil, fmt.Errorf("repository expression produced no value")
+	}
+	if w.Tag, err = rt.tag.EvalString(body); err != nil {
+		return nil, err
+	}
+	if w.Digest, err = rt.digest.EvalString(body); err != nil {
+		return nil, err
+	}
+	if w.ImageURL, err = rt.image.EvalString(body); err != nil {
+		return nil, err
+	}
+	if w.ImageURL == "" {
+		if w.Tag == "" && w.Digest == "" {
+			return nil, fmt.Errorf("neither tag nor digest expression produced a value")
+		}
+		w.ImageURL = buildImageURL(rt.Registry, w.Repository, w.Tag, w.Digest)
+	}
+	if w.Operator, err = rt.operator.EvalString(body); err != nil {
+		return nil, err
+	}
+	if w.ID, err = rt.id.EvalString(body); err != nil {
+		return nil, err
+	}
+	for name, label := range rt.labels {
+		value, err := label.EvalString(body)
+		if err != nil {
+			return nil, err
+		}
+		if w.Labels == nil {
+			w.Labels = map[string]string{}
+		}
+		w.Labels[name] = value
+	}
+	return w, nil
+}
+
+// buildImageURL assembles an image reference, preferring the tag over the
+// digest so that the deployment reflects what was pushed.
+func buildImageURL(registry, repository, tag, digest string) string {
+	ref := strings.TrimSuffix(registry, "/") + "/" + repository
+	if tag != "" {
+		return ref + ":" + tag
+	}
+	return ref + "@" + digest
+}
+
+func (r *Router) handleWebhook(rt *route, w *GenericWebhook) (*deploy.Result, error) {
+	r.Logger.Info("Received generic webhook",
+		zap.String("route", rt.Name),
+		zap.String("repository_name", w.Repository))
+	return r.Pipeline.Deploy(&deploy.Event{
+		Provider:   "generic",
+		Repository: w.Repository,
+		Tag:        w.Tag,
+		Digest:     w.Digest,
+		ImageURL:   w.ImageURL,
+		Operator:   w.Operator,
+		ID:         w.ID,
+		Labels:     w.Labels,
+	})
+}
+
+func (r *Router) auth(rt *route) gin.HandlerFunc {
+	token := rt.Auth.Token
+	if token == "" {
+		token = r.Config.AuthToken
+	}
+	return func(c *gin.Context) {
+		switch rt.Auth.Method {
+		case "none":
+			return
+		case "header":
+			headerValue := c.Request.Header.Get(rt.Auth.Header)
+			if headerValue == "" {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			if !validToken(headerValue, token) {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		case "hmac-sha256":
+			headerValue := c.Request.Header.Get(rt.Auth.Header)
+			if headerValue == "" {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			if !validSignature(body, headerValue, token) {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		default:
+			headerValue := c.Request.Header.Get("Authorization")
+			if !strings.HasPrefix(headerValue, "Bearer ") {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			if !validToken(headerValue[len("Bearer "):], token) {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		}
+	}
+}
+
+// validToken compares a token in constant time, so that its value can't be
+// worked out from how long the comparison takes.
+func validToken(value string, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(value), []byte(token)) == 1
+}
+
+// validSignature checks a hex-encoded HMAC-SHA256 of the body, optionally
+// prefixed with `sha256=` as GitHub and friends do.
+func validSignature(body []byte, signature string, secret string) bool {
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
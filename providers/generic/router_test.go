@@ -0,0 +1,211 @@
+package generic
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.b8s.dev/rollingpin/config"
+)
+
+func TestAuthBearer(t *testing.T) {
+	conf := &config.Config{AuthToken: "test1234"}
+	r := &Router{Config: conf}
+	rt := &route{}
+
+	req, _ := http.NewRequest("POST", "/", bytes.NewBufferString(""))
+	req.Header.Add("authorization", "Bearer test1234")
+	ctx, resp := buildTestConn(req)
+	r.auth(rt)(ctx)
+	if resp.Code != 200 {
+		t.Errorf("Request should have been 200 but was %d!", resp.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/", bytes.NewBufferString(""))
+	req.Header.Add("authorization", "Bearer nope")
+	ctx, resp = buildTestConn(req)
+	r.auth(rt)(ctx)
+	if resp.Code != 401 {
+		t.Errorf("Request should have been 401 but was %d!", resp.Code)
+	}
+}
+
+func TestAuthHeader(t *testing.T) {
+	conf := &config.Config{AuthToken: "test1234"}
+	r := &Router{Config: conf}
+	rt := &route{GenericRoute: config.GenericRoute{
+		Auth: config.GenericAuth{Method: "header", Header: "X-Token", Token: "route-token"},
+	}}
+
+	req, _ := http.NewRequest("POST", "/", bytes.NewBufferString(""))
+	req.Header.Add("x-token", "route-token")
+	ctx, resp := buildTestConn(req)
+	r.auth(rt)(ctx)
+	if resp.Code != 200 {
+		t.Errorf("Request should have been 200 but was %d!", resp.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/", bytes.NewBufferString(""))
+	req.Header.Add("x-token", "test1234")
+	ctx, resp = buildTestConn(req)
+	r.auth(rt)(ctx)
+	if resp.Code != 401 {
+		t.Errorf("Request should have been 401 but was %d!", resp.Code)
+	}
+}
+
+func TestAuthHMAC(t *testing.T) {
+	conf := &config.Config{AuthToken: "secret"}
+	r := &Router{Config: conf}
+	rt := &route{GenericRoute: config.GenericRoute{
+		Auth: config.GenericAuth{Method: "hmac-sha256", Header: "X-Signature"},
+	}}
+	body := `{"hello":"world"}`
+	// printf '{"hello":"world"}' | openssl dgst -sha256 -hmac secret
+	signature := "sha256=2677ad3e7c090b2fa2c0fb13020d66d5420879b8316eb356a2d60fb9073bc778"
+
+	req, _ := http.NewRequest("POST", "/", bytes.NewBufferString(body))
+	req.Header.Add("x-signature", signature)
+	ctx, resp := buildTestConn(req)
+	r.auth(rt)(ctx)
+	if resp.Code != 200 {
+		t.Errorf("Request should have been 200 but was %d!", resp.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/", bytes.NewBufferString(`{"hello":"mars"}`))
+	req.Header.Add("x-signature", signature)
+	ctx, resp = buildTestConn(req)
+	r.auth(rt)(ctx)
+	if resp.Code != 401 {
+		t.Errorf("Request should have been 401 but was %d!", resp.Code)
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	valid := []config.GenericAuth{
+		{},
+		{Method: "none"},
+		{Method: "header", Header: "X-Token"},
+		{Method: "hmac-sha256", Header: "X-Signature", Token: "route-token"},
+	}
+	for _, auth := range valid {
+		if err := validateAuth(auth, "global-token"); err != nil {
+			t.Errorf("validateAuth rejected %+v: %v", auth, err)
+		}
+	}
+	invalid := []config.GenericAuth{
+		{Method: "hmac-sha256"},
+		{Method: "header"},
+		{Method: "basic"},
+	}
+	for _, auth := range invalid {
+		if err := validateAuth(auth, "global-token"); err == nil {
+			t.Errorf("validateAuth should have rejected %+v", auth)
+		}
+	}
+	if err := validateAuth(config.GenericAuth{}, ""); err == nil {
+		t.Errorf("validateAuth should require a token")
+	}
+}
+
+func TestRouteExtract(t *testing.T) {
+	rt, err := compileRoute(config.GenericRoute{
+		Repository: ".repo",
+		Digest:     ".sha",
+		Registry:   "cr.example.com/",
+	})
+	if err != nil {
+		t.Fatalf("compileRoute failed: %v", err)
+	}
+	w, err := rt.extract(map[string]interface{}{"repo": "team/app", "sha": "sha256:abc"})
+	if err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+	if w.ImageURL != "cr.example.com/team/app@sha256:abc" {
+		t.Errorf("extract built incorrect ImageURL: %s", w.ImageURL)
+	}
+
+	if _, err := rt.extract(map[string]interface{}{"repo": "team/app"}); err == nil {
+		t.Errorf("extract should fail without a tag or digest")
+	}
+}
+
+func TestRouteExtractNumbers(t *testing.T) {
+	rt, err := compileRoute(config.GenericRoute{
+		Repository: ".repo",
+		Tag:        ".build",
+		ID:         ".delivery",
+		Registry:   "cr.example.com",
+	})
+	if err != nil {
+		t.Fatalf("compileRoute failed: %v", err)
+	}
+	var body interface{}
+	json.Unmarshal([]byte(`{"repo": "team/app", "build": 20261018, "delivery": 1234567}`), &body)
+	w, err := rt.extract(body)
+	if err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+	if w.Tag != "20261018" || w.ImageURL != "cr.example.com/team/app:20261018" || w.ID != "1234567" {
+		t.Errorf("extract should keep numbers whole, got tag %q, image %q and ID %q", w.Tag, w.ImageURL, w.ID)
+	}
+}
+
+func TestMountDuplicatePaths(t *testing.T) {
+	route := config.GenericRoute{Repository: ".repo", Registry: "cr.example.com", Auth: config.GenericAuth{Method: "none"}}
+	first, second := route, route
+	first.Name, first.Path = "first", "ci"
+	second.Name, second.Path = "second", "/ci"
+	r := &Router{Config: &config.Config{Generic: config.GenericConfig{Routes: []config.GenericRoute{first, second}}}}
+	if err := r.Mount(gin.New().Group("/webhooks/generic")); err == nil {
+		t.Errorf("Mount should reject two routes with the same path")
+	}
+}
+
+func TestRouteExtractOperatorAndLabels(t *testing.T) {
+	rt, err := compileRoute(config.GenericRoute{
+		Repository: ".repo",
+		Tag:        ".tag",
+		Registry:   "cr.example.com",
+		Operator:   ".pusher.name",
+		ID:         ".delivery",
+		Labels:     map[string]string{"branch": ".git.branch"},
+	})
+	if err != nil {
+		t.Fatalf("compileRoute failed: %v", err)
+	}
+	w, err := rt.extract(map[string]interface{}{
+		"repo":     "team/app",
+		"tag":      "1.2.3",
+		"pusher":   map[string]interface{}{"name": "ci-bot"},
+		"git":      map[string]interface{}{"branch": "main"},
+		"delivery": "d-42",
+	})
+	if err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+	if w.Operator != "ci-bot" || w.ID != "d-42" || w.Labels["branch"] != "main" {
+		t.Errorf("extract got operator %q, ID %q and labels %v", w.Operator, w.ID, w.Labels)
+	}
+}
+
+func TestCompileRouteInvalid(t *testing.T) {
+	_, err := compileRoute(config.GenericRoute{Repository: ".repo", Registry: "cr.example.com", Filter: "]["})
+	if err == nil {
+		t.Errorf("compileRoute should have rejected an invalid filter")
+	}
+	_, err = compileRoute(config.GenericRoute{Repository: ".repo"})
+	if err == nil {
+		t.Errorf("compileRoute should require an image or registry")
+	}
+}
+
+func buildTestConn(req *http.Request) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	conn, _ := gin.CreateTestContext(w)
+	conn.Request = req
+	return conn, w
+}
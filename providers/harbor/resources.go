@@ -0,0 +1,67 @@
+package harbor
+
+import (
+	"sort"
+	"strings"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+)
+
+// selectResource picks the single artifact to deploy to a mapping out of a
+// push event, which may carry several tags for the same image (e.g. `1.4.2`,
+// `1.4` and `latest`). Resources without a resource URL, or whose tag the
+// mapping's tag policy does not admit, are discarded. The remaining
+// candidates are ranked by preferResource and the best one is returned, or
+// nil if there is nothing deployable.
+func selectResource(resources []HarborWebhookResource, m *config.ImageMapping) *HarborWebhookResource {
+	var candidates []HarborWebhookResource
+	for _, res := range resources {
+		if res.ResourceURL == "" || deploy.AdmitTag(m, res.Tag) != nil {
+			continue
+		}
+		candidates = append(candidates, res)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return preferResource(m, &candidates[i], &candidates[j])
+	})
+	return &candidates[0]
+}
+
+// preferResource reports whether a should be deployed in favour of b. A
+// resource with a digest is preferred over one without, then the tag the
+// mapping's policy ranks higher, then the more specific tag, and ties are
+// broken on the resource URL so the choice is deterministic regardless of
+// the order Harbor lists them in.
+func preferResource(m *config.ImageMapping, a, b *HarborWebhookResource) bool {
+	if (a.Digest != "") != (b.Digest != "") {
+		return a.Digest != ""
+	}
+	if c := deploy.CompareTags(m, a.Tag, b.Tag); c != 0 {
+		return c > 0
+	}
+	if sa, sb := tagSpecificity(a.Tag), tagSpecificity(b.Tag); sa != sb {
+		return sa > sb
+	}
+	return a.ResourceURL < b.ResourceURL
+}
+
+// tagSpecificity scores how precisely a tag identifies a build. Floating
+// tags like `latest` score lowest; version-like tags score one point per
+// component, so `1.4.2` outranks `1.4`.
+func tagSpecificity(tag string) int {
+	if tag == "" {
+		return 0
+	}
+	parts := strings.FieldsFunc(tag, func(r rune) bool {
+		return r == '.' || r == '-' || r == '_' || r == '+'
+	})
+	score := len(parts)
+	if first := strings.TrimPrefix(tag, "v"); first != "" && first[0] >= '0' && first[0] <= '9' {
+		score++
+	}
+	return score
+}
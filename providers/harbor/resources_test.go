@@ -0,0 +1,68 @@
+package harbor
+
+import (
+	"testing"
+
+	"go.b8s.dev/rollingpin/config"
+)
+
+func TestSelectResourceEmpty(t *testing.T) {
+	if res := selectResource(nil, &config.ImageMapping{}); res != nil {
+		t.Errorf("selectResource should return nil for no resources, got: %v", res)
+	}
+	if res := selectResource([]HarborWebhookResource{{Tag: "latest"}}, &config.ImageMapping{}); res != nil {
+		t.Errorf("selectResource should skip resources without a URL, got: %v", res)
+	}
+}
+
+func TestSelectResourceMostSpecificTag(t *testing.T) {
+	resources := []HarborWebhookResource{
+		{Digest: "sha256:abc", Tag: "latest", ResourceURL: "cr.b8s.dev/library/debian:latest"},
+		{Digest: "sha256:abc", Tag: "1.4", ResourceURL: "cr.b8s.dev/library/debian:1.4"},
+		{Digest: "sha256:abc", Tag: "1.4.2", ResourceURL: "cr.b8s.dev/library/debian:1.4.2"},
+	}
+	res := selectResource(resources, &config.ImageMapping{})
+	if res.Tag != "1.4.2" {
+		t.Errorf("selectResource chose the wrong tag: %s", res.Tag)
+	}
+}
+
+func TestSelectResourcePrefersDigest(t *testing.T) {
+	resources := []HarborWebhookResource{
+		{Tag: "1.4.2", ResourceURL: "cr.b8s.dev/library/debian:1.4.2"},
+		{Digest: "sha256:abc", Tag: "1.4", ResourceURL: "cr.b8s.dev/library/debian:1.4"},
+	}
+	res := selectResource(resources, &config.ImageMapping{})
+	if res.Tag != "1.4" {
+		t.Errorf("selectResource should prefer resources with a digest, chose: %s", res.Tag)
+	}
+}
+
+func TestSelectResourceDeterministic(t *testing.T) {
+	a := HarborWebhookResource{Digest: "sha256:abc", Tag: "blue", ResourceURL: "cr.b8s.dev/library/debian:blue"}
+	b := HarborWebhookResource{Digest: "sha256:abc", Tag: "green", ResourceURL: "cr.b8s.dev/library/debian:green"}
+	first := selectResource([]HarborWebhookResource{a, b}, &config.ImageMapping{})
+	second := selectResource([]HarborWebhookResource{b, a}, &config.ImageMapping{})
+	if first.Tag != second.Tag {
+		t.Errorf("selectResource is order-dependent: %s vs %s", first.Tag, second.Tag)
+	}
+}
+
+func TestSelectResourceTagPolicy(t *testing.T) {
+	m := &config.ImageMapping{TagPolicy: &config.TagPolicy{Semver: "~1.4"}}
+	resources := []HarborWebhookResource{
+		{Digest: "sha256:abc", Tag: "latest", ResourceURL: "cr.b8s.dev/library/debian:latest"},
+		{Digest: "sha256:abc", Tag: "1.4.2", ResourceURL: "cr.b8s.dev/library/debian:1.4.2"},
+		{Digest: "sha256:def", Tag: "1.4.10", ResourceURL: "cr.b8s.dev/library/debian:1.4.10"},
+		{Digest: "sha256:123", Tag: "1.5.0", ResourceURL: "cr.b8s.dev/library/debian:1.5.0"},
+	}
+	res := selectResource(resources, m)
+	if res == nil || res.Tag != "1.4.10" {
+		t.Errorf("selectResource should choose the highest admitted version, chose: %v", res)
+	}
+
+	m.TagPolicy.Semver = ">=2"
+	if res := selectResource(resources, m); res != nil {
+		t.Errorf("selectResource should return nil when no tags are admitted, chose: %v", res)
+	}
+}
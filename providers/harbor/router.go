@@ -1,18 +1,38 @@
 package harbor
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
 	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/notify"
+	"go.b8s.dev/rollingpin/state"
 	"go.uber.org/zap"
 )
 
 type Router struct {
-	Config *config.Config
-	Logger *zap.Logger
-	Client kube.IClient
+	Config   *config.Config
+	Logger   *zap.Logger
+	Client   kube.IClient
+	Pipeline *deploy.Pipeline
+
+	// Notifier is told about deleted in-use artifacts and quota problems. It
+	// may be nil.
+	Notifier notify.Notifier
+
+	// State, if set, holds pushes waiting on their vulnerability scan, so
+	// that any replica can deploy them when the scan completes.
+	State state.Store
+
+	scansOnce sync.Once
+	scans     *scanQueue
 }
 
 func (r *Router) Mount(g *gin.RouterGroup) {
@@ -24,32 +44,319 @@ func (r *Router) Mount(g *gin.RouterGroup) {
 			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"ok": false})
 			return
 		}
-		if webhook.EventType == "PUSH_ARTIFACT" {
-			err := r.handlePushArtifact(&webhook.EventData)
-			if err != nil {
-				r.Logger.Info("Error while updating deployment", zap.Error(err))
-				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"ok": false})
-				return
-			}
+		var result *deploy.Result
+		switch webhook.EventType {
+		case "PUSH_ARTIFACT":
+			result, err = r.handlePushArtifact(&webhook)
+		case "SCANNING_COMPLETED":
+			err = r.handleScanningCompleted(&webhook.EventData)
+		case "DELETE_ARTIFACT":
+			err = r.handleDeleteArtifact(&webhook.EventData)
+		case "REPLICATION":
+			err = r.handleReplication(&webhook.EventData)
+		case "QUOTA_EXCEED":
+			err = r.handleQuotaExceed(&webhook.EventData)
+		}
+		if err != nil {
+			r.Logger.Info("Error while updating deployment", zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"ok": false})
+			return
 		}
-		c.JSON(http.StatusOK, gin.H{"ok": true})
+		deploy.Respond(c, result)
 	})
 }
 
-func (r *Router) handlePushArtifact(w *HarborWebhookEvent) error {
+func (r *Router) handlePushArtifact(webhook *HarborWebhook) (*deploy.Result, error) {
+	w := &webhook.EventData
 	r.Logger.Info("Received Harbor webhook", zap.String("image_name", w.Repository.FullName))
-	for _, m := range r.Config.Mappings {
-		if w.Repository.FullName == m.ImageName {
-			err := r.Client.UpdateDeploymentImage(m.Namespace, m.DeploymentName, w.Resources[0].ResourceURL)
-			r.Logger.Info("Updated deployment",
+	for _, m := range r.Config.AllMappings() {
+		if w.Repository.FullName == m.ImageName && m.AcceptsProvider("harbor") {
+			res := selectResource(w.Resources, &m)
+			if res == nil {
+				r.Logger.Info("No deployable artifacts in Harbor webhook",
+					zap.String("image_name", m.ImageName),
+					zap.Int("resources", len(w.Resources)))
+				return &deploy.Result{Status: deploy.StatusSkipped, Reason: "no deployable artifacts"}, nil
+			}
+			if m.ReplicationTarget != "" {
+				r.Logger.Info("Waiting for replication before deploying",
+					zap.String("image_name", m.ImageName),
+					zap.String("replication_target", m.ReplicationTarget))
+				return &deploy.Result{Status: deploy.StatusSkipped, Reason: "deployed from " + m.ReplicationTarget + " once replicated"}, nil
+			}
+			if m.WaitForScan {
+				if err := r.deferUntilScanned(m, res, webhook); err != nil {
+					return nil, err
+				}
+				return &deploy.Result{Status: deploy.StatusQueued, Reason: "waiting for vulnerability scan"}, nil
+			}
+			return r.Pipeline.Apply(&m, resourceEvent(webhook, res, time.Time{}))
+		}
+	}
+	return &deploy.Result{Status: deploy.StatusIgnored}, nil
+}
+
+// resourceEvent converts an artifact of a Harbor push into a pipeline Event.
+// receivedAt is when a push that was held back was received.
+func resourceEvent(push *HarborWebhook, res *HarborWebhookResource, receivedAt time.Time) *deploy.Event {
+	e := &deploy.Event{
+		Provider:   "harbor",
+		Repository: push.EventData.Repository.FullName,
+		Tag:        res.Tag,
+		Digest:     res.Digest,
+		ImageURL:   res.ResourceURL,
+		Operator:   push.Operator,
+		ReceivedAt: receivedAt,
+	}
+	if push.OccurAt > 0 {
+		e.OccurAt = time.Unix(int64(push.OccurAt), 0)
+	}
+	return e
+}
+
+// deferUntilScanned holds a push in the scan queue until Harbor reports the
+// scan of its digest as completed.
+func (r *Router) deferUntilScanned(m config.ImageMapping, res *HarborWebhookResource, push *HarborWebhook) error {
+	expired, err := r.scanQueue().Add(m, *res, push)
+	r.logExpiredScans(expired)
+	if err != nil {
+		return err
+	}
+	r.Logger.Info("Deferring deployment until vulnerability scan completes",
+		zap.String("image_name", m.ImageName),
+		zap.String("deployment", m.DeploymentName),
+		zap.String("digest", res.Digest))
+	return nil
+}
+
+func (r *Router) handleScanningCompleted(w *HarborWebhookEvent) error {
+	r.Logger.Info("Received Harbor scan webhook", zap.String("image_name", w.Repository.FullName))
+	// Pushes are removed from the queue once taken, so every one of them is
+	// applied even if others fail, and the failures are returned together.
+	var failures []string
+	for i := range w.Resources {
+		res := &w.Resources[i]
+		ready, expired, err := r.scanQueue().Take(res.Digest)
+		r.logExpiredScans(expired)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", res.Digest, err))
+			continue
+		}
+		result := summariseScan(res)
+		for _, p := range ready {
+			if err := scanPermits(&p.Mapping, result); err != nil {
+				r.Logger.Warn("Vulnerability scan blocked deployment",
+					zap.String("image_name", p.Mapping.ImageName),
+					zap.String("deployment", p.Mapping.DeploymentName),
+					zap.String("digest", res.Digest),
+					zap.String("max_severity", result.MaxSeverity),
+					zap.Error(err))
+				continue
+			}
+			if _, err := r.Pipeline.Apply(&p.Mapping, resourceEvent(p.Push, &p.Resource, p.QueuedAt)); err != nil {
+				r.Logger.Warn("Error while deploying scanned artifact",
+					zap.String("image_name", p.Mapping.ImageName),
+					zap.String("deployment", p.Mapping.DeploymentName),
+					zap.String("digest", res.Digest),
+					zap.Error(err))
+				failures = append(failures, fmt.Sprintf("%s/%s: %v", p.Mapping.Namespace, p.Mapping.DeploymentName, err))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("deploying scanned artifacts: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// handleDeleteArtifact warns when an artifact deleted from Harbor is still
+// referenced by a mapped deployment, since its pods will fail to pull the
+// image the next time they are rescheduled.
+func (r *Router) handleDeleteArtifact(w *HarborWebhookEvent) error {
+	r.Logger.Info("Received Harbor delete webhook", zap.String("image_name", w.Repository.FullName))
+	for _, m := range r.Config.AllMappings() {
+		if w.Repository.FullName != m.ImageName {
+			continue
+		}
+		d, err := r.Client.GetDeployment(m.Namespace, m.DeploymentName)
+		if err != nil {
+			r.Logger.Info("Could not check deployment for deleted artifact",
+				zap.String("deployment", m.DeploymentName),
+				zap.Error(err))
+			continue
+		}
+		for _, c := range d.Containers {
+			for _, res := range w.Resources {
+				if !imageReferences(c.Image, m.ImageName, &res) {
+					continue
+				}
+				r.Logger.Warn("Deleted artifact is in use by a deployment",
+					zap.String("image_name", m.ImageName),
+					zap.String("deployment", m.DeploymentName),
+					zap.String("namespace", m.Namespace),
+					zap.String("container", c.Name),
+					zap.String("image", c.Image))
+				r.notify(&notify.Notification{
+					Level:   "warning",
+					Event:   "artifact_deleted",
+					Message: fmt.Sprintf("Image %s was deleted from Harbor but is in use by %s/%s", c.Image, m.Namespace, m.DeploymentName),
+					Fields: map[string]string{
+						"image":      c.Image,
+						"digest":     res.Digest,
+						"deployment": m.DeploymentName,
+						"namespace":  m.Namespace,
+						"container":  c.Name,
+					},
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// imageReferences reports whether a container image refers to the given
+// Harbor artifact, either by digest or by tag.
+func imageReferences(image string, repository string, res *HarborWebhookResource) bool {
+	if res.ResourceURL != "" && image == res.ResourceURL {
+		return true
+	}
+	if res.Digest != "" && strings.HasSuffix(image, "@"+res.Digest) {
+		return true
+	}
+	return res.Tag != "" && strings.HasSuffix(image, "/"+repository+":"+res.Tag)
+}
+
+// handleReplication deploys images for mappings with a ReplicationTarget once
+// Harbor reports they were successfully copied to that registry.
+func (r *Router) handleReplication(w *HarborWebhookEvent) error {
+	rep := w.Replication
+	if rep == nil {
+		return nil
+	}
+	host := registryHost(rep.DestResource.Endpoint)
+	r.Logger.Info("Received Harbor replication webhook",
+		zap.String("destination", host),
+		zap.String("job_status", rep.JobStatus))
+	if rep.JobStatus != "Success" {
+		return nil
+	}
+	namespace := rep.DestResource.Namespace
+	if namespace == "" {
+		namespace = rep.SrcResource.Namespace
+	}
+	// A replication may copy several tags of an image: each mapping deploys
+	// the one it would have picked from the push, and every mapping is
+	// applied even if others fail.
+	var failures []string
+	for _, m := range r.Config.AllMappings() {
+		if !m.AcceptsProvider("harbor") || m.ReplicationTarget == "" || registryHost(m.ReplicationTarget) != host {
+			continue
+		}
+		var replicated []HarborWebhookResource
+		for _, a := range rep.SuccessfulArtifacts {
+			name, tag := parseNameTag(a.NameTag)
+			if rep.SrcResource.Namespace+"/"+name != m.ImageName {
+				continue
+			}
+			if tag == "" {
+				r.Logger.Info("Replicated artifact has no tag, ignoring", zap.String("name_tag", a.NameTag))
+				continue
+			}
+			replicated = append(replicated, HarborWebhookResource{
+				Tag:         tag,
+				ResourceURL: host + "/" + namespace + "/" + name + ":" + tag,
+			})
+		}
+		res := selectResource(replicated, &m)
+		if res == nil {
+			continue
+		}
+		event := &deploy.Event{
+			Provider:   "harbor",
+			Repository: m.ImageName,
+			Tag:        res.Tag,
+			ImageURL:   res.ResourceURL,
+		}
+		if _, err := r.Pipeline.Apply(&m, event); err != nil {
+			r.Logger.Warn("Error while deploying replicated artifact",
 				zap.String("image_name", m.ImageName),
-				zap.String("deployment", m.DeploymentName))
-			return err
+				zap.String("deployment", m.DeploymentName),
+				zap.String("image", res.ResourceURL),
+				zap.Error(err))
+			failures = append(failures, fmt.Sprintf("%s/%s: %v", m.Namespace, m.DeploymentName, err))
 		}
 	}
+	if len(failures) > 0 {
+		return fmt.Errorf("deploying replicated artifacts: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// parseNameTag splits a replicated artifact's `name:tag`. Harbor reports
+// multi-artifact replications as `name [N item(s) in total]`, in which case
+// the tag is empty.
+func parseNameTag(nameTag string) (string, string) {
+	if i := strings.Index(nameTag, " ["); i >= 0 {
+		return nameTag[:i], ""
+	}
+	if i := strings.LastIndex(nameTag, ":"); i >= 0 {
+		return nameTag[:i], nameTag[i+1:]
+	}
+	return nameTag, ""
+}
+
+// registryHost reduces a registry endpoint such as `https://cr.b8s.dev/` to
+// the host used in image references.
+func registryHost(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return strings.TrimSuffix(endpoint, "/")
+}
+
+func (r *Router) handleQuotaExceed(w *HarborWebhookEvent) error {
+	details := w.CustomAttributes["Details"]
+	r.Logger.Warn("Harbor project quota exceeded",
+		zap.String("image_name", w.Repository.FullName),
+		zap.String("details", details))
+	r.notify(&notify.Notification{
+		Level:   "warning",
+		Event:   "quota_exceeded",
+		Message: fmt.Sprintf("Harbor quota exceeded for %s: %s", w.Repository.FullName, details),
+		Fields: map[string]string{
+			"repository": w.Repository.FullName,
+			"details":    details,
+		},
+	})
 	return nil
 }
 
+func (r *Router) notify(n *notify.Notification) {
+	if r.Notifier == nil {
+		return
+	}
+	if err := r.Notifier.Notify(n); err != nil {
+		r.Logger.Info("Failed to send notification", zap.String("event", n.Event), zap.Error(err))
+	}
+}
+
+func (r *Router) scanQueue() *scanQueue {
+	r.scansOnce.Do(func() {
+		r.scans = newScanQueue(r.Config.Harbor.ScanTimeout, r.State)
+	})
+	return r.scans
+}
+
+func (r *Router) logExpiredScans(expired []*pendingScan) {
+	for _, p := range expired {
+		r.Logger.Warn("Gave up waiting for vulnerability scan",
+			zap.String("image_name", p.Mapping.ImageName),
+			zap.String("deployment", p.Mapping.DeploymentName),
+			zap.String("digest", p.Resource.Digest),
+			zap.Time("queued_at", p.QueuedAt))
+	}
+}
+
 func (r *Router) auth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		headerValue := c.Request.Header.Get("Authorization")
@@ -8,6 +8,10 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/deploy"
+	"go.b8s.dev/rollingpin/kube"
+	"go.b8s.dev/rollingpin/notify"
+	"go.uber.org/zap"
 )
 
 func TestAuthSuccess(t *testing.T) {
@@ -38,9 +42,342 @@ func TestAuthMismatch(t *testing.T) {
 	}
 }
 
+func testPipeline(conf *config.Config, client kube.IClient) *deploy.Pipeline {
+	return &deploy.Pipeline{Config: conf, Logger: zap.NewNop(), Client: client}
+}
+
 func buildTestConn(req *http.Request) (*gin.Context, *httptest.ResponseRecorder) {
 	w := httptest.NewRecorder()
 	conn, _ := gin.CreateTestContext(w)
 	conn.Request = req
 	return conn, w
 }
+
+func TestHandlePushArtifactNoResources(t *testing.T) {
+	conf := &config.Config{
+		Mappings: []config.ImageMapping{
+			{ImageName: "library/debian", DeploymentName: "app", Namespace: "default"},
+		},
+	}
+	r := &Router{Config: conf, Logger: zap.NewNop()}
+	event := &HarborWebhookEvent{
+		Repository: HarborWebhookRepository{FullName: "library/debian"},
+	}
+	if _, err := r.handlePushArtifact(&HarborWebhook{EventData: *event}); err != nil {
+		t.Errorf("handlePushArtifact should ignore events without resources: %v", err)
+	}
+}
+
+func TestHandlePushArtifactMultipleResources(t *testing.T) {
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/debian:1.4.1"}},
+	})
+	conf := &config.Config{
+		Mappings: []config.ImageMapping{
+			{ImageName: "library/debian", DeploymentName: "app", Namespace: "default"},
+		},
+	}
+	r := &Router{Config: conf, Logger: zap.NewNop(), Client: client, Pipeline: testPipeline(conf, client)}
+	event := &HarborWebhookEvent{
+		Repository: HarborWebhookRepository{FullName: "library/debian"},
+		Resources: []HarborWebhookResource{
+			{Digest: "sha256:abc", Tag: "latest", ResourceURL: "cr.b8s.dev/library/debian:latest"},
+			{Digest: "sha256:abc", Tag: "1.4.2", ResourceURL: "cr.b8s.dev/library/debian:1.4.2"},
+			{Digest: "sha256:abc", Tag: "1.4", ResourceURL: "cr.b8s.dev/library/debian:1.4"},
+		},
+	}
+	if _, err := r.handlePushArtifact(&HarborWebhook{EventData: *event}); err != nil {
+		t.Fatalf("handlePushArtifact failed: %v", err)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1.4.2" {
+		t.Errorf("handlePushArtifact deployed the wrong artifact: %s", d.Containers[0].Image)
+	}
+}
+
+func TestHandlePushArtifactSkipsOtherProviders(t *testing.T) {
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/debian:1"}},
+	})
+	conf := &config.Config{
+		Mappings: []config.ImageMapping{
+			{ImageName: "library/debian", DeploymentName: "app", Namespace: "default", Providers: []string{"direct"}},
+		},
+	}
+	r := &Router{Config: conf, Logger: zap.NewNop(), Client: client, Pipeline: testPipeline(conf, client)}
+	event := &HarborWebhookEvent{
+		Repository: HarborWebhookRepository{FullName: "library/debian"},
+		Resources:  []HarborWebhookResource{{Digest: "sha256:abc", Tag: "2", ResourceURL: "cr.b8s.dev/library/debian:2"}},
+	}
+	result, err := r.handlePushArtifact(&HarborWebhook{EventData: *event})
+	if err != nil || result.Status != deploy.StatusIgnored {
+		t.Errorf("Mappings that don't accept harbor should ignore its pushes, got %+v, %v", result, err)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("Push deployed to a mapping that doesn't accept harbor: %s", d.Containers[0].Image)
+	}
+}
+
+func TestHandleScanningCompletedGatesDeploy(t *testing.T) {
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/debian:1"}},
+	})
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "strict",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/debian:1"}},
+	})
+	conf := &config.Config{
+		Mappings: []config.ImageMapping{
+			{
+				ImageName:         "library/debian",
+				DeploymentName:    "app",
+				Namespace:         "default",
+				WaitForScan:       true,
+				SeverityThreshold: "Critical",
+			},
+		},
+	}
+	r := &Router{Config: conf, Logger: zap.NewNop(), Client: client, Pipeline: testPipeline(conf, client)}
+	push := &HarborWebhookEvent{
+		Repository: HarborWebhookRepository{FullName: "library/debian"},
+		Resources: []HarborWebhookResource{
+			{Digest: "sha256:abc", Tag: "2", ResourceURL: "cr.b8s.dev/library/debian:2"},
+		},
+	}
+	if _, err := r.handlePushArtifact(&HarborWebhook{EventData: *push}); err != nil {
+		t.Fatalf("handlePushArtifact failed: %v", err)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("handlePushArtifact should have deferred the deploy: %s", d.Containers[0].Image)
+	}
+
+	scan := &HarborWebhookEvent{
+		Repository: HarborWebhookRepository{FullName: "library/debian"},
+		Resources: []HarborWebhookResource{
+			{
+				Digest: "sha256:abc",
+				ScanOverview: map[string]HarborScanReport{
+					"application/vnd.security.vulnerability.report; version=1.1": {
+						ScanStatus: "Success",
+						Severity:   "High",
+					},
+				},
+			},
+		},
+	}
+	if err := r.handleScanningCompleted(scan); err != nil {
+		t.Fatalf("handleScanningCompleted failed: %v", err)
+	}
+	d, _ = client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("handleScanningCompleted should have deployed: %s", d.Containers[0].Image)
+	}
+
+	conf.Mappings[0].DeploymentName = "strict"
+	conf.Mappings[0].SeverityThreshold = "High"
+	r.handlePushArtifact(&HarborWebhook{EventData: *push})
+	r.handleScanningCompleted(scan)
+	d, _ = client.GetDeployment("default", "strict")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:1" {
+		t.Errorf("handleScanningCompleted should have blocked the deploy: %s", d.Containers[0].Image)
+	}
+}
+
+func TestHandleScanningCompletedAppliesEveryPush(t *testing.T) {
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/debian:1"}},
+	})
+	missing := config.ImageMapping{ImageName: "library/debian", DeploymentName: "missing", Namespace: "default", WaitForScan: true}
+	app := config.ImageMapping{ImageName: "library/debian", DeploymentName: "app", Namespace: "default", WaitForScan: true}
+	conf := &config.Config{Mappings: []config.ImageMapping{missing, app}}
+	r := &Router{Config: conf, Logger: zap.NewNop(), Client: client, Pipeline: testPipeline(conf, client)}
+	res := HarborWebhookResource{Digest: "sha256:abc", Tag: "2", ResourceURL: "cr.b8s.dev/library/debian:2"}
+	push := &HarborWebhook{EventData: HarborWebhookEvent{Repository: HarborWebhookRepository{FullName: "library/debian"}}}
+	for _, m := range []config.ImageMapping{missing, app} {
+		if err := r.deferUntilScanned(m, &res, push); err != nil {
+			t.Fatalf("deferUntilScanned failed: %v", err)
+		}
+	}
+
+	scan := &HarborWebhookEvent{
+		Repository: HarborWebhookRepository{FullName: "library/debian"},
+		Resources: []HarborWebhookResource{{
+			Digest: "sha256:abc",
+			ScanOverview: map[string]HarborScanReport{
+				"application/vnd.security.vulnerability.report; version=1.1": {ScanStatus: "Success", Severity: "Low"},
+			},
+		}},
+	}
+	if err := r.handleScanningCompleted(scan); err == nil {
+		t.Errorf("handleScanningCompleted should report the push it couldn't apply")
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "cr.b8s.dev/library/debian:2" {
+		t.Errorf("A failed push shouldn't stop the others from deploying: %s", d.Containers[0].Image)
+	}
+}
+
+type recordingNotifier struct {
+	notifications []*notify.Notification
+}
+
+func (n *recordingNotifier) Notify(notification *notify.Notification) error {
+	n.notifications = append(n.notifications, notification)
+	return nil
+}
+
+func TestHandleDeleteArtifactInUse(t *testing.T) {
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: "cr.b8s.dev/library/debian:1.4.2"}},
+	})
+	conf := &config.Config{
+		Mappings: []config.ImageMapping{
+			{ImageName: "library/debian", DeploymentName: "app", Namespace: "default"},
+		},
+	}
+	notifier := &recordingNotifier{}
+	r := &Router{Config: conf, Logger: zap.NewNop(), Client: client, Pipeline: testPipeline(conf, client), Notifier: notifier}
+
+	unused := &HarborWebhookEvent{
+		Repository: HarborWebhookRepository{FullName: "library/debian"},
+		Resources:  []HarborWebhookResource{{Digest: "sha256:abc", Tag: "1.4.1"}},
+	}
+	r.handleDeleteArtifact(unused)
+	if len(notifier.notifications) != 0 {
+		t.Errorf("Deleting an unused artifact should not notify: %v", notifier.notifications)
+	}
+
+	inUse := &HarborWebhookEvent{
+		Repository: HarborWebhookRepository{FullName: "library/debian"},
+		Resources:  []HarborWebhookResource{{Digest: "sha256:def", Tag: "1.4.2"}},
+	}
+	r.handleDeleteArtifact(inUse)
+	if len(notifier.notifications) != 1 || notifier.notifications[0].Event != "artifact_deleted" {
+		t.Errorf("Deleting an in-use artifact should notify: %v", notifier.notifications)
+	}
+}
+
+func TestHandleReplication(t *testing.T) {
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "default",
+		Containers: []*kube.Container{{Name: "app", Image: "mirror.b8s.dev/prod/debian:1"}},
+	})
+	conf := &config.Config{
+		Mappings: []config.ImageMapping{
+			{
+				ImageName:         "library/debian",
+				DeploymentName:    "app",
+				Namespace:         "default",
+				ReplicationTarget: "mirror.b8s.dev",
+			},
+		},
+	}
+	r := &Router{Config: conf, Logger: zap.NewNop(), Client: client, Pipeline: testPipeline(conf, client)}
+
+	push := &HarborWebhookEvent{
+		Repository: HarborWebhookRepository{FullName: "library/debian"},
+		Resources:  []HarborWebhookResource{{Digest: "sha256:abc", Tag: "2", ResourceURL: "cr.b8s.dev/library/debian:2"}},
+	}
+	result, _ := r.handlePushArtifact(&HarborWebhook{EventData: *push})
+	if result == nil || result.Status != deploy.StatusSkipped {
+		t.Errorf("Pushes waiting for replication should be skipped, got %+v", result)
+	}
+	d, _ := client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "mirror.b8s.dev/prod/debian:1" {
+		t.Errorf("Pushes should wait for replication, image was: %s", d.Containers[0].Image)
+	}
+
+	replication := &HarborWebhookEvent{
+		Replication: &HarborReplication{
+			JobStatus:    "Success",
+			SrcResource:  HarborReplicationResource{Namespace: "library"},
+			DestResource: HarborReplicationResource{Endpoint: "https://mirror.b8s.dev", Namespace: "prod"},
+			SuccessfulArtifacts: []HarborReplicationArtifact{
+				{Type: "image", Status: "Success", NameTag: "debian:2"},
+			},
+		},
+	}
+	if err := r.handleReplication(replication); err != nil {
+		t.Fatalf("handleReplication failed: %v", err)
+	}
+	d, _ = client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "mirror.b8s.dev/prod/debian:2" {
+		t.Errorf("handleReplication did not deploy the replicated image: %s", d.Containers[0].Image)
+	}
+
+	// Of several replicated tags, the one the push would have picked is
+	// deployed.
+	replication.Replication.SuccessfulArtifacts = []HarborReplicationArtifact{
+		{Type: "image", Status: "Success", NameTag: "debian:3"},
+		{Type: "image", Status: "Success", NameTag: "debian:latest"},
+		{Type: "image", Status: "Success", NameTag: "debian:2.1"},
+		{Type: "image", Status: "Success", NameTag: "other:4"},
+	}
+	conf.Mappings[0].TagPolicy = &config.TagPolicy{Semver: ">=2"}
+	if err := r.handleReplication(replication); err != nil {
+		t.Fatalf("handleReplication failed: %v", err)
+	}
+	d, _ = client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "mirror.b8s.dev/prod/debian:3" {
+		t.Errorf("handleReplication deployed the wrong tag: %s", d.Containers[0].Image)
+	}
+
+	// A mapping that fails does not stop the others from deploying.
+	conf.Mappings = append([]config.ImageMapping{{
+		ImageName:         "library/debian",
+		DeploymentName:    "missing",
+		Namespace:         "default",
+		ReplicationTarget: "mirror.b8s.dev",
+	}}, conf.Mappings...)
+	replication.Replication.SuccessfulArtifacts = []HarborReplicationArtifact{
+		{Type: "image", Status: "Success", NameTag: "debian:4"},
+	}
+	if err := r.handleReplication(replication); err == nil {
+		t.Errorf("handleReplication should report the failed mapping")
+	}
+	d, _ = client.GetDeployment("default", "app")
+	if d.Containers[0].Image != "mirror.b8s.dev/prod/debian:4" {
+		t.Errorf("handleReplication stopped at the failed mapping: %s", d.Containers[0].Image)
+	}
+}
+
+func TestParseNameTag(t *testing.T) {
+	if name, tag := parseNameTag("debian:2"); name != "debian" || tag != "2" {
+		t.Errorf("parseNameTag parsed incorrectly: %s, %s", name, tag)
+	}
+	if name, tag := parseNameTag("debian [2 item(s) in total]"); name != "debian" || tag != "" {
+		t.Errorf("parseNameTag parsed incorrectly: %s, %s", name, tag)
+	}
+}
+
+func TestHandleQuotaExceed(t *testing.T) {
+	notifier := &recordingNotifier{}
+	r := &Router{Config: &config.Config{}, Logger: zap.NewNop(), Notifier: notifier}
+	r.handleQuotaExceed(&HarborWebhookEvent{
+		Repository:       HarborWebhookRepository{FullName: "library/debian"},
+		CustomAttributes: map[string]string{"Details": "storage quota exceeded"},
+	})
+	if len(notifier.notifications) != 1 || notifier.notifications[0].Event != "quota_exceeded" {
+		t.Errorf("handleQuotaExceed should notify: %v", notifier.notifications)
+	}
+}
@@ -0,0 +1,236 @@
+package harbor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/state"
+)
+
+const defaultScanTimeout = 30 * time.Minute
+
+// severities lists Harbor's vulnerability severities from least to most
+// severe. "None" is reported when a scan found nothing at all.
+var severities = []string{"None", "Unknown", "Negligible", "Low", "Medium", "High", "Critical"}
+
+// severityRank returns the position of a severity in severities, or -1 if it
+// is not a severity Harbor reports.
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// scanResult summarises the scan reports attached to a resource.
+type scanResult struct {
+	Succeeded   bool
+	MaxSeverity string
+}
+
+// summariseScan reduces all the reports in a resource's scan overview to the
+// single most severe finding. A scan only counts as succeeded if every report
+// did.
+func summariseScan(res *HarborWebhookResource) scanResult {
+	result := scanResult{Succeeded: len(res.ScanOverview) > 0, MaxSeverity: "None"}
+	raise := func(severity string) {
+		if severityRank(severity) > severityRank(result.MaxSeverity) {
+			result.MaxSeverity = severity
+		}
+	}
+	for _, report := range res.ScanOverview {
+		if report.ScanStatus != "Success" {
+			result.Succeeded = false
+		}
+		raise(report.Severity)
+		for severity, count := range report.Summary.Summary {
+			if count > 0 {
+				raise(severity)
+			}
+		}
+	}
+	return result
+}
+
+// scanPermits reports whether a scan result is acceptable for the mapping.
+func scanPermits(m *config.ImageMapping, result scanResult) error {
+	if !result.Succeeded {
+		return fmt.Errorf("vulnerability scan did not succeed")
+	}
+	if m.SeverityThreshold == "" {
+		return nil
+	}
+	threshold := severityRank(m.SeverityThreshold)
+	if threshold < 0 {
+		return fmt.Errorf("unknown severity threshold %q", m.SeverityThreshold)
+	}
+	if severityRank(result.MaxSeverity) >= threshold {
+		return fmt.Errorf("image has %s vulnerabilities, threshold is %s",
+			result.MaxSeverity, m.SeverityThreshold)
+	}
+	return nil
+}
+
+// pendingScan is a push that is waiting on its vulnerability scan.
+type pendingScan struct {
+	Mapping  config.ImageMapping   `json:"mapping"`
+	Resource HarborWebhookResource `json:"resource"`
+	// Push is the webhook that pushed the artifact.
+	Push     *HarborWebhook `json:"push"`
+	QueuedAt time.Time      `json:"queued_at"`
+}
+
+// scanQueue holds pushes deferred until Harbor reports a completed scan,
+// keyed by digest. Entries older than the timeout are discarded. The
+// pushes are kept in a state store, so that whichever replica receives the
+// SCANNING_COMPLETED event can deploy them.
+type scanQueue struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	store   state.Store
+	now     func() time.Time
+}
+
+// newScanQueue returns a queue kept in store, or in memory if store is nil.
+func newScanQueue(timeout time.Duration, store state.Store) *scanQueue {
+	if timeout <= 0 {
+		timeout = defaultScanTimeout
+	}
+	if store == nil {
+		store = state.NewMemory()
+	}
+	return &scanQueue{
+		timeout: timeout,
+		store:   store,
+		now:     time.Now,
+	}
+}
+
+// Add defers a push until Take is called with its digest. A newer push for
+// the same mapping and digest replaces the older one. Any entries that have
+// expired in the meantime are returned.
+func (q *scanQueue) Add(m config.ImageMapping, res HarborWebhookResource, push *HarborWebhook) ([]*pendingScan, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	expired, err := q.prune()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := q.get(res.Digest)
+	if err != nil {
+		return expired, err
+	}
+	for i, p := range entries {
+		if p.Mapping.Namespace == m.Namespace && p.Mapping.DeploymentName == m.DeploymentName {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	entries = append(entries, &pendingScan{Mapping: m, Resource: res, Push: push, QueuedAt: q.now()})
+	return expired, state.PutJSON(q.store, state.BucketScans, scanKey(res.Digest), entries)
+}
+
+// Take removes and returns the pushes waiting on a digest, along with any
+// entries that expired before the scan completed.
+func (q *scanQueue) Take(digest string) (ready []*pendingScan, expired []*pendingScan, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	expired, err = q.prune()
+	if err != nil {
+		return nil, nil, err
+	}
+	ready, err = q.get(digest)
+	if err != nil || len(ready) == 0 {
+		return nil, expired, err
+	}
+	return ready, expired, q.store.Delete(state.BucketScans, scanKey(digest))
+}
+
+// Len returns the number of pushes currently waiting.
+func (q *scanQueue) Len() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	all, err := q.all()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, entries := range all {
+		n += len(entries)
+	}
+	return n, nil
+}
+
+// prune removes and returns the entries older than the timeout. The caller
+// must hold the lock.
+func (q *scanQueue) prune() ([]*pendingScan, error) {
+	all, err := q.all()
+	if err != nil {
+		return nil, err
+	}
+	var expired []*pendingScan
+	cutoff := q.now().Add(-q.timeout)
+	for key, entries := range all {
+		var keep []*pendingScan
+		for _, p := range entries {
+			if p.QueuedAt.Before(cutoff) {
+				expired = append(expired, p)
+			} else {
+				keep = append(keep, p)
+			}
+		}
+		if len(keep) == len(entries) {
+			continue
+		}
+		if len(keep) == 0 {
+			err = q.store.Delete(state.BucketScans, key)
+		} else {
+			err = state.PutJSON(q.store, state.BucketScans, key, keep)
+		}
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// get returns the entries waiting on a digest. The caller must hold the
+// lock.
+func (q *scanQueue) get(digest string) ([]*pendingScan, error) {
+	var entries []*pendingScan
+	err := state.GetJSON(q.store, state.BucketScans, scanKey(digest), &entries)
+	if errors.Is(err, state.ErrNotFound) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// all returns every waiting entry by key. The caller must hold the lock.
+func (q *scanQueue) all() (map[string][]*pendingScan, error) {
+	saved, err := q.store.List(state.BucketScans)
+	if err != nil {
+		return nil, err
+	}
+	all := map[string][]*pendingScan{}
+	for key, b := range saved {
+		var entries []*pendingScan
+		if err := json.Unmarshal(b, &entries); err != nil {
+			return nil, err
+		}
+		all[key] = entries
+	}
+	return all, nil
+}
+
+// scanKey turns a digest like `sha256:...` into a key the state store
+// accepts.
+func scanKey(digest string) string {
+	return strings.ReplaceAll(digest, ":", ".")
+}
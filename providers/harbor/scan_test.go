@@ -0,0 +1,108 @@
+package harbor
+
+import (
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/state"
+)
+
+func TestSummariseScan(t *testing.T) {
+	res := &HarborWebhookResource{
+		ScanOverview: map[string]HarborScanReport{
+			"application/vnd.security.vulnerability.report; version=1.1": {
+				ScanStatus: "Success",
+				Severity:   "Medium",
+				Summary: HarborScanSummary{
+					Total:   3,
+					Summary: map[string]int{"Low": 2, "High": 1, "Critical": 0},
+				},
+			},
+		},
+	}
+	result := summariseScan(res)
+	if !result.Succeeded {
+		t.Errorf("summariseScan should have succeeded")
+	}
+	if result.MaxSeverity != "High" {
+		t.Errorf("summariseScan computed incorrect MaxSeverity: %s", result.MaxSeverity)
+	}
+
+	if result := summariseScan(&HarborWebhookResource{}); result.Succeeded {
+		t.Errorf("summariseScan should not succeed without any reports")
+	}
+}
+
+func TestScanPermits(t *testing.T) {
+	m := &config.ImageMapping{SeverityThreshold: "Critical"}
+	if err := scanPermits(m, scanResult{Succeeded: true, MaxSeverity: "High"}); err != nil {
+		t.Errorf("High should be permitted below a Critical threshold: %v", err)
+	}
+	if err := scanPermits(m, scanResult{Succeeded: true, MaxSeverity: "Critical"}); err == nil {
+		t.Errorf("Critical should not be permitted at a Critical threshold")
+	}
+	if err := scanPermits(m, scanResult{Succeeded: false, MaxSeverity: "None"}); err == nil {
+		t.Errorf("Failed scans should not be permitted")
+	}
+	m = &config.ImageMapping{SeverityThreshold: "Dreadful"}
+	if err := scanPermits(m, scanResult{Succeeded: true, MaxSeverity: "None"}); err == nil {
+		t.Errorf("Unknown thresholds should not be permitted")
+	}
+}
+
+func TestScanQueueExpiry(t *testing.T) {
+	now := time.Unix(1586922308, 0)
+	q := newScanQueue(time.Minute, nil)
+	q.now = func() time.Time { return now }
+
+	m := config.ImageMapping{DeploymentName: "app", Namespace: "default"}
+	q.Add(m, HarborWebhookResource{Digest: "sha256:old"}, &HarborWebhook{})
+	q.Add(m, HarborWebhookResource{Digest: "sha256:new"}, &HarborWebhook{})
+	q.Add(m, HarborWebhookResource{Digest: "sha256:new", Tag: "replaced"}, &HarborWebhook{})
+	if n, _ := q.Len(); n != 2 {
+		t.Errorf("scanQueue should replace pushes for the same mapping and digest, has %d", n)
+	}
+
+	now = now.Add(2 * time.Minute)
+	q.Add(m, HarborWebhookResource{Digest: "sha256:newest"}, &HarborWebhook{})
+
+	ready, expired, err := q.Take("sha256:new")
+	if err != nil {
+		t.Fatalf("Take failed: %v", err)
+	}
+	if len(ready) != 0 {
+		t.Errorf("Take should not return expired entries: %v", ready)
+	}
+	if len(expired) != 0 {
+		t.Errorf("Expired entries should already have been pruned by Add: %v", expired)
+	}
+	ready, _, _ = q.Take("sha256:newest")
+	if len(ready) != 1 {
+		t.Errorf("Take should return the pending entry, got %d", len(ready))
+	}
+	if n, _ := q.Len(); n != 0 {
+		t.Errorf("scanQueue should be empty, has %d", n)
+	}
+}
+
+func TestScanQueueShared(t *testing.T) {
+	store := state.NewMemory()
+	a := newScanQueue(time.Minute, store)
+	b := newScanQueue(time.Minute, store)
+
+	m := config.ImageMapping{DeploymentName: "app", Namespace: "default"}
+	if _, err := a.Add(m, HarborWebhookResource{Digest: "sha256:abc", Tag: "2"}, &HarborWebhook{Operator: "alice"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	ready, _, err := b.Take("sha256:abc")
+	if err != nil {
+		t.Fatalf("Take failed: %v", err)
+	}
+	if len(ready) != 1 || ready[0].Resource.Tag != "2" || ready[0].Push.Operator != "alice" || ready[0].Mapping.DeploymentName != "app" {
+		t.Errorf("Another replica should take the push, got %+v", ready)
+	}
+	if n, _ := a.Len(); n != 0 {
+		t.Errorf("Taken push should be gone from every replica, %d left", n)
+	}
+}
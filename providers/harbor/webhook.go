@@ -10,6 +10,13 @@ type HarborWebhook struct {
 type HarborWebhookEvent struct {
 	Resources  []HarborWebhookResource `json:"resources"`
 	Repository HarborWebhookRepository `json:"repository"`
+
+	// Replication is only present on REPLICATION events.
+	Replication *HarborReplication `json:"replication"`
+
+	// CustomAttributes carries extra detail on some events, such as the
+	// reason for a QUOTA_EXCEED.
+	CustomAttributes map[string]string `json:"custom_attributes"`
 }
 
 type HarborWebhookRepository struct {
@@ -24,4 +31,46 @@ type HarborWebhookResource struct {
 	Digest      string `json:"digest"`
 	Tag         string `json:"tag"`
 	ResourceURL string `json:"resource_url"`
+
+	// ScanOverview is only present on SCANNING_* events, keyed by the report
+	// MIME type.
+	ScanOverview map[string]HarborScanReport `json:"scan_overview"`
+}
+
+type HarborScanReport struct {
+	ReportID   string            `json:"report_id"`
+	ScanStatus string            `json:"scan_status"`
+	Severity   string            `json:"severity"`
+	Summary    HarborScanSummary `json:"summary"`
+}
+
+type HarborScanSummary struct {
+	Total   int            `json:"total"`
+	Fixable int            `json:"fixable"`
+	Summary map[string]int `json:"summary"`
+}
+
+type HarborReplication struct {
+	HarborHostname      string                      `json:"harbor_hostname"`
+	JobStatus           string                      `json:"job_status"`
+	ArtifactType        string                      `json:"artifact_type"`
+	TriggerType         string                      `json:"trigger_type"`
+	ExecutionTimestamp  int                         `json:"execution_timestamp"`
+	SrcResource         HarborReplicationResource   `json:"src_resource"`
+	DestResource        HarborReplicationResource   `json:"dest_resource"`
+	SuccessfulArtifacts []HarborReplicationArtifact `json:"successful_artifact"`
+	FailedArtifacts     []HarborReplicationArtifact `json:"failed_artifact"`
+}
+
+type HarborReplicationResource struct {
+	RegistryName string `json:"registry_name"`
+	RegistryType string `json:"registry_type"`
+	Endpoint     string `json:"endpoint"`
+	Namespace    string `json:"namespace"`
+}
+
+type HarborReplicationArtifact struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	NameTag string `json:"name_tag"`
 }
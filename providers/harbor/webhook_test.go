@@ -44,3 +44,78 @@ func TestUnmarshalHarborWebhook(t *testing.T) {
 		)
 	}
 }
+
+func TestUnmarshalHarborScanWebhook(t *testing.T) {
+	payload := `{
+		"type": "SCANNING_COMPLETED",
+		"occur_at": 1586922308,
+		"operator": "auto",
+		"event_data": {
+			"resources": [{
+				"digest": "sha256:8a9e9863dbb6e10edb5adfe917c00da84e1700fa76e7ed02476aa6e6fb8ee0d8",
+				"tag": "latest",
+				"resource_url": "hub.harbor.com/test-webhook/debian:latest",
+				"scan_overview": {
+					"application/vnd.security.vulnerability.report; version=1.1": {
+						"report_id": "d5b0ea2b-8b3c-4a94-a1cb-0bbd3c2b7e0b",
+						"scan_status": "Success",
+						"severity": "High",
+						"summary": {"total": 4, "fixable": 1, "summary": {"High": 1, "Low": 3}}
+					}
+				}
+			}],
+			"repository": {
+				"name": "debian",
+				"namespace": "test-webhook",
+				"repo_full_name": "test-webhook/debian",
+				"repo_type": "private"
+			}
+		}
+	}`
+	var webhook HarborWebhook
+	err := json.Unmarshal([]byte(payload), &webhook)
+	if err != nil {
+		t.Errorf("Failed to unmarshal HarborWebhook: %v", err)
+		return
+	}
+	report := webhook.EventData.Resources[0].ScanOverview["application/vnd.security.vulnerability.report; version=1.1"]
+	if report.Severity != "High" {
+		t.Errorf("HarborScanReport had incorrect Severity: %v", report.Severity)
+	}
+	if report.Summary.Summary["Low"] != 3 {
+		t.Errorf("HarborScanSummary had incorrect counts: %v", report.Summary.Summary)
+	}
+}
+
+func TestUnmarshalHarborReplicationWebhook(t *testing.T) {
+	payload := `{
+		"type": "REPLICATION",
+		"occur_at": 1586922308,
+		"operator": "MANUAL",
+		"event_data": {
+			"replication": {
+				"harbor_hostname": "hub.harbor.com",
+				"job_status": "Success",
+				"artifact_type": "image",
+				"trigger_type": "MANUAL",
+				"execution_timestamp": 1586922308,
+				"src_resource": {"registry_type": "harbor", "endpoint": "https://hub.harbor.com", "namespace": "library"},
+				"dest_resource": {"registry_name": "mirror", "registry_type": "harbor", "endpoint": "https://mirror.example.com", "namespace": "prod"},
+				"successful_artifact": [{"type": "image", "status": "Success", "name_tag": "debian:latest"}]
+			}
+		}
+	}`
+	var webhook HarborWebhook
+	err := json.Unmarshal([]byte(payload), &webhook)
+	if err != nil {
+		t.Errorf("Failed to unmarshal HarborWebhook: %v", err)
+		return
+	}
+	rep := webhook.EventData.Replication
+	if rep.DestResource.Endpoint != "https://mirror.example.com" {
+		t.Errorf("HarborReplication had incorrect DestResource: %v", rep.DestResource)
+	}
+	if rep.SuccessfulArtifacts[0].NameTag != "debian:latest" {
+		t.Errorf("HarborReplication had incorrect SuccessfulArtifacts: %v", rep.SuccessfulArtifacts)
+	}
+}
@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+// authorize fetches a bearer token for a repository from the realm named in
+// the registry's `WWW-Authenticate` challenge, as described by the Docker
+// token authentication spec.
+func (c *Client) authorize(repository string, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "Bearer") || params["realm"] == "" {
+		return fmt.Errorf("registry requires unsupported authentication %q", challenge)
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return err
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching registry token: %s", resp.Status)
+	}
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return err
+	}
+	token := tr.Token
+	if token == "" {
+		token = tr.AccessToken
+	}
+	if token == "" {
+		return fmt.Errorf("fetching registry token: response contained no token")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens == nil {
+		c.tokens = map[string]string{}
+	}
+	c.tokens[repository] = token
+	return nil
+}
+
+func (c *Client) token(repository string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens[repository]
+}
+
+// parseChallenge splits a `WWW-Authenticate` header such as
+// `Bearer realm="https://auth.example.com/token",service="registry"` into its
+// scheme and parameters.
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	scheme, rest, _ := cut(strings.TrimSpace(header), " ")
+	for rest != "" {
+		var pair string
+		pair, rest = nextParam(rest)
+		key, value, ok := cut(pair, "=")
+		if !ok {
+			continue
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return scheme, params
+}
+
+// nextParam returns the first comma-separated parameter in s, respecting
+// quoted values that may themselves contain commas.
+func nextParam(s string) (string, string) {
+	inQuotes := false
+	for i, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == ',' && !inQuotes:
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+func cut(s string, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
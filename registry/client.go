@@ -0,0 +1,176 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestMediaTypes are the manifest formats we are willing to resolve a
+// digest for. Index types come first so multi-arch images resolve to the
+// index digest rather than an arbitrary platform's manifest.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Client talks to a registry using the OCI distribution API.
+type Client struct {
+	// BaseURL is the registry's root, e.g. `https://registry.example.com`.
+	BaseURL  string
+	Username string
+	Password string
+	HTTP     *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// New creates a Client for a registry. The registry may be given as a bare
+// host, in which case HTTPS is assumed, or as a full URL.
+func New(registry string, username string, password string) *Client {
+	base := strings.TrimSuffix(registry, "/")
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	return &Client{
+		BaseURL:  base,
+		Username: username,
+		Password: password,
+		HTTP:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Host returns the registry host as it appears in image references.
+func (c *Client) Host() string {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return c.BaseURL
+	}
+	return u.Host
+}
+
+type tagList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// ListTags returns every tag in a repository, following the registry's
+// `Link` headers if it splits the list into pages.
+func (c *Client) ListTags(repository string) ([]string, error) {
+	var tags []string
+	path := "/v2/" + repository + "/tags/list"
+	for path != "" {
+		page, next, err := c.listTagsPage(repository, path)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page...)
+		if next == path {
+			return nil, fmt.Errorf("listing tags for %s: registry returned the same page twice", repository)
+		}
+		path = next
+	}
+	return tags, nil
+}
+
+// listTagsPage fetches one page of a repository's tags, returning the path
+// of the next page, or "" if it was the last.
+func (c *Client) listTagsPage(repository string, path string) ([]string, string, error) {
+	resp, err := c.do("GET", path, repository, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("listing tags for %s: registry returned %s", repository, resp.Status)
+	}
+	var list tagList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", err
+	}
+	next, err := nextLink(resp.Header.Get("Link"))
+	if err != nil {
+		return nil, "", fmt.Errorf("listing tags for %s: %w", repository, err)
+	}
+	return list.Tags, next, nil
+}
+
+// nextLink returns the path and query of the `rel="next"` link in a Link
+// header, such as `</v2/app/tags/list?n=100&last=1.4.2>; rel="next"`, or ""
+// if there is none.
+func nextLink(header string) (string, error) {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) != `rel="next"` {
+				continue
+			}
+			u, err := url.Parse(strings.Trim(target, "<>"))
+			if err != nil {
+				return "", fmt.Errorf("invalid next link %q: %w", target, err)
+			}
+			return u.RequestURI(), nil
+		}
+	}
+	return "", nil
+}
+
+// ResolveDigest returns the manifest digest a tag currently points to.
+func (c *Client) ResolveDigest(repository string, tag string) (string, error) {
+	header := http.Header{"Accept": []string{strings.Join(manifestMediaTypes, ", ")}}
+	resp, err := c.do("HEAD", "/v2/"+repository+"/manifests/"+tag, repository, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("resolving %s:%s: registry returned %s", repository, tag, resp.Status)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("resolving %s:%s: registry did not return a digest", repository, tag)
+	}
+	return digest, nil
+}
+
+// do performs a request, transparently completing the bearer token dance if
+// the registry challenges us for one.
+func (c *Client) do(method string, path string, repository string, header http.Header) (*http.Response, error) {
+	resp, err := c.send(method, path, repository, header)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if err := c.authorize(repository, challenge); err != nil {
+		return nil, err
+	}
+	return c.send(method, path, repository, header)
+}
+
+func (c *Client) send(method string, path string, repository string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.BaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if token := c.token(repository); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	return c.HTTP.Do(req)
+}
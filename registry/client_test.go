@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+
+	"go.b8s.dev/rollingpin/registry/registrytest"
+)
+
+func TestClientListTags(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	fake.Push("vendor/app", "1.0.0", "sha256:aaa")
+	fake.Push("vendor/app", "1.1.0", "sha256:bbb")
+
+	c := New(fake.URL, "", "")
+	tags, err := c.ListTags("vendor/app")
+	if err != nil {
+		t.Fatalf("ListTags failed: %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"1.0.0", "1.1.0"}) {
+		t.Errorf("ListTags returned incorrect tags: %v", tags)
+	}
+
+	if _, err := c.ListTags("vendor/missing"); err == nil {
+		t.Errorf("ListTags should fail for a missing repository")
+	}
+}
+
+func TestClientListTagsPaginated(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	fake.PageSize = 2
+	for _, tag := range []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0", "1.4.0"} {
+		fake.Push("vendor/app", tag, "sha256:"+tag)
+	}
+
+	tags, err := New(fake.URL, "", "").ListTags("vendor/app")
+	if err != nil {
+		t.Fatalf("ListTags failed: %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0", "1.4.0"}) {
+		t.Errorf("ListTags should follow every page, got: %v", tags)
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	next, err := nextLink(`<https://registry.example.com/v2/app/tags/list?n=2&last=b>; rel="next"`)
+	if err != nil || next != "/v2/app/tags/list?n=2&last=b" {
+		t.Errorf("nextLink returned incorrect path: %q, %v", next, err)
+	}
+	if next, _ := nextLink(`</v2/app/tags/list?n=2&last=a>; rel="prev"`); next != "" {
+		t.Errorf("nextLink should ignore other relations, got: %q", next)
+	}
+}
+
+func TestClientResolveDigestWithTokenAuth(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	fake.Username = "robot"
+	fake.Password = "hunter2"
+	fake.Push("vendor/app", "stable", "sha256:ccc")
+
+	c := New(fake.URL, "robot", "hunter2")
+	digest, err := c.ResolveDigest("vendor/app", "stable")
+	if err != nil {
+		t.Fatalf("ResolveDigest failed: %v", err)
+	}
+	if digest != "sha256:ccc" {
+		t.Errorf("ResolveDigest returned incorrect digest: %s", digest)
+	}
+
+	c = New(fake.URL, "robot", "wrong")
+	if _, err := c.ResolveDigest("vendor/app", "stable"); err == nil {
+		t.Errorf("ResolveDigest should fail with bad credentials")
+	}
+}
+
+func TestClientHost(t *testing.T) {
+	if host := New("registry.example.com/", "", "").Host(); host != "registry.example.com" {
+		t.Errorf("Host returned incorrect host: %s", host)
+	}
+	if host := New("http://127.0.0.1:5000", "", "").Host(); host != "127.0.0.1:5000" {
+		t.Errorf("Host returned incorrect host: %s", host)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry",scope="repository:a/b:pull,push"`)
+	if scheme != "Bearer" {
+		t.Errorf("parseChallenge returned incorrect scheme: %s", scheme)
+	}
+	if params["realm"] != "https://auth.example.com/token" || params["scope"] != "repository:a/b:pull,push" {
+		t.Errorf("parseChallenge returned incorrect params: %v", params)
+	}
+}
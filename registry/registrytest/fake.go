@@ -0,0 +1,131 @@
+// Package registrytest provides an in-process OCI distribution registry for
+// tests.
+package registrytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry is a fake registry serving tag lists and manifest digests. If
+// Username is set it requires bearer token authentication, issuing tokens
+// from its own `/token` endpoint.
+type Registry struct {
+	*httptest.Server
+
+	Username string
+	Password string
+
+	// PageSize, if set, splits tag lists into pages of this many tags,
+	// linked by `Link` headers, unless the request asks for its own size.
+	PageSize int
+
+	mu    sync.Mutex
+	repos map[string]map[string]string
+}
+
+const fakeToken = "fake-registry-token"
+
+// New starts a fake registry. Callers must Close it.
+func New() *Registry {
+	r := &Registry{repos: map[string]map[string]string{}}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+// Push points a tag at a digest, creating the repository if needed.
+func (r *Registry) Push(repository string, tag string, digest string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.repos[repository] == nil {
+		r.repos[repository] = map[string]string{}
+	}
+	r.repos[repository][tag] = digest
+}
+
+// Host returns the `host:port` the registry is listening on.
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != r.Username || pass != r.Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": fakeToken})
+		return
+	}
+	if !strings.HasPrefix(req.URL.Path, "/v2/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Username != "" && req.Header.Get("Authorization") != "Bearer "+fakeToken {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.URL+`/token",service="registrytest"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case strings.HasSuffix(path, "/tags/list"):
+		name := strings.TrimSuffix(path, "/tags/list")
+		tags, ok := r.repos[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		list := []string{}
+		for tag := range tags {
+			list = append(list, tag)
+		}
+		sort.Strings(list)
+		list = r.page(w, req, list)
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "tags": list})
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		name, ref := path[:i], path[i+len("/manifests/"):]
+		digest, ok := r.repos[name][ref]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// page trims a sorted tag list to the page a request asks for with its `n`
+// and `last` parameters, setting a Link header to the next page if there is
+// one.
+func (r *Registry) page(w http.ResponseWriter, req *http.Request, list []string) []string {
+	size := r.PageSize
+	if n, err := strconv.Atoi(req.URL.Query().Get("n")); err == nil {
+		size = n
+	}
+	if last := req.URL.Query().Get("last"); last != "" {
+		list = list[sort.SearchStrings(list, last):]
+		if len(list) > 0 && list[0] == last {
+			list = list[1:]
+		}
+	}
+	if size <= 0 || len(list) <= size {
+		return list
+	}
+	list = list[:size]
+	next := url.Values{"n": {strconv.Itoa(size)}, "last": {list[size-1]}}
+	w.Header().Set("Link", "<"+req.URL.Path+"?"+next.Encode()+`>; rel="next"`)
+	return list
+}
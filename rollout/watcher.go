@@ -0,0 +1,171 @@
+// Package rollout watches deployments after they are updated and reports
+// whether the new pods rolled out successfully.
+package rollout
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.b8s.dev/rollingpin/kube"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultInterval = 5 * time.Second
+	defaultTimeout  = 10 * time.Minute
+)
+
+// Target is a deployment that has just been updated to Image.
+type Target struct {
+	Namespace  string
+	Deployment string
+
+	// Container is the container that was updated. Defaults to the first.
+	Container string
+
+	Image  string
+	Tag    string
+	Digest string
+
+	// PreviousImage is what the deployment ran before the update.
+	PreviousImage string
+
+	// Rollback is set when the update put the deployment back on an earlier
+	// image.
+	Rollback bool
+}
+
+func (t *Target) key() string {
+	return t.Namespace + "/" + t.Deployment
+}
+
+// Result is the outcome of watching a Target.
+type Result struct {
+	Target
+	Succeeded  bool
+	Reason     string
+	FinishedAt time.Time
+}
+
+// Watcher polls deployments until their rollout completes, fails, or times
+// out, then passes the Result to every listener.
+type Watcher struct {
+	Client kube.IClient
+	Logger *zap.Logger
+
+	// Interval is how often the deployment status is checked.
+	Interval time.Duration
+	// Timeout is how long a rollout may take before it counts as failed.
+	Timeout time.Duration
+
+	mu        sync.Mutex
+	listeners []func(*Result)
+	active    map[string]context.CancelFunc
+}
+
+// OnResult registers a function to be called with the result of every
+// rollout the watcher tracks.
+func (w *Watcher) OnResult(f func(*Result)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listeners = append(w.listeners, f)
+}
+
+// Track watches the target in the background. A newer Track for the same
+// deployment supersedes an earlier one, which stops without a result.
+func (w *Watcher) Track(t Target) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.mu.Lock()
+	if w.active == nil {
+		w.active = map[string]context.CancelFunc{}
+	}
+	if previous, ok := w.active[t.key()]; ok {
+		previous()
+	}
+	w.active[t.key()] = cancel
+	w.mu.Unlock()
+
+	go func() {
+		result := w.Wait(ctx, t)
+		w.mu.Lock()
+		superseded := ctx.Err() != nil
+		if !superseded {
+			delete(w.active, t.key())
+		}
+		w.mu.Unlock()
+		cancel()
+		if !superseded {
+			w.publish(result)
+		}
+	}()
+}
+
+// Wait blocks until the target's rollout finishes and returns its result.
+func (w *Watcher) Wait(ctx context.Context, t Target) *Result {
+	interval, timeout := w.Interval, w.Timeout
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if result := w.check(t); result != nil {
+			return result
+		}
+		select {
+		case <-ctx.Done():
+			return &Result{Target: t, Reason: "watch cancelled", FinishedAt: time.Now()}
+		case <-deadline.C:
+			return &Result{Target: t, Reason: "timed out after " + timeout.String(), FinishedAt: time.Now()}
+		case <-ticker.C:
+		}
+	}
+}
+
+// check returns a result if the rollout has finished, or nil if it is still
+// in progress.
+func (w *Watcher) check(t Target) *Result {
+	d, err := w.Client.GetDeployment(t.Namespace, t.Deployment)
+	if err != nil {
+		w.Logger.Info("Error while checking rollout",
+			zap.String("namespace", t.Namespace),
+			zap.String("deployment", t.Deployment),
+			zap.Error(err))
+		return nil
+	}
+	if image := d.Image(t.Container); image != "" && image != t.Image {
+		return &Result{Target: t, Reason: "deployment was changed to " + image, FinishedAt: time.Now()}
+	}
+	if d.Status == nil {
+		return nil
+	}
+	if d.Status.Failed {
+		return &Result{Target: t, Reason: d.Status.Message, FinishedAt: time.Now()}
+	}
+	if d.Status.Complete() {
+		return &Result{Target: t, Succeeded: true, FinishedAt: time.Now()}
+	}
+	return nil
+}
+
+func (w *Watcher) publish(r *Result) {
+	w.Logger.Info("Rollout finished",
+		zap.String("namespace", r.Namespace),
+		zap.String("deployment", r.Deployment),
+		zap.String("image", r.Image),
+		zap.Bool("succeeded", r.Succeeded),
+		zap.String("reason", r.Reason))
+	w.mu.Lock()
+	listeners := append([]func(*Result){}, w.listeners...)
+	w.mu.Unlock()
+	for _, f := range listeners {
+		f(r)
+	}
+}
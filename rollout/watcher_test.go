@@ -0,0 +1,68 @@
+package rollout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.b8s.dev/rollingpin/kube"
+	"go.uber.org/zap"
+)
+
+func testWatcher(t *testing.T, image string) (*Watcher, *kube.Client) {
+	client, _ := kube.NewFake()
+	client.CreateDeployment(&kube.Deployment{
+		Name:       "app",
+		Namespace:  "staging",
+		Containers: []*kube.Container{{Name: "app", Image: image}},
+		Status:     &kube.RolloutStatus{Replicas: 2, UpdatedReplicas: 1, AvailableReplicas: 1},
+	})
+	return &Watcher{Client: client, Logger: zap.NewNop(), Interval: 5 * time.Millisecond, Timeout: time.Second}, client
+}
+
+func TestWatcherSucceeds(t *testing.T) {
+	w, client := testWatcher(t, "cr.b8s.dev/app:2")
+	results := make(chan *Result, 1)
+	w.OnResult(func(r *Result) { results <- r })
+
+	w.Track(Target{Namespace: "staging", Deployment: "app", Image: "cr.b8s.dev/app:2"})
+	client.SetDeploymentStatus("staging", "app", &kube.RolloutStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2})
+
+	select {
+	case r := <-results:
+		if !r.Succeeded {
+			t.Errorf("Rollout should have succeeded: %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Watcher did not report a result")
+	}
+}
+
+func TestWatcherProgressDeadline(t *testing.T) {
+	w, client := testWatcher(t, "cr.b8s.dev/app:2")
+	client.SetDeploymentStatus("staging", "app", &kube.RolloutStatus{Replicas: 2, Failed: true, Message: "exceeded its progress deadline"})
+
+	r := w.Wait(context.Background(), Target{Namespace: "staging", Deployment: "app", Image: "cr.b8s.dev/app:2"})
+	if r.Succeeded || r.Reason != "exceeded its progress deadline" {
+		t.Errorf("Rollout should have failed with the deadline message: %+v", r)
+	}
+}
+
+func TestWatcherTimeout(t *testing.T) {
+	w, _ := testWatcher(t, "cr.b8s.dev/app:2")
+	w.Timeout = 20 * time.Millisecond
+
+	r := w.Wait(context.Background(), Target{Namespace: "staging", Deployment: "app", Image: "cr.b8s.dev/app:2"})
+	if r.Succeeded {
+		t.Errorf("Rollout that never completes should fail")
+	}
+}
+
+func TestWatcherImageChanged(t *testing.T) {
+	w, _ := testWatcher(t, "cr.b8s.dev/app:3")
+
+	r := w.Wait(context.Background(), Target{Namespace: "staging", Deployment: "app", Image: "cr.b8s.dev/app:2"})
+	if r.Succeeded {
+		t.Errorf("Rollout should fail once the deployment moves to another image")
+	}
+}
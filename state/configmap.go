@@ -0,0 +1,132 @@
+package state
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const defaultPrefix = "rollingpin-state"
+
+// Labels that identify the ConfigMaps of a store's bucket.
+const (
+	prefixLabel = "rollingpin.b8s.dev/state"
+	bucketLabel = "rollingpin.b8s.dev/bucket"
+)
+
+// maxValueSize is the largest value a ConfigMap holds, leaving room under
+// the API server's 1MiB limit for the key and metadata.
+const maxValueSize = 1000 * 1024
+
+// ConfigMaps is a Store that keeps each key in its own ConfigMap, so state
+// survives restarts without a persistent volume. A bucket can hold any
+// number of keys, but each value must fit in a ConfigMap, which is limited
+// to 1MiB. Writes are retried on conflict, so several replicas can share
+// the ConfigMaps.
+type ConfigMaps struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Prefix    string
+}
+
+func NewConfigMaps(client kubernetes.Interface, namespace string, prefix string) *ConfigMaps {
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	return &ConfigMaps{Client: client, Namespace: namespace, Prefix: prefix}
+}
+
+// name returns the name of the ConfigMap for a key. Keys may contain
+// characters that names can't, so the key is hashed.
+func (c *ConfigMaps) name(bucket string, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return c.Prefix + "-" + bucket + "-" + hex.EncodeToString(sum[:16])
+}
+
+func (c *ConfigMaps) selector(bucket string) string {
+	return labels.SelectorFromSet(labels.Set{prefixLabel: c.Prefix, bucketLabel: bucket}).String()
+}
+
+func (c *ConfigMaps) Get(bucket string, key string) ([]byte, error) {
+	cm, err := c.Client.CoreV1().ConfigMaps(c.Namespace).Get(context.TODO(), c.name(bucket, key), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, ok := cm.BinaryData[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (c *ConfigMaps) Put(bucket string, key string, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if len(value) > maxValueSize {
+		return fmt.Errorf("state: value for %q is %d bytes, more than a ConfigMap can hold", key, len(value))
+	}
+	client := c.Client.CoreV1().ConfigMaps(c.Namespace)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      c.name(bucket, key),
+			Namespace: c.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "rollingpin",
+				prefixLabel:                    c.Prefix,
+				bucketLabel:                    bucket,
+			},
+		},
+		BinaryData: map[string][]byte{key: value},
+	}
+	_, err := client.Create(context.TODO(), cm, metav1.CreateOptions{})
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := client.Get(context.TODO(), cm.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		existing.BinaryData = cm.BinaryData
+		_, err = client.Update(context.TODO(), existing, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (c *ConfigMaps) Delete(bucket string, key string) error {
+	err := c.Client.CoreV1().ConfigMaps(c.Namespace).Delete(context.TODO(), c.name(bucket, key), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (c *ConfigMaps) List(bucket string) (map[string][]byte, error) {
+	cms, err := c.Client.CoreV1().ConfigMaps(c.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: c.selector(bucket)})
+	if err != nil {
+		return nil, err
+	}
+	list := make(map[string][]byte, len(cms.Items))
+	for _, cm := range cms.Items {
+		for key, value := range cm.BinaryData {
+			list[key] = value
+		}
+	}
+	return list, nil
+}
+
+func (c *ConfigMaps) Close() error {
+	return nil
+}
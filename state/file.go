@@ -0,0 +1,82 @@
+package state
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// File is a Store backed by a bbolt database file. Only one process can
+// have the file open at a time.
+type File struct {
+	db *bolt.DB
+}
+
+// OpenFile opens the database at path, creating it if it doesn't exist.
+func OpenFile(path string) (*File, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &File{db: db}, nil
+}
+
+func (f *File) Get(bucket string, key string) ([]byte, error) {
+	var value []byte
+	err := f.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return ErrNotFound
+		}
+		v := b.Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		// Values are only valid for the life of the transaction.
+		value = copyBytes(v)
+		return nil
+	})
+	return value, err
+}
+
+func (f *File) Put(bucket string, key string, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return f.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), value)
+	})
+}
+
+func (f *File) Delete(bucket string, key string) error {
+	return f.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (f *File) List(bucket string) (map[string][]byte, error) {
+	list := map[string][]byte{}
+	err := f.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			list[string(k)] = copyBytes(v)
+			return nil
+		})
+	})
+	return list, err
+}
+
+func (f *File) Close() error {
+	return f.db.Close()
+}
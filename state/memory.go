@@ -0,0 +1,64 @@
+package state
+
+import (
+	"sync"
+)
+
+// Memory is a Store that keeps everything in memory, and so loses it on
+// restart.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]map[string][]byte{}}
+}
+
+func (m *Memory) Get(bucket string, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyBytes(value), nil
+}
+
+func (m *Memory) Put(bucket string, key string, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.buckets[bucket] == nil {
+		m.buckets[bucket] = map[string][]byte{}
+	}
+	m.buckets[bucket][key] = copyBytes(value)
+	return nil
+}
+
+func (m *Memory) Delete(bucket string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.buckets[bucket], key)
+	return nil
+}
+
+func (m *Memory) List(bucket string) (map[string][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := map[string][]byte{}
+	for key, value := range m.buckets[bucket] {
+		list[key] = copyBytes(value)
+	}
+	return list, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
@@ -0,0 +1,116 @@
+// Package state persists the work rollingpin has in flight, such as queued
+// jobs and pending approvals, so that it survives a restart.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.b8s.dev/rollingpin/config"
+	"go.b8s.dev/rollingpin/kube"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Buckets group related keys, like tables.
+const (
+	BucketJobs        = "jobs"
+	BucketHistory     = "history"
+	BucketIdempotency = "idempotency"
+	BucketApprovals   = "approvals"
+	BucketApplied     = "applied"
+	BucketPauses      = "pauses"
+	BucketScans       = "scans"
+	BucketHalts       = "halts"
+	BucketPromotions  = "promotions"
+)
+
+var ErrNotFound = errors.New("state: key not found")
+
+// Store is a key-value store of opaque values grouped into buckets. Buckets
+// are created as needed. Keys may only contain alphanumerics, `-`, `_` and
+// `.`, so that every backend can store them.
+type Store interface {
+	Get(bucket string, key string) ([]byte, error)
+	Put(bucket string, key string, value []byte) error
+	Delete(bucket string, key string) error
+
+	// List returns every key and value in a bucket.
+	List(bucket string) (map[string][]byte, error)
+
+	Close() error
+}
+
+// Open returns the store described by the config. clientset is only used by
+// the `kubernetes` backend.
+func Open(conf config.StateConfig, clientset kubernetes.Interface) (Store, error) {
+	switch conf.Backend {
+	case "", "memory":
+		return NewMemory(), nil
+	case "file":
+		if conf.Path == "" {
+			return nil, errors.New("state: the file backend needs a path")
+		}
+		return OpenFile(conf.Path)
+	case "kubernetes":
+		namespace := conf.Namespace
+		if namespace == "" {
+			current, err := kube.CurrentNamespace()
+			if err != nil {
+				return nil, fmt.Errorf("state: %w", err)
+			}
+			namespace = current
+		}
+		if conf.Prefix != "" {
+			if errs := validation.IsDNS1123Label(conf.Prefix); len(errs) > 0 {
+				return nil, fmt.Errorf("state: invalid prefix %q: %s", conf.Prefix, strings.Join(errs, ", "))
+			}
+		}
+		return NewConfigMaps(clientset, namespace, conf.Prefix), nil
+	default:
+		return nil, fmt.Errorf("state: unknown backend %q", conf.Backend)
+	}
+}
+
+// PutJSON stores v encoded as JSON.
+func PutJSON(s Store, bucket string, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Put(bucket, key, b)
+}
+
+// GetJSON decodes the JSON value stored under key into v.
+func GetJSON(s Store, bucket string, key string, v interface{}) error {
+	b, err := s.Get(bucket, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// validKey reports whether a key can be stored by every backend. These are
+// the characters allowed in ConfigMap keys.
+func validKey(key string) bool {
+	if key == "" || len(key) > 253 {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func checkKey(key string) error {
+	if !validKey(key) {
+		return fmt.Errorf("state: invalid key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package state
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go.b8s.dev/rollingpin/config"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testStore(t *testing.T, s Store) {
+	if _, err := s.Get(BucketJobs, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound from an empty store, got %v", err)
+	}
+	if err := s.Put(BucketJobs, "abc", []byte("one")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := s.Put(BucketJobs, "def", []byte("two")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := s.Put(BucketApprovals, "abc", []byte("other")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := s.Put(BucketJobs, "abc", []byte("three")); err != nil {
+		t.Fatalf("Overwriting Put failed: %v", err)
+	}
+	if err := s.Put(BucketJobs, "default/app", []byte("x")); err == nil {
+		t.Errorf("Put should refuse keys with a slash")
+	}
+
+	if v, err := s.Get(BucketJobs, "abc"); err != nil || string(v) != "three" {
+		t.Errorf("Expected three, got %q %v", v, err)
+	}
+	list, err := s.List(BucketJobs)
+	if err != nil || len(list) != 2 || string(list["def"]) != "two" {
+		t.Errorf("Unexpected list: %q %v", list, err)
+	}
+
+	if err := s.Delete(BucketJobs, "abc"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := s.Delete(BucketJobs, "abc"); err != nil {
+		t.Errorf("Deleting a missing key should succeed, got %v", err)
+	}
+	if _, err := s.Get(BucketJobs, "abc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Deleted key should be gone, got %v", err)
+	}
+	if v, _ := s.Get(BucketApprovals, "abc"); string(v) != "other" {
+		t.Errorf("Buckets should be separate, got %q", v)
+	}
+	if list, _ := s.List(BucketHistory); len(list) != 0 {
+		t.Errorf("Unused bucket should be empty, got %q", list)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	testStore(t, s)
+	s.Close()
+
+	// Values survive reopening the file.
+	s, err = OpenFile(path)
+	if err != nil {
+		t.Fatalf("Reopening failed: %v", err)
+	}
+	defer s.Close()
+	if v, err := s.Get(BucketJobs, "def"); err != nil || string(v) != "two" {
+		t.Errorf("Expected value to persist, got %q %v", v, err)
+	}
+}
+
+func TestConfigMaps(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	testStore(t, NewConfigMaps(client, "rollingpin", ""))
+
+	// Another replica sees the same state.
+	other := NewConfigMaps(client, "rollingpin", "")
+	if v, err := other.Get(BucketJobs, "def"); err != nil || string(v) != "two" {
+		t.Errorf("Expected shared value, got %q %v", v, err)
+	}
+
+	// Stores with another prefix are separate.
+	if list, _ := NewConfigMaps(client, "rollingpin", "other").List(BucketJobs); len(list) != 0 {
+		t.Errorf("Stores with different prefixes should be separate, got %q", list)
+	}
+
+	// Each key has its own ConfigMap, so a bucket isn't limited to 1MiB.
+	big := make([]byte, maxValueSize)
+	for _, key := range []string{"big1", "big2"} {
+		if err := other.Put(BucketHistory, key, big); err != nil {
+			t.Fatalf("Put of a large value failed: %v", err)
+		}
+	}
+	if err := other.Put(BucketHistory, "huge", make([]byte, maxValueSize+1)); err == nil {
+		t.Errorf("Put should refuse values larger than a ConfigMap can hold")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if s, err := Open(config.StateConfig{}, nil); err != nil {
+		t.Errorf("Default backend failed: %v", err)
+	} else if _, ok := s.(*Memory); !ok {
+		t.Errorf("Default backend should be memory, got %T", s)
+	}
+	if _, err := Open(config.StateConfig{Backend: "file"}, nil); err == nil {
+		t.Errorf("File backend without a path should fail")
+	}
+	if _, err := Open(config.StateConfig{Backend: "etcd"}, nil); err == nil {
+		t.Errorf("Unknown backend should fail")
+	}
+	if s, err := Open(config.StateConfig{Backend: "kubernetes", Namespace: "rollingpin"}, fake.NewSimpleClientset()); err != nil {
+		t.Errorf("Kubernetes backend failed: %v", err)
+	} else if s.(*ConfigMaps).Prefix != "rollingpin-state" {
+		t.Errorf("Expected default prefix, got %q", s.(*ConfigMaps).Prefix)
+	}
+	if _, err := Open(config.StateConfig{Backend: "kubernetes", Namespace: "rollingpin", Prefix: "Rollingpin_State"}, fake.NewSimpleClientset()); err == nil {
+		t.Errorf("Prefix that can't be used in ConfigMap names should fail")
+	}
+}